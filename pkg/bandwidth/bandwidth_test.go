@@ -0,0 +1,118 @@
+package bandwidth
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_allocation(t *testing.T) {
+	tt := map[string]struct {
+		weight, activeWeight int
+		active               bool
+		want                 uint64
+	}{
+		"Alone":            {weight: 1, activeWeight: 1, active: true, want: 1000},
+		"Equal weights":    {weight: 1, activeWeight: 2, active: true, want: 500},
+		"Heavier":          {weight: 3, activeWeight: 4, active: true, want: 750},
+		"Lighter":          {weight: 1, activeWeight: 4, active: true, want: 250},
+		"Idle":             {weight: 1, activeWeight: 3, active: false, want: 250},
+		"Idle alone":       {weight: 2, activeWeight: 0, active: false, want: 1000},
+		"Never below one":  {weight: 1, activeWeight: 5000, active: true, want: 1},
+		"No active weight": {weight: 0, activeWeight: 0, active: true, want: 1000},
+	}
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.want, allocation(1000, tc.weight, tc.activeWeight, tc.active))
+		})
+	}
+}
+
+// waitLimit waits for the coordinator to update the allocation of a share
+func waitLimit(t *testing.T, share *Share, want uint64) {
+	t.Helper()
+	require.Eventually(t, func() bool { return share.Limit() == want }, 5*time.Second, 10*time.Millisecond)
+}
+
+func Test_Join(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "bandwidth.sock")
+
+	_, err := ReadStats(socket)
+	require.ErrorIs(t, err, ErrNoCoordinator)
+
+	first, err := Join(socket, 4000, "first", 1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4000), first.Limit())
+
+	second, err := Join(socket, 4000, "second", 3)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3000), second.Limit())
+	waitLimit(t, first, 1000)
+
+	stats, err := ReadStats(socket)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4000), stats.Budget)
+	require.Len(t, stats.Connections, 2)
+	require.Equal(t, "first", stats.Connections[0].Host)
+	require.Equal(t, 3, stats.Connections[1].Weight)
+	require.Equal(t, uint64(3000), stats.Connections[1].Limit)
+
+	require.NoError(t, second.Close())
+	waitLimit(t, first, 4000)
+	require.NoError(t, first.Close())
+
+	_, err = ReadStats(socket)
+	require.ErrorIs(t, err, ErrNoCoordinator)
+}
+
+func Test_Election(t *testing.T) {
+	retryDelay = 10 * time.Millisecond
+	defer func() { retryDelay = time.Second }()
+	socket := filepath.Join(t.TempDir(), "bandwidth.sock")
+
+	host, err := Join(socket, 3000, "host", 1)
+	require.NoError(t, err)
+	left, err := Join(socket, 3000, "left", 1)
+	require.NoError(t, err)
+	defer left.Close()
+	right, err := Join(socket, 3000, "right", 1)
+	require.NoError(t, err)
+	defer right.Close()
+	waitLimit(t, left, 1000)
+
+	// the process hosting the coordinator exits, the remaining ones elect a new coordinator
+	require.NoError(t, host.Close())
+	waitLimit(t, left, 1500)
+	waitLimit(t, right, 1500)
+
+	stats, err := ReadStats(socket)
+	require.NoError(t, err)
+	require.Len(t, stats.Connections, 2)
+}
+
+func Test_Wrap(t *testing.T) {
+	share, err := Join(filepath.Join(t.TempDir(), "bandwidth.sock"), 1024*1024, "host", 1)
+	require.NoError(t, err)
+	defer share.Close()
+
+	var written bytes.Buffer
+	reader, writer := share.Wrap(context.Background(), strings.NewReader(strings.Repeat("x", 1000)), &written)
+	read, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Len(t, read, 1000)
+	_, err = writer.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.Equal(t, "hello", written.String())
+
+	require.Eventually(t, func() bool {
+		stats, err := ReadStats(share.socket)
+		return err == nil && len(stats.Connections) == 1 &&
+			stats.Connections[0].Received == 1000 && stats.Connections[0].Sent == 5
+	}, 5*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,279 @@
+package bandwidth
+
+import (
+	"encoding/json"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// reportInterval is the interval between two usage reports of a connection
+	reportInterval = time.Second
+	// activeTimeout is the duration after which a connection not transferring data is idle,
+	// idle connections do not reduce the share of the others
+	activeTimeout = 3 * reportInterval
+	// ioTimeout bounds the exchanges with the coordinator, a stuck peer cannot block the others
+	ioTimeout = 2 * time.Second
+)
+
+// message types
+const (
+	typeRegister = "register"
+	typeUsage    = "usage"
+	typeLimit    = "limit"
+	typeStats    = "stats"
+)
+
+// message is exchanged as a JSON line between the connections and the coordinator
+type message struct {
+	Type     string
+	Host     string `json:",omitempty"`
+	PID      int    `json:",omitempty"`
+	Weight   int    `json:",omitempty"`
+	Sent     uint64 `json:",omitempty"`
+	Received uint64 `json:",omitempty"`
+	// Limit is the allowed bytes per second
+	Limit uint64 `json:",omitempty"`
+	Stats *Stats `json:",omitempty"`
+}
+
+// Stats is the current usage of the budget
+type Stats struct {
+	// Budget is the total of bytes per second shared by the connections
+	Budget uint64
+	// Since is the start of the coordinator
+	Since       time.Time
+	Connections []Connection
+}
+
+// Connection is a connection sharing the budget
+type Connection struct {
+	Host        string
+	PID         int
+	Weight      int
+	ConnectedAt time.Time
+	// Active is false when the connection did not transfer data recently
+	Active bool
+	// Limit is the allowed bytes per second
+	Limit uint64
+	// Rate is the bytes per second transferred since the previous report
+	Rate     uint64
+	Sent     uint64
+	Received uint64
+}
+
+// Coordinator allocates the budget to the registered connections
+type Coordinator struct {
+	budget   uint64
+	listener net.Listener
+	started  time.Time
+
+	mu     sync.Mutex
+	peers  map[*peer]struct{}
+	closed bool
+}
+
+type peer struct {
+	Connection
+	conn       net.Conn
+	encoder    *json.Encoder
+	lastReport time.Time
+	lastActive time.Time
+}
+
+// NewCoordinator returns a coordinator sharing budget bytes per second between the connections accepted by listener
+func NewCoordinator(listener net.Listener, budget uint64) *Coordinator {
+	return &Coordinator{
+		budget:   budget,
+		listener: listener,
+		started:  time.Now(),
+		peers:    map[*peer]struct{}{},
+	}
+}
+
+// Serve accepts connections until the coordinator is closed
+func (c *Coordinator) Serve() error {
+	for {
+		conn, err := c.listener.Accept()
+		if err != nil {
+			c.mu.Lock()
+			closed := c.closed
+			c.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		go c.handle(conn)
+	}
+}
+
+// Close stops the coordinator and disconnects the registered connections
+func (c *Coordinator) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	for p := range c.peers {
+		_ = p.conn.Close()
+	}
+	c.mu.Unlock()
+	return c.listener.Close()
+}
+
+// Stats returns the current usage of the budget
+func (c *Coordinator) Stats() *Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	stats := &Stats{Budget: c.budget, Since: c.started, Connections: []Connection{}}
+	for p := range c.peers {
+		connection := p.Connection
+		connection.Active = p.active(now)
+		stats.Connections = append(stats.Connections, connection)
+	}
+	sort.Slice(stats.Connections, func(i, j int) bool {
+		if stats.Connections[i].ConnectedAt.Equal(stats.Connections[j].ConnectedAt) {
+			return stats.Connections[i].PID < stats.Connections[j].PID
+		}
+		return stats.Connections[i].ConnectedAt.Before(stats.Connections[j].ConnectedAt)
+	})
+	return stats
+}
+
+func (c *Coordinator) handle(conn net.Conn) {
+	defer conn.Close()
+
+	decoder := json.NewDecoder(conn)
+	var msg message
+	if err := decoder.Decode(&msg); err != nil {
+		logger().Debug("invalid bandwidth message", zap.Error(err))
+		return
+	}
+	switch msg.Type {
+	case typeStats:
+		_ = conn.SetWriteDeadline(time.Now().Add(ioTimeout))
+		if err := json.NewEncoder(conn).Encode(message{Type: typeStats, Stats: c.Stats()}); err != nil {
+			logger().Debug("failed to send bandwidth stats", zap.Error(err))
+		}
+		return
+	case typeRegister:
+	default:
+		logger().Debug("unexpected bandwidth message", zap.String("type", msg.Type))
+		return
+	}
+
+	now := time.Now()
+	p := &peer{
+		Connection: Connection{
+			Host:        msg.Host,
+			PID:         msg.PID,
+			Weight:      msg.Weight,
+			ConnectedAt: now,
+			Sent:        msg.Sent,
+			Received:    msg.Received,
+		},
+		conn:       conn,
+		encoder:    json.NewEncoder(conn),
+		lastReport: now,
+		lastActive: now,
+	}
+	if p.Weight < 1 {
+		p.Weight = 1
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.peers[p] = struct{}{}
+	c.allocate(now)
+	c.mu.Unlock()
+	logger().Debug("connection sharing the bandwidth budget", zap.String("host", p.Host), zap.Int("pid", p.PID))
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.peers, p)
+		c.allocate(time.Now())
+		c.mu.Unlock()
+	}()
+
+	for {
+		var msg message
+		if err := decoder.Decode(&msg); err != nil {
+			return
+		}
+		if msg.Type != typeUsage {
+			continue
+		}
+		now := time.Now()
+		c.mu.Lock()
+		p.report(msg, now)
+		c.allocate(now)
+		c.mu.Unlock()
+	}
+}
+
+// allocate shares the budget between the active connections, proportionally to their weight.
+// An idle connection is allocated the share it would have if it was active,
+// so it is not throttled when it starts transferring data again.
+// The caller must hold the lock.
+func (c *Coordinator) allocate(now time.Time) {
+	activeWeight := 0
+	for p := range c.peers {
+		if p.active(now) {
+			activeWeight += p.Weight
+		}
+	}
+	for p := range c.peers {
+		limit := allocation(c.budget, p.Weight, activeWeight, p.active(now))
+		if limit == p.Limit {
+			continue
+		}
+		p.Limit = limit
+		_ = p.conn.SetWriteDeadline(time.Now().Add(ioTimeout))
+		if err := p.encoder.Encode(message{Type: typeLimit, Limit: limit}); err != nil {
+			logger().Debug("failed to send bandwidth limit", zap.String("host", p.Host), zap.Error(err))
+			_ = p.conn.Close()
+		}
+	}
+}
+
+// allocation returns the bytes per second allocated to a connection
+func allocation(budget uint64, weight int, activeWeight int, active bool) uint64 {
+	total := activeWeight
+	if !active {
+		total += weight
+	}
+	if total < 1 {
+		return budget
+	}
+	limit := budget * uint64(weight) / uint64(total)
+	if limit < 1 {
+		return 1
+	}
+	return limit
+}
+
+func (p *peer) active(now time.Time) bool {
+	return now.Sub(p.lastActive) < activeTimeout
+}
+
+// report updates the statistics of the peer with its total of transferred bytes
+func (p *peer) report(msg message, now time.Time) {
+	var transferred uint64
+	if total, previous := msg.Sent+msg.Received, p.Sent+p.Received; total > previous {
+		transferred = total - previous
+	}
+	if elapsed := now.Sub(p.lastReport); elapsed > 0 {
+		p.Rate = uint64(float64(transferred) / elapsed.Seconds())
+	}
+	if transferred > 0 {
+		p.lastActive = now
+	}
+	p.Sent, p.Received, p.lastReport = msg.Sent, msg.Received, now
+}
@@ -0,0 +1,4 @@
+// Package bandwidth shares a global bandwidth budget between the connections of concurrent assh processes.
+// One of the processes hosts a coordinator listening on a unix socket, the others register their connections
+// to it and receive the part of the budget they are allowed to use, proportional to their weight.
+package bandwidth // import "moul.io/assh/v2/pkg/bandwidth"
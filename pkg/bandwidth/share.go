@@ -0,0 +1,296 @@
+package bandwidth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+	"moul.io/assh/v2/pkg/logfile"
+	"moul.io/assh/v2/pkg/ratelimit"
+)
+
+// ErrNoCoordinator is returned when no connection is currently sharing the budget
+var ErrNoCoordinator = errors.New("no connection is sharing the bandwidth budget")
+
+// retryDelay is the delay before reconnecting to a new coordinator, once the previous one is gone
+var retryDelay = time.Second
+
+// Share is the part of the budget allocated to a connection.
+// The first connection starts a coordinator in its process, when it ends
+// the remaining connections elect a new one.
+type Share struct {
+	socket   string
+	budget   uint64
+	register message
+	limiter  *rate.Limiter
+
+	sent     uint64
+	received uint64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu          sync.Mutex
+	coordinator *Coordinator
+}
+
+// Join registers a connection to host with weight to the coordinator listening on socket,
+// starting one sharing budget bytes per second if none is running
+func Join(socket string, budget uint64, host string, weight int) (*Share, error) {
+	if budget == 0 {
+		return nil, errors.New("the bandwidth budget must be positive")
+	}
+	s := &Share{
+		socket:   socket,
+		budget:   budget,
+		register: message{Type: typeRegister, Host: host, PID: os.Getpid(), Weight: weight},
+		limiter:  rate.NewLimiter(rate.Limit(budget), burst(budget)),
+		done:     make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	conn, decoder, err := s.connect()
+	if err != nil {
+		s.cancel()
+		s.closeCoordinator()
+		return nil, err
+	}
+	go s.run(conn, decoder)
+	return s, nil
+}
+
+// Wrap returns a reader and a writer limited by the share, the transferred bytes are reported to the coordinator
+func (s *Share) Wrap(ctx context.Context, r io.Reader, w io.Writer) (io.Reader, io.Writer) {
+	reader := ratelimit.NewReaderWithContext(ctx, &countingReader{r: r, count: &s.received}, s.limiter)
+	writer := ratelimit.NewWriterWithContext(ctx, &countingWriter{w: w, count: &s.sent}, s.limiter)
+	return reader, writer
+}
+
+// Limit returns the bytes per second currently allocated to the connection
+func (s *Share) Limit() uint64 {
+	return uint64(s.limiter.Limit())
+}
+
+// Close unregisters the connection, stopping the coordinator if it is hosted by this share
+func (s *Share) Close() error {
+	s.cancel()
+	<-s.done
+	return s.closeCoordinator()
+}
+
+func (s *Share) closeCoordinator() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.coordinator == nil {
+		return nil
+	}
+	err := s.coordinator.Close()
+	s.coordinator = nil
+	return err
+}
+
+// dial connects to the coordinator, starting one if none is running
+func (s *Share) dial() (net.Conn, error) {
+	if conn, err := net.DialTimeout("unix", s.socket, ioTimeout); err == nil {
+		return conn, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.socket), 0o700); err != nil {
+		return nil, err
+	}
+	unlock, err := logfile.Lock(s.socket+".lock", 0o600)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// another process may have won the election meanwhile
+	if conn, err := net.DialTimeout("unix", s.socket, ioTimeout); err == nil {
+		return conn, nil
+	}
+	// the socket of a coordinator that did not exit cleanly
+	if err := os.Remove(s.socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", s.socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(s.socket, 0o600); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	coordinator := NewCoordinator(listener, s.budget)
+	go func() {
+		if err := coordinator.Serve(); err != nil {
+			logger().Warn("bandwidth coordinator stopped", zap.Error(err))
+		}
+	}()
+	s.mu.Lock()
+	s.coordinator = coordinator
+	s.mu.Unlock()
+	logger().Debug("started the bandwidth coordinator", zap.String("socket", s.socket))
+
+	return net.DialTimeout("unix", s.socket, ioTimeout)
+}
+
+// connect registers the connection to the coordinator and waits for its first allocation
+func (s *Share) connect() (net.Conn, *json.Decoder, error) {
+	conn, err := s.dial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	register := s.register
+	register.Sent, register.Received = atomic.LoadUint64(&s.sent), atomic.LoadUint64(&s.received)
+	_ = conn.SetDeadline(time.Now().Add(ioTimeout))
+	if err := json.NewEncoder(conn).Encode(register); err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	decoder := json.NewDecoder(conn)
+	var msg message
+	if err := decoder.Decode(&msg); err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	if msg.Type != typeLimit {
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("unexpected bandwidth message %q", msg.Type)
+	}
+	s.setLimit(msg.Limit)
+	_ = conn.SetDeadline(time.Time{})
+	return conn, decoder, nil
+}
+
+// run reports the usage to the coordinator until the share is closed, reconnecting when the coordinator is gone.
+// While disconnected, the last allocation is kept.
+func (s *Share) run(conn net.Conn, decoder *json.Decoder) {
+	defer close(s.done)
+	for {
+		s.serve(conn, decoder)
+		_ = conn.Close()
+
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-time.After(retryDelay):
+			}
+			var err error
+			if conn, decoder, err = s.connect(); err == nil {
+				break
+			}
+			logger().Debug("failed to reach the bandwidth coordinator", zap.Error(err))
+		}
+	}
+}
+
+func (s *Share) serve(conn net.Conn, decoder *json.Decoder) {
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			var msg message
+			if err := decoder.Decode(&msg); err != nil {
+				errs <- err
+				return
+			}
+			if msg.Type == typeLimit {
+				s.setLimit(msg.Limit)
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(reportInterval)
+	defer ticker.Stop()
+	encoder := json.NewEncoder(conn)
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case err := <-errs:
+			logger().Debug("lost the bandwidth coordinator", zap.Error(err))
+			return
+		case <-ticker.C:
+			usage := message{Type: typeUsage, Sent: atomic.LoadUint64(&s.sent), Received: atomic.LoadUint64(&s.received)}
+			_ = conn.SetWriteDeadline(time.Now().Add(ioTimeout))
+			if err := encoder.Encode(usage); err != nil {
+				logger().Debug("failed to report the bandwidth usage", zap.Error(err))
+				return
+			}
+		}
+	}
+}
+
+func (s *Share) setLimit(limit uint64) {
+	s.limiter.SetLimit(rate.Limit(limit))
+	s.limiter.SetBurst(burst(limit))
+}
+
+// burst allows one second of traffic at once
+func burst(limit uint64) int {
+	switch {
+	case limit < 1:
+		return 1
+	case limit > math.MaxInt32:
+		return math.MaxInt32
+	default:
+		return int(limit)
+	}
+}
+
+// ReadStats returns the current usage of the budget from the coordinator listening on socket
+func ReadStats(socket string) (*Stats, error) {
+	conn, err := net.DialTimeout("unix", socket, ioTimeout)
+	if err != nil {
+		return nil, ErrNoCoordinator
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(ioTimeout))
+	if err := json.NewEncoder(conn).Encode(message{Type: typeStats}); err != nil {
+		return nil, err
+	}
+	var msg message
+	if err := json.NewDecoder(conn).Decode(&msg); err != nil {
+		return nil, err
+	}
+	if msg.Type != typeStats || msg.Stats == nil {
+		return nil, fmt.Errorf("unexpected bandwidth message %q", msg.Type)
+	}
+	return msg.Stats, nil
+}
+
+type countingReader struct {
+	r     io.Reader
+	count *uint64
+}
+
+func (r *countingReader) Read(buf []byte) (int, error) {
+	n, err := r.r.Read(buf)
+	atomic.AddUint64(r.count, uint64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	w     io.Writer
+	count *uint64
+}
+
+func (w *countingWriter) Write(buf []byte) (int, error) {
+	n, err := w.w.Write(buf)
+	atomic.AddUint64(w.count, uint64(n))
+	return n, err
+}
@@ -0,0 +1,126 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"moul.io/assh/v2/pkg/bandwidth"
+	"moul.io/assh/v2/pkg/config"
+)
+
+var statsBandwidthCommand = &cobra.Command{
+	Use:   "bandwidth",
+	Short: "Display the current usage of the bandwidth budget shared by the connections",
+	RunE:  runStatsBandwidthCommand,
+}
+
+// nolint:gochecknoinits
+func init() {
+	statsBandwidthCommand.Flags().StringP("format", "", "text", "Output format: 'text' or 'json'")
+	statsCommand.AddCommand(statsBandwidthCommand)
+}
+
+// joinBandwidthBudget registers the connection to the global bandwidth budget, nil if no budget is configured.
+// failures are only logged as they should never prevent a connection
+func joinBandwidthBudget(conf *config.Config, host *config.Host) *bandwidth.Share {
+	if conf.BandwidthBudget == "" {
+		return nil
+	}
+	budget, err := humanize.ParseBytes(conf.BandwidthBudget)
+	if err != nil {
+		logger().Warn("Invalid bandwidth budget", zap.String("budget", conf.BandwidthBudget), zap.Error(err))
+		return nil
+	}
+	socket, err := conf.BandwidthSocket()
+	if err != nil {
+		logger().Warn("Cannot get the bandwidth socket path", zap.Error(err))
+		return nil
+	}
+	if socket == "" {
+		logger().Debug("The bandwidth budget is not shared (asshbandwidthsocket: none)")
+		return nil
+	}
+	share, err := bandwidth.Join(socket, budget, host.Name(), host.BandwidthWeight)
+	if err != nil {
+		logger().Warn("Cannot share the bandwidth budget", zap.String("socket", socket), zap.Error(err))
+		return nil
+	}
+	logger().Debug("Sharing the bandwidth budget", zap.String("host", host.Name()), zap.Uint64("limit", share.Limit()))
+	return share
+}
+
+func runStatsBandwidthCommand(cmd *cobra.Command, args []string) error {
+	conf, err := config.Open(viper.GetString("config"))
+	if err != nil {
+		return errors.Wrap(err, "failed to load config")
+	}
+	if conf.BandwidthBudget == "" {
+		return errors.New("no bandwidth budget configured (bandwidthbudget)")
+	}
+	socket, err := conf.BandwidthSocket()
+	if err != nil {
+		return errors.Wrap(err, "failed to get the bandwidth socket path")
+	}
+	if socket == "" {
+		return errors.New("the bandwidth budget is not shared (asshbandwidthsocket: none)")
+	}
+
+	stats, err := bandwidth.ReadStats(socket)
+	if errors.Is(err, bandwidth.ErrNoCoordinator) {
+		stats = &bandwidth.Stats{Connections: []bandwidth.Connection{}}
+	} else if err != nil {
+		return errors.Wrap(err, "failed to read the bandwidth usage")
+	}
+
+	format, _ := cmd.Flags().GetString("format")
+	switch format {
+	case "text":
+		return printBandwidthStats(stats)
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(stats)
+	default:
+		return fmt.Errorf("invalid output format %q, should be 'text' or 'json'", format)
+	}
+}
+
+func printBandwidthStats(stats *bandwidth.Stats) error {
+	if len(stats.Connections) == 0 {
+		fmt.Println("no connection is sharing the bandwidth budget.")
+		return nil
+	}
+
+	var used uint64
+	for _, connection := range stats.Connections {
+		used += connection.Rate
+	}
+	fmt.Printf(
+		"budget: %s/s, used: %s/s, coordinator started %s\n\n",
+		humanize.Bytes(stats.Budget), humanize.Bytes(used), humanize.Time(stats.Since),
+	)
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "HOST\tPID\tWEIGHT\tSTATUS\tLIMIT\tRATE\tSENT\tRECEIVED\tCONNECTED")
+	for _, connection := range stats.Connections {
+		status := "idle"
+		if connection.Active {
+			status = "active"
+		}
+		fmt.Fprintf(
+			writer, "%s\t%d\t%d\t%s\t%s/s\t%s/s\t%s\t%s\t%s\n",
+			connection.Host, connection.PID, connection.Weight, status,
+			humanize.Bytes(connection.Limit), humanize.Bytes(connection.Rate),
+			humanize.Bytes(connection.Sent), humanize.Bytes(connection.Received),
+			humanize.Time(connection.ConnectedAt),
+		)
+	}
+	return writer.Flush()
+}
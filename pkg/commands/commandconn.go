@@ -0,0 +1,180 @@
+package commands
+
+import (
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	shlex "github.com/flynn/go-shlex"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"moul.io/assh/v2/pkg/config"
+)
+
+// commandConn is a net.Conn using the standard input and output of a command,
+// i.e: a ProxyCommand or a 'ssh -W' command going through a gateway
+type commandConn struct {
+	command   string
+	cmd       *exec.Cmd
+	stdin     *os.File
+	stdout    *os.File
+	closeOnce sync.Once
+	// pending is the output read by waitStarted, returned by the next reads
+	pending []byte
+	// watchdog kills the command if it does not answer in time, it is stopped by the first read
+	watchdog *time.Timer
+}
+
+// dialCommand starts a command expanded against host and returns a connection to its standard input and output
+func dialCommand(host *config.Host, command string, stderr io.Writer) (*commandConn, error) {
+	command = host.ExpandString(command, "")
+	logger().Debug("Dialing command", zap.String("command", command))
+	args, err := shlex.Split(command)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, errors.New("empty command")
+	}
+
+	// pipes are created manually (instead of using cmd.StdoutPipe)
+	// to support read and write deadlines
+	stdinReader, stdinWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		_ = stdinReader.Close()
+		_ = stdinWriter.Close()
+		return nil, err
+	}
+
+	spawn := exec.Command(args[0], args[1:]...) // #nosec
+	spawn.Stdin = stdinReader
+	spawn.Stdout = stdoutWriter
+	spawn.Stderr = stderr
+	err = spawn.Start()
+
+	// the child ends of the pipes are not used by the current process
+	_ = stdinReader.Close()
+	_ = stdoutWriter.Close()
+	if err != nil {
+		_ = stdinWriter.Close()
+		_ = stdoutReader.Close()
+		return nil, err
+	}
+
+	return &commandConn{
+		command: command,
+		cmd:     spawn,
+		stdin:   stdinWriter,
+		stdout:  stdoutReader,
+	}, nil
+}
+
+func (c *commandConn) Read(b []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(b, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	n, err := c.stdout.Read(b)
+	if n > 0 && c.watchdog != nil {
+		c.watchdog.Stop()
+	}
+	return n, err
+}
+
+func (c *commandConn) Write(b []byte) (int, error) { return c.stdin.Write(b) }
+
+// commandStartGrace is the time a command is given to exit or to answer before it is used: a command exiting
+// within it did not reach its target, i.e: an ssh gateway which is down
+var commandStartGrace = 500 * time.Millisecond
+
+// waitStarted waits for the first output of the command, its exit or commandStartGrace: the command is running as
+// soon as it is spawned, but it did not necessarily reach its target. The targets may wait for the client to talk
+// first, so a command still running after commandStartGrace is used; it is killed if it does not answer within
+// timeout, zero disables the timeout.
+func (c *commandConn) waitStarted(timeout time.Duration) error {
+	grace := commandStartGrace
+	if timeout > 0 && timeout < grace {
+		grace = timeout
+	}
+	start := time.Now()
+	_ = c.stdout.SetReadDeadline(start.Add(grace))
+	buf := make([]byte, 32*1024)
+	n, err := c.stdout.Read(buf)
+	_ = c.stdout.SetReadDeadline(time.Time{})
+	switch {
+	case n > 0:
+		c.pending = buf[:n]
+		return nil
+	case errors.Is(err, os.ErrDeadlineExceeded) && grace == timeout:
+		_ = c.Close()
+		return errors.Errorf("no answer within %s", timeout)
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		if timeout > 0 {
+			c.killUnanswered(timeout - time.Since(start))
+		}
+		return nil
+	}
+
+	// the output is closed, the command exited
+	err = c.cmd.Wait()
+	_ = c.Close()
+	if err != nil {
+		return errors.Wrap(err, "the command exited without answering")
+	}
+	return errors.New("the command exited without answering")
+}
+
+// killUnanswered kills the command if it does not answer within timeout, without waiting for its answer: the
+// protocols where the client talks first cannot wait for it
+func (c *commandConn) killUnanswered(timeout time.Duration) {
+	c.watchdog = time.AfterFunc(timeout, func() { _ = c.Close() })
+}
+
+// CloseWrite closes the standard input of the command, it can still be read until it exits
+func (c *commandConn) CloseWrite() error {
+	return c.stdin.Close()
+}
+
+// Close closes the standard input of the command, then kills it
+func (c *commandConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		// the standard input may already be closed by CloseWrite
+		if err = c.stdin.Close(); errors.Is(err, os.ErrClosed) {
+			err = nil
+		}
+		if c.cmd.Process != nil {
+			_ = c.cmd.Process.Kill()
+		}
+		_ = c.cmd.Wait()
+		_ = c.stdout.Close()
+	})
+	return err
+}
+
+func (c *commandConn) LocalAddr() net.Addr  { return commandAddr("local") }
+func (c *commandConn) RemoteAddr() net.Addr { return commandAddr(c.command) }
+
+func (c *commandConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *commandConn) SetReadDeadline(t time.Time) error  { return c.stdout.SetReadDeadline(t) }
+func (c *commandConn) SetWriteDeadline(t time.Time) error { return c.stdin.SetWriteDeadline(t) }
+
+// commandAddr is the net.Addr of a commandConn
+type commandAddr string
+
+func (a commandAddr) Network() string { return "command" }
+func (a commandAddr) String() string  { return string(a) }
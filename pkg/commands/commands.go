@@ -20,6 +20,13 @@ import (
 var commands = []*cobra.Command{
 	pingCommand,
 	proxyCommand,
+	relayCommand,
+	socksCommand,
+	statsCommand,
+	historyCommand,
+	lastCommand,
+	recordingsCommand,
+	hooksCommand,
 	infoCommand,
 	configCommand,
 	socketsCommand,
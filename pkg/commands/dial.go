@@ -0,0 +1,95 @@
+package commands
+
+import (
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"moul.io/assh/v2/pkg/config"
+	"moul.io/assh/v2/pkg/upstream"
+)
+
+// dialGateways connects to host through its first available gateway, or directly if it has none, and returns the
+// connection with the gateway used; the hostname of host is resolved, and the attempts are retried, as it is done by
+// 'assh connect'
+func dialGateways(host *config.Host, conf *config.Config, connectTimeout time.Duration, stderr io.Writer) (net.Conn, string, error) {
+	policy, err := newRetryPolicy(host, time.Now())
+	if err != nil {
+		return nil, "", err
+	}
+	gateways := host.Gateways
+	if len(gateways) == 0 {
+		gateways = []string{"direct"}
+	}
+
+	errs := []string{}
+	for _, gateway := range gateways {
+		conn, err := dialPreparedGateway(host, conf, gateway, connectTimeout, stderr, policy)
+		if err == nil {
+			return conn, upstream.Redact(gateway), nil
+		}
+		logger().Debug("Failed to use gateway", zap.String("gateway", upstream.Redact(gateway)), zap.Error(err))
+		errs = append(errs, upstream.Redact(gateway)+": "+err.Error())
+	}
+	return nil, "", errors.Errorf("no such available gateway (%s)", strings.Join(errs, ", "))
+}
+
+// dialPreparedGateway resolves the hostname of host before reaching it through gateway, the ssh gateways resolve it
+// by themselves; a direct connection uses the ProxyCommand of host if any
+func dialPreparedGateway(host *config.Host, conf *config.Config, gateway string, connectTimeout time.Duration, stderr io.Writer, policy *retryPolicy) (net.Conn, error) {
+	step := retryStepGateway
+	if gateway == "direct" || upstream.IsProxy(gateway) {
+		host = host.Clone()
+		if err := hostPrepare(host, "", policy); err != nil {
+			return nil, errors.Wrap(err, "failed to prepare host")
+		}
+		if gateway != "direct" || host.ProxyCommand == "" {
+			step = retryStepDial
+		}
+	}
+
+	var conn net.Conn
+	err := policy.run(step, gateway, connectTimeout, func(timeout time.Duration) error {
+		var err error
+		if gateway == "direct" && host.ProxyCommand != "" {
+			conn, err = dialCommandConn(host, host.ProxyCommand, stderr)
+		} else {
+			conn, err = dialGateway(host, conf, gateway, timeout, stderr, policy)
+		}
+		if command, ok := conn.(*commandConn); ok && err == nil {
+			// an ssh gateway or a ProxyCommand is running once spawned, it may exit without reaching the host
+			err = command.waitStarted(timeout)
+		}
+		return err
+	})
+	return conn, err
+}
+
+// dialGateway connects to host through gateway: "direct", an upstream proxy or an ssh gateway; the hostname of host
+// must already be resolved for the direct connections and the upstream proxies
+func dialGateway(host *config.Host, conf *config.Config, gateway string, connectTimeout time.Duration, stderr io.Writer, policy *retryPolicy) (net.Conn, error) {
+	address := net.JoinHostPort(host.HostName, host.Port)
+	if gateway == "direct" {
+		return net.DialTimeout("tcp", address, connectTimeout)
+	}
+	if upstream.IsProxy(gateway) {
+		return upstream.Dial(gateway, address, connectTimeout)
+	}
+	command, _, err := gatewayCommand(host, gateway, policy)
+	if err != nil {
+		return nil, err
+	}
+	return dialCommandConn(conf.GetGatewaySafe(gateway), command, stderr)
+}
+
+// dialCommandConn is dialCommand returning a nil net.Conn on error
+func dialCommandConn(host *config.Host, command string, stderr io.Writer) (net.Conn, error) {
+	conn, err := dialCommand(host, command, stderr)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
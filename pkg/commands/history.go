@@ -0,0 +1,164 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"moul.io/assh/v2/pkg/config"
+	"moul.io/assh/v2/pkg/history"
+	"moul.io/assh/v2/pkg/logfile"
+)
+
+// the history file is rotated once it reaches historyMaxSize, historyMaxBackups rotated files are kept
+const (
+	historyMaxSize    = 10 * 1024 * 1024
+	historyMaxBackups = 3
+)
+
+var historyCommand = &cobra.Command{
+	Use:   "history",
+	Short: "List the connection attempts, optionally filtered by host pattern",
+	RunE:  runHistoryCommand,
+}
+
+var lastCommand = &cobra.Command{
+	Use:   "last",
+	Short: "List the last connection attempt of each host, the most recent first",
+	RunE:  runLastCommand,
+}
+
+// nolint:gochecknoinits
+func init() {
+	// the flags are not bound to viper, they are shared by both commands
+	for _, cmd := range []*cobra.Command{historyCommand, lastCommand} {
+		cmd.Flags().DurationP("since", "", 0, "Only list the attempts more recent than the duration, i.e: 24h")
+		cmd.Flags().StringP("gateway", "", "", "Only list the attempts using the gateway")
+		cmd.Flags().BoolP("failed", "", false, "Only list the failed attempts")
+		cmd.Flags().IntP("limit", "n", 0, "Maximum number of attempts listed, the most recent are kept")
+		cmd.Flags().BoolP("json", "", false, "Print the attempts as JSON")
+	}
+}
+
+// historyLog returns the connection attempts log, nil if disabled
+func historyLog(conf *config.Config) (*logfile.File, error) {
+	path, err := conf.HistoryFile()
+	if err != nil || path == "" {
+		return nil, err
+	}
+	return &logfile.File{Path: path, MaxSize: historyMaxSize, MaxBackups: historyMaxBackups}, nil
+}
+
+// saveConnectionAttempt appends a connection attempt to the history,
+// failures are only logged as they should never prevent a connection
+func saveConnectionAttempt(conf *config.Config, input string, host *config.Host, gateway string, start time.Time, err error) {
+	log, logErr := historyLog(conf)
+	if logErr != nil {
+		logger().Warn("Cannot get the history file path", zap.Error(logErr))
+		return
+	}
+	if log == nil {
+		return
+	}
+
+	entry := history.Entry{
+		Time:     start,
+		Input:    input,
+		HostName: host.HostName,
+		Port:     host.Port,
+		Gateways: redactGateways(host.Gateways),
+		Gateway:  gateway,
+		Outcome:  history.Success,
+		Duration: time.Since(start),
+	}
+	if err != nil {
+		entry.Outcome = history.Failure
+		entry.Error = err.Error()
+	}
+	if err := history.Append(log, entry); err != nil {
+		logger().Warn("Cannot save connection attempt", zap.String("file", log.Path), zap.Error(err))
+	}
+}
+
+// readHistory returns the history entries matching the command flags and arguments
+func readHistory(cmd *cobra.Command, args []string) ([]history.Entry, error) {
+	conf, err := config.Open(viper.GetString("config"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load config")
+	}
+	log, err := historyLog(conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the history file path")
+	}
+	if log == nil {
+		return nil, errors.New("the connection history is disabled (asshhistoryfile: none)")
+	}
+	entries, err := history.Read(log)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the history")
+	}
+
+	filter := history.Filter{}
+	if len(args) > 0 {
+		filter.Host = args[0]
+	}
+	if since, _ := cmd.Flags().GetDuration("since"); since > 0 {
+		filter.Since = time.Now().Add(-since)
+	}
+	filter.Gateway, _ = cmd.Flags().GetString("gateway")
+	filter.Failed, _ = cmd.Flags().GetBool("failed")
+	return history.Select(entries, filter), nil
+}
+
+func runHistoryCommand(cmd *cobra.Command, args []string) error {
+	entries, err := readHistory(cmd, args)
+	if err != nil {
+		return err
+	}
+	if limit, _ := cmd.Flags().GetInt("limit"); limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	return printHistory(cmd, entries)
+}
+
+func runLastCommand(cmd *cobra.Command, args []string) error {
+	entries, err := readHistory(cmd, args)
+	if err != nil {
+		return err
+	}
+	entries = history.Last(entries)
+	if limit, _ := cmd.Flags().GetInt("limit"); limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return printHistory(cmd, entries)
+}
+
+func printHistory(cmd *cobra.Command, entries []history.Entry) error {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("no connection attempt found.")
+		return nil
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "TIME\tHOST\tHOSTNAME\tPATH\tOUTCOME\tDURATION\tERROR")
+	for _, entry := range entries {
+		fmt.Fprintf(
+			writer, "%s\t%s\t%s\t%s\t%s\t%v\t%s\n",
+			entry.Time.Local().Format("2006-01-02 15:04:05"), entry.Input,
+			fmt.Sprintf("%s:%s", entry.HostName, entry.Port), entry.Path(),
+			entry.Outcome, entry.Duration.Round(time.Second), entry.Error,
+		)
+	}
+	return writer.Flush()
+}
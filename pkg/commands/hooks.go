@@ -0,0 +1,232 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"moul.io/assh/v2/pkg/config"
+	"moul.io/assh/v2/pkg/hooks"
+	"moul.io/assh/v2/pkg/upstream"
+)
+
+var hooksCommand = &cobra.Command{
+	Use:   "hooks",
+	Short: "Inspect and test the hooks",
+}
+
+var hooksListCommand = &cobra.Command{
+	Use:   "list",
+	Short: "List the effective hooks of each host, optionally filtered by host pattern",
+	RunE:  runHooksListCommand,
+}
+
+var hooksTestCommand = &cobra.Command{
+	Use:   "test <host> <event>",
+	Short: "Render the hooks of an event with realistic arguments, without connecting",
+	RunE:  runHooksTestCommand,
+}
+
+// nolint:gochecknoinits
+func init() {
+	// the flags are not bound to viper, to not collide with the flags of the other commands
+	hooksListCommand.Flags().BoolP("json", "", false, "Print the hooks as JSON")
+	hooksTestCommand.Flags().BoolP("exec", "", false, "Run the hooks after rendering them")
+	hooksTestCommand.Flags().BoolP("args", "", false, "Print the arguments given to the hooks as JSON")
+	hooksCommand.AddCommand(hooksListCommand, hooksTestCommand)
+}
+
+func runHooksListCommand(cmd *cobra.Command, args []string) error {
+	conf, err := config.Open(viper.GetString("config"))
+	if err != nil {
+		return errors.Wrap(err, "failed to load config")
+	}
+
+	// the hooks of the hosts after the inheritance and the defaults
+	hostsHooks := map[string]*config.HostHooks{}
+	names := []string{}
+	for _, host := range conf.Hosts.SortedList() {
+		if len(args) > 0 {
+			if matched, _ := path.Match(args[0], host.Name()); !matched {
+				continue
+			}
+		}
+		computed, err := conf.GetHost(host.Name())
+		if err != nil {
+			return errors.Wrapf(err, "failed to compute host %q", host.Name())
+		}
+		if computed.Hooks.Length() == 0 {
+			continue
+		}
+		hostsHooks[host.Name()] = computed.Hooks
+		names = append(names, host.Name())
+	}
+
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(hostsHooks)
+	}
+	if len(names) == 0 {
+		fmt.Println("no hook.")
+		return nil
+	}
+	for _, name := range names {
+		fmt.Println(name)
+		for _, event := range hostsHooks[name].Events() {
+			if len(event.Hooks) == 0 {
+				continue
+			}
+			fmt.Printf("  %s:\n", event.Name)
+			for _, hook := range event.Hooks {
+				fmt.Printf("    - %s\n", hookDefinition(hook))
+			}
+		}
+	}
+	return nil
+}
+
+// hookDefinition returns the expression of a hook, or the JSON of its map
+func hookDefinition(hook hooks.Hook) string {
+	if hook.Expr != "" {
+		return hook.Expr
+	}
+	definition, err := json.Marshal(hook)
+	if err != nil {
+		return hook.String()
+	}
+	return string(definition)
+}
+
+func runHooksTestCommand(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		return errors.New("assh: \"hooks test\" requires 2 arguments. See 'assh hooks test --help'")
+	}
+
+	conf, err := config.Open(viper.GetString("config"))
+	if err != nil {
+		return errors.Wrap(err, "failed to load config")
+	}
+	host := conf.GetHostSafe(args[0])
+	event, ok := host.Hooks.Event(args[1])
+	if !ok {
+		names := []string{}
+		for _, event := range host.Hooks.Events() {
+			names = append(names, event.Name)
+		}
+		return fmt.Errorf("no such event %q, expected one of %s", args[1], strings.Join(names, ", "))
+	}
+
+	hookArgs := hookTestArgs(conf, host, event.Name)
+	if printArgs, _ := cmd.Flags().GetBool("args"); printArgs {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(hookArgs); err != nil {
+			return err
+		}
+	}
+	if len(event.Hooks) == 0 {
+		fmt.Printf("no %s hook for %s.\n", event.Name, host.Name())
+		return nil
+	}
+
+	fmt.Printf("%s hooks of %s:\n", event.Name, host.Name())
+	failed := false
+	for _, result := range event.Hooks.DryRunAll(event.Name, hookArgs) {
+		fmt.Printf("  - %s\n", result.Hook)
+		switch {
+		case result.Err != nil:
+			failed = true
+			fmt.Printf("    error: %v\n", result.Err)
+		case result.Skipped != "":
+			fmt.Printf("    skipped: %s\n", result.Skipped)
+		default:
+			for _, line := range strings.Split(strings.TrimRight(result.Output, "\n"), "\n") {
+				fmt.Printf("    %s\n", line)
+			}
+		}
+	}
+
+	if run, _ := cmd.Flags().GetBool("exec"); run {
+		fmt.Printf("running the %s hooks of %s\n", event.Name, host.Name())
+		drivers, err := event.Hooks.InvokeAll(event.Name, hookArgs)
+		if err != nil {
+			return errors.Wrapf(err, "%s hook failed", event.Name)
+		}
+		for _, err := range drivers.Close() {
+			logger().Warn(fmt.Sprintf("Failed to close a %s hook: %v", event.Name, err))
+		}
+	}
+	if failed {
+		return fmt.Errorf("some %s hooks cannot be rendered", event.Name)
+	}
+	return nil
+}
+
+// hookTestArgs returns realistic arguments of the hooks of an event, with synthetic statistics and errors
+func hookTestArgs(conf *config.Config, host *config.Host, event string) hooks.RunArgs {
+	now := time.Now()
+	gateway := "direct"
+	if len(host.Gateways) > 0 {
+		gateway = upstream.Redact(host.Gateways[0])
+	}
+	stats := &ConnectionStats{CreatedAt: now.Add(-5*time.Minute - 50*time.Millisecond), Gateway: gateway}
+	dialError := fmt.Sprintf("dial tcp %s: connect: connection refused", net.JoinHostPort(host.HostName, host.Port))
+
+	switch event {
+	case "BeforeConfigWrite", "AfterConfigWrite":
+		return ConfigWriteHookArgs{SSHConfigPath: conf.SSHConfigPath(), Host: host}
+	case "OnResolve":
+		method := "command"
+		if host.ResolveCommand == "" && len(host.ResolveNameservers) > 0 {
+			method = "dns"
+		}
+		args := ResolveHookArgs{Host: host, Method: method, OldHostName: host.HostName, HostName: "192.0.2.1"}
+		if gateway != "direct" {
+			args.Gateway = gateway
+		}
+		return args
+	case "OnRetry":
+		return RetryHookArgs{Host: host, Stats: stats, Error: dialError, Step: retryStepDial, Attempt: 1, RetryIn: defaultRetryBackoff}
+	case "OnGatewayFallback":
+		next := "direct"
+		if len(host.Gateways) > 1 {
+			next = upstream.Redact(host.Gateways[1])
+		}
+		return GatewayFallbackHookArgs{Host: host, Gateway: gateway, Error: "exit status 255", NextGateway: next}
+	}
+
+	args := ConnectHookArgs{Host: host, Stats: stats}
+	switch event {
+	case "OnConnectError":
+		args.Error = dialError
+		stats.DisconnectedAt = now
+		return args
+	case "BeforeConnect":
+		return args
+	}
+
+	stats.ConnectedAt = now.Add(-5 * time.Minute)
+	switch event {
+	case "OnIdle":
+		return IdleHookArgs{Host: host, Stats: stats, IdleFor: 10 * time.Minute}
+	case "BeforeDisconnect":
+		args.Reason = disconnectIdle
+		args.DisconnectIn = defaultDisconnectWarning
+	case "OnDisconnect":
+		args.Reason = disconnectClient
+		stats.ReceivedBytes = 1258291
+		stats.SentBytes = 24576
+		stats.WrittenBytes = stats.ReceivedBytes
+		stats.TimeToFirstByte = 80 * time.Millisecond
+		stats.disconnected(now)
+	}
+	return args
+}
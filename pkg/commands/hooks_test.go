@@ -0,0 +1,55 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"moul.io/assh/v2/pkg/config"
+	"moul.io/assh/v2/pkg/hooks"
+)
+
+func Test_hookTestArgs(t *testing.T) {
+	Convey("Testing hookTestArgs()", t, func() {
+		conf := config.New()
+		So(conf.LoadConfig(strings.NewReader(`hosts:
+  target:
+    HostName: 10.0.0.1
+    Gateways: [bastion, direct]
+`)), ShouldBeNil)
+		host := conf.GetHostSafe("target")
+
+		// every event gets its typed arguments
+		for _, event := range host.Hooks.Events() {
+			So(hookTestArgs(conf, host, event.Name), ShouldNotBeNil)
+		}
+
+		args := hookTestArgs(conf, host, "OnConnectError").(ConnectHookArgs)
+		So(args.Error, ShouldEqual, "dial tcp 10.0.0.1:22: connect: connection refused")
+		So(args.Stats.Gateway, ShouldEqual, "bastion")
+
+		args = hookTestArgs(conf, host, "OnDisconnect").(ConnectHookArgs)
+		So(args.Reason, ShouldEqual, disconnectClient)
+		So(args.Stats.ConnectionDurationHuman, ShouldNotBeEmpty)
+		So(args.Stats.ReceivedBytesHuman, ShouldEqual, "1.3 MB")
+
+		fallback := hookTestArgs(conf, host, "OnGatewayFallback").(GatewayFallbackHookArgs)
+		So(fallback.Gateway, ShouldEqual, "bastion")
+		So(fallback.NextGateway, ShouldEqual, "direct")
+
+		// the documented template variables can be rendered
+		for event, line := range map[string]string{
+			"OnConnectError":    "write {{.Host.Name}} {{.Error}}",
+			"OnDisconnect":      "write {{.Stats.ConnectionDurationHuman}} {{.Stats.AverageSpeedHuman}} {{.Reason}}",
+			"BeforeDisconnect":  "write {{.DisconnectIn}} {{.Reason}}",
+			"OnRetry":           "write {{.Step}} {{.Attempt}} {{.RetryIn}} {{.Stats.Gateway}}",
+			"OnGatewayFallback": "write {{.Gateway}} {{.NextGateway}} {{.Error}}",
+			"OnResolve":         "write {{.Method}} {{.OldHostName}} {{.HostName}} {{.Gateway}}",
+			"OnIdle":            "write {{.IdleFor}} {{.Stats.ConnectedAt}}",
+			"BeforeConfigWrite": "write {{.SSHConfigPath}} {{.Host.Name}}",
+		} {
+			results := (&hooks.Hooks{{Expr: line}}).DryRunAll(event, hookTestArgs(conf, host, event))
+			So(results[0].Err, ShouldBeNil)
+		}
+	})
+}
@@ -1,8 +1,12 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"os"
+	"os/signal"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -10,6 +14,9 @@ import (
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 	"moul.io/assh/v2/pkg/config"
+	"moul.io/assh/v2/pkg/sshprobe"
+	"moul.io/assh/v2/pkg/upstream"
+	"moul.io/assh/v2/pkg/utils"
 )
 
 var pingCommand = &cobra.Command{
@@ -26,12 +33,70 @@ func init() {
 	pingCommand.Flags().Float64P("wait", "i", 1, "Wait 'wait' seconds between sending each packet")
 	pingCommand.Flags().BoolP("o", "", false, "Exit successfully after receiving one reply packet")
 	pingCommand.Flags().Float64P("waittime", "W", 1, "Time in seconds to wait for a reply for each packet sent")
+	pingCommand.Flags().BoolP("all", "", false, "Ping every host of the configuration")
+	pingCommand.Flags().StringP("template", "", "", "Ping every host inheriting from a template")
+	pingCommand.Flags().IntP("concurrency", "", 10, "Maximum number of hosts pinged simultaneously when pinging multiple hosts")
+	pingCommand.Flags().StringP("format", "", "text", "Output format: 'text', 'json' or 'ndjson' (one JSON object per reply, streamed)")
+	pingCommand.Flags().StringP("probe", "", "tcp", "Probe level: 'tcp' (connect only), 'banner' (read the SSH identification) or 'kex' (complete the key exchange and check known_hosts)")
 	_ = viper.BindPFlags(pingCommand.Flags())
 }
 
+// pingHop contains the time spent to reach one step of the path to a host
+type pingHop struct {
+	Name     string
+	Duration time.Duration
+}
+
+// pingResult contains the outcome of a single probe
+type pingResult struct {
+	Gateway  string
+	Hops     []pingHop
+	Duration time.Duration
+	Server   *sshprobe.Result
+	Err      error
+}
+
+// pingOptions configures how hosts are probed
+type pingOptions struct {
+	Timeout time.Duration
+	// Probe is the probe level: "tcp", "banner" or "kex"
+	Probe string
+	// Wait is the delay between the probes sent to a host
+	Wait time.Duration
+}
+
+func (r *pingResult) addHop(name string, duration time.Duration) {
+	r.Hops = append(r.Hops, pingHop{Name: name, Duration: duration})
+	r.Duration += duration
+}
+
+func (r pingResult) hopsString() string {
+	hops := make([]string, 0, len(r.Hops))
+	for _, hop := range r.Hops {
+		hops = append(hops, fmt.Sprintf("%s=%v", hop.Name, hop.Duration))
+	}
+	return strings.Join(hops, " ")
+}
+
+func (r pingResult) serverString() string {
+	if r.Server == nil || r.Server.Banner == nil {
+		return ""
+	}
+	infos := []string{fmt.Sprintf("server=%s", r.Server.Banner.SoftwareVersion)}
+	if r.Server.Fingerprint != "" {
+		infos = append(infos, fmt.Sprintf("hostkey=%s", r.Server.Fingerprint))
+	}
+	if r.Server.KnownHosts != sshprobe.KnownHostsUnchecked {
+		infos = append(infos, fmt.Sprintf("known_hosts=%s", r.Server.KnownHosts))
+	}
+	return strings.Join(infos, " ")
+}
+
 func runPingCommand(cmd *cobra.Command, args []string) error {
-	if len(args) < 1 {
-		return errors.New("assh: \"ping\" requires exactly 1 argument. See 'assh ping --help'")
+	fleet := viper.GetBool("all") || viper.GetString("template") != "" || len(args) > 1 ||
+		(len(args) == 1 && isHostPattern(args[0]))
+	if len(args) < 1 && !fleet {
+		return errors.New("assh: \"ping\" requires at least 1 argument. See 'assh ping --help'")
 	}
 
 	conf, err := config.Open(viper.GetString("config"))
@@ -41,74 +106,263 @@ func runPingCommand(cmd *cobra.Command, args []string) error {
 	if err = conf.LoadKnownHosts(); err != nil {
 		return errors.Wrap(err, "failed to load known-hosts")
 	}
-	target := args[0]
-	host, err := computeHost(target, viper.GetInt("port"), conf)
+
+	opts := pingOptions{
+		Timeout: time.Duration(viper.GetFloat64("waittime") * float64(time.Second)),
+		Probe:   viper.GetString("probe"),
+		Wait:    time.Duration(viper.GetFloat64("wait") * float64(time.Second)),
+	}
+	switch opts.Probe {
+	case "tcp", "banner", "kex":
+	default:
+		return fmt.Errorf("invalid probe level %q, should be 'tcp', 'banner' or 'kex'", opts.Probe)
+	}
+	output, err := newPingOutput(viper.GetString("format"), os.Stdout)
 	if err != nil {
-		return errors.Wrapf(err, "failed to get host %q", target)
+		return err
 	}
 
-	if len(host.Gateways) > 0 {
-		return errors.New("assh \"ping\" is not working with gateways (yet)")
-	}
-	if host.ProxyCommand != "" {
-		return errors.New("assh \"ping\" is not working with custom ProxyCommand (yet)")
+	// Ctrl+C stops sending probes, the statistics are still displayed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if fleet {
+		targets, err := pingFleetTargets(conf, args, viper.GetBool("all"), viper.GetString("template"))
+		if err != nil {
+			return err
+		}
+		if err := runPingFleet(ctx, conf, targets, opts, output); err != nil {
+			// the failures were already reported
+			cmd.SilenceUsage = true
+			return err
+		}
+		return nil
 	}
 
-	portName := "ssh"
-	if host.Port != "22" {
-		// fixme: resolve port name
-		portName = "unknown"
+	target := args[0]
+	host, err := computeHost(target, viper.GetInt("port"), conf)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get host %q", target)
 	}
-	proto := "tcp"
-	fmt.Printf("PING %s (%s) PORT %s (%s) PROTO %s\n", target, host.HostName, host.Port, portName, proto)
-	dest := net.JoinHostPort(host.HostName, host.Port)
+
+	port, portName := servicePort(host.Port)
+	output.header(target, host.HostName, port, portName)
+	stats := pingStats{}
 	count := uint(viper.GetInt("count"))
-	transmittedPackets := 0
-	receivedPackets := 0
-	minRoundtrip := time.Duration(0)
-	maxRoundtrip := time.Duration(0)
-	totalRoundtrip := time.Duration(0)
+loop:
 	for seq := uint(0); count == 0 || seq < count; seq++ {
 		if seq > 0 {
-			time.Sleep(time.Duration(viper.GetFloat64("wait")) * time.Second)
+			select {
+			case <-ctx.Done():
+				break loop
+			case <-time.After(opts.Wait):
+			}
 		}
-		start := time.Now()
-		conn, err := net.DialTimeout(proto, dest, time.Second*time.Duration(viper.GetFloat64("waittime")))
-		transmittedPackets++
-		duration := time.Since(start)
-		totalRoundtrip += duration
-		if minRoundtrip == 0 || minRoundtrip > duration {
-			minRoundtrip = duration
+		result := pingHost(host, conf, opts)
+		stats.add(result)
+		output.reply(newPingReply(seq, target, host, port, result))
+		if ctx.Err() != nil || (result.Err == nil && viper.GetBool("o")) {
+			break
 		}
-		if maxRoundtrip < duration {
-			maxRoundtrip = duration
+	}
+
+	return output.summary(target, stats)
+}
+
+// pingHost probes host using the same path selection as proxy(),
+// the first gateway answering is used
+func pingHost(host *config.Host, conf *config.Config, opts pingOptions) pingResult {
+	if len(host.Gateways) == 0 {
+		return pingDirect(host, opts)
+	}
+
+	gatewayErrors := pingGatewaysError{}
+	for _, gateway := range host.Gateways {
+		var result pingResult
+		switch {
+		case gateway == "direct":
+			result = pingDirect(host, opts)
+			result.Gateway = gateway
+		case upstream.IsProxy(gateway):
+			result = pingUpstream(host, gateway, opts)
+		default:
+			result = pingGateway(host, conf, gateway, opts)
 		}
-		if err == nil {
-			defer func() {
-				if err2 := conn.Close(); err2 != nil {
-					logger().Error("Failed to close connection", zap.Error(err2))
-				}
-			}()
+		if result.Err == nil {
+			return result
 		}
-		if err == nil {
-			receivedPackets++
-			fmt.Printf("Connected to %s: seq=%d time=%v protocol=%s port=%s\n", host.HostName, seq, duration, proto, host.Port)
-			if viper.GetBool("o") {
-				goto stats
-			}
-		} else {
-			// FIXME: switch on error type
-			fmt.Printf("Request timeout for seq %d (%v)\n", seq, err)
+		logger().Debug("Failed to ping using gateway", zap.String("gateway", upstream.Redact(gateway)), zap.Error(result.Err))
+		gatewayErrors.gateways = append(gatewayErrors.gateways, upstream.Redact(gateway))
+		gatewayErrors.errs = append(gatewayErrors.errs, result.Err)
+	}
+	return pingResult{Err: gatewayErrors}
+}
+
+// pingGatewaysError is returned when a host cannot be reached through any of its gateways,
+// the error of each gateway is kept to classify the failure
+type pingGatewaysError struct {
+	gateways []string
+	errs     []error
+}
+
+func (e pingGatewaysError) Error() string {
+	messages := make([]string, 0, len(e.errs))
+	for idx, err := range e.errs {
+		messages = append(messages, fmt.Sprintf("%s: %v", e.gateways[idx], err))
+	}
+	return fmt.Sprintf("no such available gateway (%s)", strings.Join(messages, ", "))
+}
+
+func (e pingGatewaysError) Unwrap() []error { return e.errs }
+
+// pingDirect probes host without gateway, either with a native TCP connection or using the host ProxyCommand
+func pingDirect(host *config.Host, opts pingOptions) pingResult {
+	result := pingResult{}
+	hostCopy := host.Clone()
+	if hostCopy.ProxyCommand != "" {
+		pingCommandPath(&result, hostCopy, hostCopy, hostCopy.ProxyCommand, "proxycommand", opts)
+		return result
+	}
+
+	if err := hostPrepare(hostCopy, "", nil); err != nil {
+		result.Err = errors.Wrap(err, "failed to prepare host")
+		return result
+	}
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(hostCopy.HostName, hostCopy.Port), opts.Timeout)
+	result.addHop("tcp", time.Since(start))
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer closePingConn(conn)
+
+	if opts.Probe != "tcp" {
+		pingProbe(&result, conn, hostCopy, "banner", opts)
+	}
+	return result
+}
+
+// pingUpstream probes host through an upstream proxy, the connection to the proxy and its handshake are measured
+// as a single hop
+func pingUpstream(host *config.Host, gateway string, opts pingOptions) pingResult {
+	result := pingResult{Gateway: upstream.Redact(gateway)}
+	hostCopy := host.Clone()
+	if err := hostPrepare(hostCopy, "", nil); err != nil {
+		result.Err = errors.Wrap(err, "failed to prepare host")
+		return result
+	}
+	start := time.Now()
+	conn, err := upstream.Dial(gateway, net.JoinHostPort(hostCopy.HostName, hostCopy.Port), opts.Timeout)
+	result.addHop("proxy", time.Since(start))
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer closePingConn(conn)
+
+	if opts.Probe != "tcp" {
+		pingProbe(&result, conn, hostCopy, "banner", opts)
+	}
+	return result
+}
+
+// pingGateway probes host through gateway; the gateway SSH port is dialed first,
+// then a channel to the host is opened through the gateway
+func pingGateway(host *config.Host, conf *config.Config, gateway string, opts pingOptions) pingResult {
+	result := pingResult{Gateway: gateway}
+	gatewayHost := conf.GetGatewaySafe(gateway)
+
+	// the gateway port can only be measured if the gateway is directly reachable
+	if gatewayHost.ProxyCommand == "" && len(gatewayHost.Gateways) == 0 {
+		gatewayCopy := gatewayHost.Clone()
+		if err := hostPrepare(gatewayCopy, "", nil); err != nil {
+			result.Err = errors.Wrap(err, "failed to prepare gateway")
+			return result
+		}
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(gatewayCopy.HostName, gatewayCopy.Port), opts.Timeout)
+		result.addHop("gateway", time.Since(start))
+		if err != nil {
+			result.Err = err
+			return result
 		}
+		closePingConn(conn)
+	}
+
+	command, targetHost, err := gatewayCommand(host, gateway, nil)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	pingCommandPath(&result, gatewayHost, targetHost, command, "channel", opts)
+	return result
+}
+
+// pingCommandPath probes target through a command; the command is considered connected
+// as soon as the remote server identifies itself, no session is opened on the remote server
+func pingCommandPath(result *pingResult, commandHost *config.Host, target *config.Host, command string, hop string, opts pingOptions) {
+	conn, err := dialCommand(commandHost, command, nil)
+	if err != nil {
+		result.Err = err
+		return
+	}
+	defer closePingConn(conn)
+	pingProbe(result, conn, target, hop, opts)
+	if errors.Is(result.Err, os.ErrDeadlineExceeded) && result.Server.Banner == nil {
+		result.Err = errors.Wrapf(result.Err, "%s did not answer", hop)
+	}
+}
+
+// pingProbe reads the SSH identification of the server and optionally completes the key exchange
+func pingProbe(result *pingResult, conn net.Conn, host *config.Host, bannerHop string, opts pingOptions) {
+	address := host.HostName
+	if host.HostKeyAlias != "" {
+		address = host.HostKeyAlias
+	}
+	server, err := sshprobe.Probe(conn, sshprobe.Options{
+		KEX:             opts.Probe == "kex",
+		Address:         net.JoinHostPort(address, host.Port),
+		KnownHostsFiles: knownHostsFiles(host),
+		Timeout:         opts.Timeout,
+	})
+	result.Server = server
+	result.addHop(bannerHop, server.BannerDuration)
+	if server.KEXDuration > 0 {
+		result.addHop("kex", server.KEXDuration)
 	}
+	result.Err = err
+}
 
-	// FIXME: catch Ctrl+C
+// knownHostsFiles returns the known_hosts files used by ssh for host
+func knownHostsFiles(host *config.Host) []string {
+	files := []string{}
+	userFiles := host.UserKnownHostsFile
+	if len(userFiles) == 0 {
+		userFiles = []string{"~/.ssh/known_hosts", "~/.ssh/known_hosts2"}
+	}
+	globalFiles := host.GlobalKnownHostsFile
+	if len(globalFiles) == 0 {
+		globalFiles = []string{"/etc/ssh/ssh_known_hosts", "/etc/ssh/ssh_known_hosts2"}
+	}
+	for _, file := range append(userFiles, globalFiles...) {
+		for _, file := range strings.Fields(file) {
+			if file == "none" {
+				continue
+			}
+			path, err := utils.ExpandUser(expandSSHTokens(file, host))
+			if err != nil {
+				logger().Debug("Cannot expand known_hosts file", zap.String("file", file), zap.Error(err))
+				continue
+			}
+			files = append(files, path)
+		}
+	}
+	return files
+}
 
-stats:
-	fmt.Printf("\n--- %s assh ping statistics ---\n", target)
-	packetLossRatio := float64(transmittedPackets-receivedPackets) / float64(transmittedPackets) * 100
-	fmt.Printf("%d packets transmitted, %d packets received, %.2f%% packet loss\n", transmittedPackets, receivedPackets, packetLossRatio)
-	avgRoundtrip := totalRoundtrip / time.Duration(transmittedPackets)
-	fmt.Printf("round-trip min/avg/max = %v/%v/%v\n", minRoundtrip, avgRoundtrip, maxRoundtrip)
-	return nil
+func closePingConn(conn net.Conn) {
+	if err := conn.Close(); err != nil {
+		logger().Debug("Failed to close connection", zap.Error(err))
+	}
 }
@@ -0,0 +1,265 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"moul.io/assh/v2/pkg/config"
+)
+
+// isHostPattern returns true if name contains wildcards
+func isHostPattern(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+// pingFleetTargets returns the sorted names of the hosts to ping, matching the patterns,
+// all the hosts or the hosts inheriting from template.
+// Hosts defined as patterns are pinged using their first non-pattern alias, if any.
+func pingFleetTargets(conf *config.Config, patterns []string, all bool, template string) ([]string, error) {
+	template = strings.ToLower(template)
+	if template != "" {
+		if _, found := conf.Templates[template]; !found {
+			return nil, fmt.Errorf("no such template: %s", template)
+		}
+	}
+
+	targets := map[string]bool{}
+	for name, host := range conf.Hosts {
+		candidates := append([]string{name}, host.Aliases...)
+
+		selected := all
+		if template != "" {
+			for _, inherited := range host.Inherits {
+				if inherited == template {
+					selected = true
+				}
+			}
+		}
+		for _, pattern := range patterns {
+			for _, candidate := range candidates {
+				matched, err := path.Match(pattern, candidate)
+				if err != nil {
+					return nil, errors.Wrapf(err, "invalid pattern %q", pattern)
+				}
+				if matched {
+					selected = true
+				}
+			}
+		}
+		if !selected {
+			continue
+		}
+
+		for _, candidate := range candidates {
+			if !isHostPattern(candidate) {
+				targets[candidate] = true
+				break
+			}
+		}
+	}
+
+	// non-pattern arguments are pinged even if they are not in the configuration
+	for _, pattern := range patterns {
+		if !isHostPattern(pattern) {
+			targets[pattern] = true
+		}
+	}
+
+	if len(targets) == 0 {
+		return nil, errors.New("no host matches")
+	}
+	sorted := make([]string, 0, len(targets))
+	for target := range targets {
+		sorted = append(sorted, target)
+	}
+	sort.Strings(sorted)
+	return sorted, nil
+}
+
+// pingFleetEntry is the outcome of the probes sent to one of the hosts of a fleet
+type pingFleetEntry struct {
+	Type       string `json:",omitempty"`
+	Host       string
+	HostName   string
+	Port       string
+	Path       string
+	Latency    time.Duration
+	Status     string
+	Error      string `json:",omitempty"`
+	Statistics pingStats
+}
+
+// failed returns true if at least one probe failed or if the host was not pinged
+func (e pingFleetEntry) failed() bool {
+	return e.Statistics.Transmitted == 0 || e.Statistics.Received < e.Statistics.Transmitted
+}
+
+// pingFleetSummary is the final document of the json and ndjson output formats when pinging multiple hosts
+type pingFleetSummary struct {
+	Type   string           `json:",omitempty"`
+	Hosts  []pingFleetEntry `json:",omitempty"`
+	Total  int
+	Failed int
+}
+
+// runPingFleet pings multiple hosts with bounded concurrency, an error is returned if any host failed
+func runPingFleet(ctx context.Context, conf *config.Config, targets []string, opts pingOptions, output *pingOutput) error {
+	count := viper.GetInt("count")
+	if count < 1 {
+		count = 1
+	}
+	concurrency := viper.GetInt("concurrency")
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	entries := make([]pingFleetEntry, len(targets))
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, concurrency)
+	for idx, target := range targets {
+		wg.Add(1)
+		go func(idx int, target string) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			entry := pingFleetHost(ctx, conf, target, count, opts)
+			mutex.Lock()
+			defer mutex.Unlock()
+			entries[idx] = entry
+			output.fleetEntry(entry)
+		}(idx, target)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, entry := range entries {
+		if entry.failed() {
+			failed++
+		}
+	}
+	if err := output.fleetSummary(entries, failed); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d/%d hosts failed", failed, len(entries))
+	}
+	return nil
+}
+
+// pingFleetHost sends count probes to target, opts.Wait apart, the probes are not sent anymore once ctx is canceled
+func pingFleetHost(ctx context.Context, conf *config.Config, target string, count int, opts pingOptions) pingFleetEntry {
+	entry := pingFleetEntry{Host: target, Path: "-"}
+	host, err := computeHost(target, viper.GetInt("port"), conf)
+	if err != nil {
+		entry.Status = pingErrorOther
+		entry.Error = err.Error()
+		entry.Statistics.add(pingResult{Err: err})
+		entry.Statistics.compute()
+		return entry
+	}
+	entry.HostName = host.HostName
+	entry.Port, _ = servicePort(host.Port)
+
+	var lastErr error
+loop:
+	for seq := 0; seq < count && ctx.Err() == nil; seq++ {
+		if seq > 0 {
+			select {
+			case <-ctx.Done():
+				break loop
+			case <-time.After(opts.Wait):
+			}
+		}
+		result := pingHost(host, conf, opts)
+		entry.Statistics.add(result)
+		if result.Err != nil {
+			logger().Debug("Failed to ping host", zap.String("host", target), zap.Error(result.Err))
+			lastErr = result.Err
+			continue
+		}
+		entry.Path = pingPath(host, result)
+	}
+
+	entry.Statistics.compute()
+	entry.Latency = entry.Statistics.Avg
+	switch {
+	case entry.Statistics.Transmitted == 0:
+		entry.Status = "canceled"
+	case lastErr == nil:
+		entry.Status = "ok"
+	default:
+		entry.Status = pingErrorType(lastErr)
+		entry.Error = lastErr.Error()
+	}
+	return entry
+}
+
+// pingPath describes how a host was reached
+func pingPath(host *config.Host, result pingResult) string {
+	switch result.Gateway {
+	case "", "direct":
+		if host.ProxyCommand != "" {
+			return "proxycommand"
+		}
+		return "direct"
+	default:
+		return "via " + result.Gateway
+	}
+}
+
+func (o *pingOutput) fleetEntry(entry pingFleetEntry) {
+	if o.format != "ndjson" {
+		return
+	}
+	entry.Type = "host"
+	if err := o.encoder.Encode(entry); err != nil {
+		logger().Error("failed to encode ping entry", zap.Error(err))
+	}
+}
+
+func (o *pingOutput) fleetSummary(entries []pingFleetEntry, failed int) error {
+	switch o.format {
+	case "json":
+		return errors.Wrap(o.encoder.Encode(pingFleetSummary{Hosts: entries, Total: len(entries), Failed: failed}), "failed to encode ping summary")
+	case "ndjson":
+		return errors.Wrap(o.encoder.Encode(pingFleetSummary{Type: "summary", Total: len(entries), Failed: failed}), "failed to encode ping summary")
+	}
+
+	writer := tabwriter.NewWriter(o.writer, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "HOST\tPATH\tLATENCY\tSTATUS")
+	for _, entry := range entries {
+		latency := "-"
+		if entry.Statistics.Received > 0 {
+			latency = entry.Latency.String()
+		}
+		status := entry.Status
+		if entry.Statistics.Transmitted > 1 && entry.failed() {
+			status = fmt.Sprintf("%s (%.0f%% loss)", status, entry.Statistics.PacketLoss)
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", entry.Host, entry.Path, latency, status)
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		fmt.Fprintln(o.writer)
+		for _, entry := range entries {
+			if entry.Error != "" {
+				fmt.Fprintf(o.writer, "%s: %s\n", entry.Host, entry.Error)
+			}
+		}
+	}
+	fmt.Fprintf(o.writer, "\n%d hosts, %d failed\n", len(entries), failed)
+	return nil
+}
@@ -0,0 +1,125 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"moul.io/assh/v2/pkg/config"
+)
+
+func Test_pingFleetTargets(t *testing.T) {
+	Convey("Testing pingFleetTargets()", t, func() {
+		conf := config.New()
+		err := conf.LoadConfig(strings.NewReader(`
+hosts:
+  web-1:
+    Inherits: prod
+  web-2:
+    Aliases: [www]
+  db-1:
+    Inherits: prod
+  "*.example.com":
+    Aliases: [example-gw, "*.example.org"]
+  "*.internal": {}
+templates:
+  prod:
+    User: deploy
+`))
+		So(err, ShouldBeNil)
+
+		targets, err := pingFleetTargets(conf, []string{"web-*"}, false, "")
+		So(err, ShouldBeNil)
+		So(targets, ShouldResemble, []string{"web-1", "web-2"})
+
+		targets, err = pingFleetTargets(conf, []string{"ww?", "db-1", "unknown-host"}, false, "")
+		So(err, ShouldBeNil)
+		So(targets, ShouldResemble, []string{"db-1", "unknown-host", "web-2"})
+
+		targets, err = pingFleetTargets(conf, []string{"*.example.org"}, false, "")
+		So(err, ShouldBeNil)
+		So(targets, ShouldResemble, []string{"example-gw"})
+
+		targets, err = pingFleetTargets(conf, nil, true, "")
+		So(err, ShouldBeNil)
+		So(targets, ShouldResemble, []string{"db-1", "example-gw", "web-1", "web-2"})
+
+		targets, err = pingFleetTargets(conf, nil, false, "Prod")
+		So(err, ShouldBeNil)
+		So(targets, ShouldResemble, []string{"db-1", "web-1"})
+
+		_, err = pingFleetTargets(conf, nil, false, "staging")
+		So(err, ShouldNotBeNil)
+
+		_, err = pingFleetTargets(conf, []string{"app-*"}, false, "")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func Test_runPingFleet(t *testing.T) {
+	Convey("Testing runPingFleet()", t, func() {
+		listener := newBannerServer(t)
+		defer listener.Close()
+		_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+		conf := config.New()
+		err := conf.LoadConfig(strings.NewReader(fmt.Sprintf(`
+hosts:
+  web-1:
+    HostName: 127.0.0.1
+    Port: %s
+  web-2:
+    HostName: 127.0.0.1
+    Port: %s
+    Gateways: [direct]
+  web-3:
+    HostName: 127.0.0.1
+    Port: %s
+`, port, port, closedPort(t))))
+		So(err, ShouldBeNil)
+		opts := pingOptions{Timeout: 2 * time.Second, Probe: "tcp"}
+
+		var buf bytes.Buffer
+		output, err := newPingOutput("text", &buf)
+		So(err, ShouldBeNil)
+		err = runPingFleet(context.Background(), conf, []string{"web-1", "web-2"}, opts, output)
+		So(err, ShouldBeNil)
+		lines := strings.Split(buf.String(), "\n")
+		So(lines[0], ShouldStartWith, "HOST   PATH")
+		So(lines[1], ShouldStartWith, "web-1  direct")
+		So(lines[1], ShouldEndWith, "ok")
+		So(lines[2], ShouldStartWith, "web-2  direct")
+		So(buf.String(), ShouldEndWith, "\n2 hosts, 0 failed\n")
+
+		buf.Reset()
+		err = runPingFleet(context.Background(), conf, []string{"web-1", "web-3"}, opts, output)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "1/2 hosts failed")
+		lines = strings.Split(buf.String(), "\n")
+		So(strings.Fields(lines[2]), ShouldResemble, []string{"web-3", "-", "-", "refused"})
+		So(lines[4], ShouldContainSubstring, "connection refused")
+		So(buf.String(), ShouldEndWith, "\n2 hosts, 1 failed\n")
+
+		Convey("with several probes", func() {
+			opts.Wait = 100 * time.Millisecond
+			start := time.Now()
+			entry := pingFleetHost(context.Background(), conf, "web-1", 3, opts)
+			So(entry.Statistics.Received, ShouldEqual, 3)
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 200*time.Millisecond)
+		})
+
+		Convey("when canceled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			buf.Reset()
+			err = runPingFleet(ctx, conf, []string{"web-1"}, opts, output)
+			So(err, ShouldNotBeNil)
+			So(buf.String(), ShouldContainSubstring, "canceled")
+		})
+	})
+}
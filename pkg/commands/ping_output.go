@@ -0,0 +1,141 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"moul.io/assh/v2/pkg/config"
+	"moul.io/assh/v2/pkg/sshprobe"
+)
+
+// pingReply is the outcome of one probe, as displayed by the ping command
+type pingReply struct {
+	Type      string `json:",omitempty"`
+	Seq       uint
+	Host      string
+	HostName  string
+	Port      string
+	Gateway   string `json:",omitempty"`
+	Duration  time.Duration
+	Hops      []pingHop        `json:",omitempty"`
+	Server    *sshprobe.Result `json:",omitempty"`
+	Error     string           `json:",omitempty"`
+	ErrorType string           `json:",omitempty"`
+
+	result pingResult
+}
+
+func newPingReply(seq uint, target string, host *config.Host, port string, result pingResult) pingReply {
+	reply := pingReply{
+		Seq:      seq,
+		Host:     target,
+		HostName: host.HostName,
+		Port:     port,
+		Gateway:  result.Gateway,
+		Duration: result.Duration,
+		Hops:     result.Hops,
+		Server:   result.Server,
+		result:   result,
+	}
+	if result.Err != nil {
+		reply.Error = result.Err.Error()
+		reply.ErrorType = pingErrorType(result.Err)
+	}
+	return reply
+}
+
+// pingSummary is the final document of the json and ndjson output formats
+type pingSummary struct {
+	Type       string `json:",omitempty"`
+	Host       string
+	Replies    []pingReply `json:",omitempty"`
+	Statistics pingStats
+}
+
+// pingOutput displays the replies and the statistics of the ping command in the selected format
+type pingOutput struct {
+	format  string
+	writer  io.Writer
+	encoder *json.Encoder
+	replies []pingReply
+}
+
+func newPingOutput(format string, writer io.Writer) (*pingOutput, error) {
+	switch format {
+	case "text", "json", "ndjson":
+	default:
+		return nil, fmt.Errorf("invalid output format %q, should be 'text', 'json' or 'ndjson'", format)
+	}
+	output := pingOutput{
+		format:  format,
+		writer:  writer,
+		encoder: json.NewEncoder(writer),
+	}
+	if format == "json" {
+		output.encoder.SetIndent("", "  ")
+	}
+	return &output, nil
+}
+
+func (o *pingOutput) header(target string, hostname string, port string, portName string) {
+	if o.format != "text" {
+		return
+	}
+	fmt.Fprintf(o.writer, "PING %s (%s) PORT %s (%s) PROTO tcp\n", target, hostname, port, portName)
+}
+
+func (o *pingOutput) reply(reply pingReply) {
+	switch o.format {
+	case "json":
+		o.replies = append(o.replies, reply)
+		return
+	case "ndjson":
+		reply.Type = "reply"
+		if err := o.encoder.Encode(reply); err != nil {
+			logger().Error("failed to encode ping reply", zap.Error(err))
+		}
+		return
+	}
+
+	if reply.result.Err != nil {
+		fmt.Fprintf(o.writer, "%s for seq %d (%v)\n", pingErrorDescription(reply.ErrorType), reply.Seq, reply.result.Err)
+		return
+	}
+	line := fmt.Sprintf("Connected to %s", reply.HostName)
+	if reply.Gateway != "" && reply.Gateway != "direct" {
+		line += fmt.Sprintf(" via %s", reply.Gateway)
+	}
+	line += fmt.Sprintf(": seq=%d time=%v protocol=tcp port=%s", reply.Seq, reply.Duration, reply.Port)
+	if len(reply.Hops) > 1 {
+		line += " " + reply.result.hopsString()
+	}
+	if server := reply.result.serverString(); server != "" {
+		line += " " + server
+	}
+	fmt.Fprintln(o.writer, line)
+}
+
+func (o *pingOutput) summary(target string, stats pingStats) error {
+	stats.compute()
+	switch o.format {
+	case "json":
+		return errors.Wrap(o.encoder.Encode(pingSummary{Host: target, Replies: o.replies, Statistics: stats}), "failed to encode ping summary")
+	case "ndjson":
+		return errors.Wrap(o.encoder.Encode(pingSummary{Type: "summary", Host: target, Statistics: stats}), "failed to encode ping summary")
+	}
+
+	fmt.Fprintf(o.writer, "\n--- %s assh ping statistics ---\n", target)
+	fmt.Fprintf(o.writer, "%d packets transmitted, %d packets received, %.2f%% packet loss\n", stats.Transmitted, stats.Received, stats.PacketLoss)
+	if stats.Received > 0 {
+		fmt.Fprintf(o.writer, "round-trip min/avg/max/stddev = %v/%v/%v/%v\n", stats.Min, stats.Avg, stats.Max, stats.StdDev)
+		fmt.Fprintf(o.writer, "round-trip p50/p90/p99 = %v/%v/%v\n", stats.P50, stats.P90, stats.P99)
+	}
+	if len(stats.Errors) > 0 {
+		fmt.Fprintf(o.writer, "errors: %s\n", stats.errorsString())
+	}
+	return nil
+}
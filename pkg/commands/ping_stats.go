@@ -0,0 +1,193 @@
+package commands
+
+import (
+	"bufio"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ping error types
+const (
+	pingErrorRefused     = "refused"
+	pingErrorTimeout     = "timeout"
+	pingErrorDNS         = "dns"
+	pingErrorUnreachable = "unreachable"
+	pingErrorOther       = "error"
+)
+
+// pingErrorType classifies the error returned by a probe
+func pingErrorType(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &dnsErr):
+		return pingErrorDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return pingErrorRefused
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return pingErrorUnreachable
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return pingErrorTimeout
+	default:
+		return pingErrorOther
+	}
+}
+
+// pingErrorDescription returns the text displayed for a failed probe
+func pingErrorDescription(errorType string) string {
+	switch errorType {
+	case pingErrorRefused:
+		return "Connection refused"
+	case pingErrorTimeout:
+		return "Request timeout"
+	case pingErrorDNS:
+		return "Cannot resolve host"
+	case pingErrorUnreachable:
+		return "Destination unreachable"
+	default:
+		return "Request failed"
+	}
+}
+
+// pingStats aggregates the results of the probes sent to a host,
+// round-trip statistics are only computed over the successful probes
+type pingStats struct {
+	Transmitted int
+	Received    int
+	PacketLoss  float64
+	Min         time.Duration
+	Avg         time.Duration
+	Max         time.Duration
+	StdDev      time.Duration
+	P50         time.Duration
+	P90         time.Duration
+	P99         time.Duration
+	Errors      map[string]int `json:",omitempty"`
+
+	durations []time.Duration
+}
+
+func (s *pingStats) add(result pingResult) {
+	s.Transmitted++
+	if result.Err != nil {
+		if s.Errors == nil {
+			s.Errors = map[string]int{}
+		}
+		s.Errors[pingErrorType(result.Err)]++
+	} else {
+		s.Received++
+		s.durations = append(s.durations, result.Duration)
+	}
+}
+
+// compute computes the statistics of the probes added so far, it sorts all the durations: it is only called once the
+// statistics are displayed
+func (s *pingStats) compute() {
+	if s.Transmitted > 0 {
+		s.PacketLoss = float64(s.Transmitted-s.Received) / float64(s.Transmitted) * 100
+	}
+	if len(s.durations) == 0 {
+		return
+	}
+
+	sorted := make([]time.Duration, len(s.durations))
+	copy(sorted, s.durations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total float64
+	for _, duration := range sorted {
+		total += float64(duration)
+	}
+	avg := total / float64(len(sorted))
+	var variance float64
+	for _, duration := range sorted {
+		variance += (float64(duration) - avg) * (float64(duration) - avg)
+	}
+	variance /= float64(len(sorted))
+
+	s.Min = sorted[0]
+	s.Max = sorted[len(sorted)-1]
+	s.Avg = time.Duration(avg)
+	s.StdDev = time.Duration(math.Sqrt(variance))
+	s.P50 = percentile(sorted, 50)
+	s.P90 = percentile(sorted, 90)
+	s.P99 = percentile(sorted, 99)
+}
+
+// percentile returns the nearest-rank percentile of sorted durations
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// errorsString returns the number of failures per error type, i.e: "refused=1 timeout=2"
+func (s *pingStats) errorsString() string {
+	types := make([]string, 0, len(s.Errors))
+	for errorType := range s.Errors {
+		types = append(types, errorType)
+	}
+	sort.Strings(types)
+	for idx, errorType := range types {
+		types[idx] = errorType + "=" + strconv.Itoa(s.Errors[errorType])
+	}
+	return strings.Join(types, " ")
+}
+
+// servicesFile is the database used to resolve the service names
+var servicesFile = "/etc/services"
+
+// servicePort returns the port number and the service name of port,
+// port can either be a number or a service name
+func servicePort(port string) (string, string) {
+	number, err := strconv.Atoi(port)
+	if err != nil {
+		number, err = net.LookupPort("tcp", port)
+		if err != nil {
+			return port, "unknown"
+		}
+		return strconv.Itoa(number), port
+	}
+	if name := serviceName(number); name != "" {
+		return port, name
+	}
+	return port, "unknown"
+}
+
+// serviceName looks up the name of a TCP port in the services database
+func serviceName(port int) string {
+	if file, err := os.Open(servicesFile); err == nil {
+		defer file.Close()
+
+		// format: "name port/protocol [aliases...] [# comment]"
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if idx := strings.Index(line, "#"); idx != -1 {
+				line = line[:idx]
+			}
+			fields := strings.Fields(line)
+			if len(fields) >= 2 && fields[1] == strconv.Itoa(port)+"/tcp" {
+				return fields[0]
+			}
+		}
+	}
+
+	// the services database is not available on every system
+	if port == 22 {
+		return "ssh"
+	}
+	return ""
+}
@@ -0,0 +1,164 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"moul.io/assh/v2/pkg/config"
+)
+
+func Test_pingErrorType(t *testing.T) {
+	Convey("Testing pingErrorType()", t, func() {
+		_, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", closedPort(t)))
+		So(pingErrorType(err), ShouldEqual, pingErrorRefused)
+		So(pingErrorType(pingGatewaysError{gateways: []string{"direct"}, errs: []error{err}}), ShouldEqual, pingErrorRefused)
+
+		So(pingErrorType(nil), ShouldEqual, "")
+		So(pingErrorType(&net.DNSError{Err: "no such host", Name: "unknown.invalid", IsNotFound: true}), ShouldEqual, pingErrorDNS)
+		So(pingErrorType(fmt.Errorf("channel did not answer: %w", os.ErrDeadlineExceeded)), ShouldEqual, pingErrorTimeout)
+		So(pingErrorType(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.EHOSTUNREACH)}), ShouldEqual, pingErrorUnreachable)
+		So(pingErrorType(fmt.Errorf("exec: not found")), ShouldEqual, pingErrorOther)
+	})
+}
+
+func Test_pingStats(t *testing.T) {
+	Convey("Testing pingStats", t, func() {
+		stats := pingStats{}
+		for _, duration := range []time.Duration{4, 2, 8, 6} {
+			stats.add(pingResult{Duration: duration * time.Millisecond})
+		}
+		stats.add(pingResult{Duration: time.Second, Err: os.ErrDeadlineExceeded})
+		// the probes are only collected, the statistics are computed once displayed
+		So(stats.Min, ShouldEqual, 0)
+		stats.compute()
+
+		So(stats.Transmitted, ShouldEqual, 5)
+		So(stats.Received, ShouldEqual, 4)
+		So(stats.PacketLoss, ShouldEqual, 20)
+		So(stats.Min, ShouldEqual, 2*time.Millisecond)
+		So(stats.Max, ShouldEqual, 8*time.Millisecond)
+		So(stats.Avg, ShouldEqual, 5*time.Millisecond)
+		So(stats.StdDev, ShouldEqual, time.Duration(2236067))
+		So(stats.P50, ShouldEqual, 4*time.Millisecond)
+		So(stats.P90, ShouldEqual, 8*time.Millisecond)
+		So(stats.Errors, ShouldResemble, map[string]int{pingErrorTimeout: 1})
+		So(stats.errorsString(), ShouldEqual, "timeout=1")
+
+		Convey("without successful probes", func() {
+			stats := pingStats{}
+			stats.add(pingResult{Err: os.ErrDeadlineExceeded})
+			stats.compute()
+			So(stats.PacketLoss, ShouldEqual, 100)
+			So(stats.Min, ShouldEqual, 0)
+			So(stats.Avg, ShouldEqual, 0)
+		})
+	})
+}
+
+func Test_servicePort(t *testing.T) {
+	Convey("Testing servicePort()", t, func() {
+		previous := servicesFile
+		defer func() { servicesFile = previous }()
+		servicesFile = filepath.Join(t.TempDir(), "services")
+		err := os.WriteFile(servicesFile, []byte("# comment\nssh\t\t22/tcp\t\t\t# SSH Remote Login Protocol\nssh-alt 2222/udp\nassh-test 2222/tcp\n"), 0o600)
+		So(err, ShouldBeNil)
+
+		port, name := servicePort("22")
+		So(port, ShouldEqual, "22")
+		So(name, ShouldEqual, "ssh")
+
+		port, name = servicePort("2222")
+		So(port, ShouldEqual, "2222")
+		So(name, ShouldEqual, "assh-test")
+
+		port, name = servicePort("2223")
+		So(port, ShouldEqual, "2223")
+		So(name, ShouldEqual, "unknown")
+
+		servicesFile = filepath.Join(t.TempDir(), "missing")
+		_, name = servicePort("22")
+		So(name, ShouldEqual, "ssh")
+	})
+}
+
+func Test_pingOutput(t *testing.T) {
+	Convey("Testing pingOutput", t, func() {
+		host := config.NewHost("example")
+		host.HostName = "127.0.0.1"
+		stats := pingStats{}
+		success := pingResult{Gateway: "direct", Hops: []pingHop{{Name: "tcp", Duration: time.Millisecond}}, Duration: time.Millisecond}
+		failure := pingResult{Err: fmt.Errorf("channel did not answer: %w", os.ErrDeadlineExceeded)}
+		stats.add(success)
+		stats.add(failure)
+
+		Convey("text", func() {
+			var buf bytes.Buffer
+			output, err := newPingOutput("text", &buf)
+			So(err, ShouldBeNil)
+			output.header("example", "127.0.0.1", "22", "ssh")
+			output.reply(newPingReply(0, "example", host, "22", success))
+			output.reply(newPingReply(1, "example", host, "22", failure))
+			So(output.summary("example", stats), ShouldBeNil)
+			So(buf.String(), ShouldEqual, `PING example (127.0.0.1) PORT 22 (ssh) PROTO tcp
+Connected to 127.0.0.1: seq=0 time=1ms protocol=tcp port=22
+Request timeout for seq 1 (channel did not answer: i/o timeout)
+
+--- example assh ping statistics ---
+2 packets transmitted, 1 packets received, 50.00% packet loss
+round-trip min/avg/max/stddev = 1ms/1ms/1ms/0s
+round-trip p50/p90/p99 = 1ms/1ms/1ms
+errors: timeout=1
+`)
+		})
+
+		Convey("ndjson", func() {
+			var buf bytes.Buffer
+			output, err := newPingOutput("ndjson", &buf)
+			So(err, ShouldBeNil)
+			output.header("example", "127.0.0.1", "22", "ssh")
+			output.reply(newPingReply(0, "example", host, "22", success))
+			output.reply(newPingReply(1, "example", host, "22", failure))
+			So(output.summary("example", stats), ShouldBeNil)
+
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			So(len(lines), ShouldEqual, 3)
+			var reply pingReply
+			So(json.Unmarshal([]byte(lines[1]), &reply), ShouldBeNil)
+			So(reply.Type, ShouldEqual, "reply")
+			So(reply.Seq, ShouldEqual, 1)
+			So(reply.ErrorType, ShouldEqual, pingErrorTimeout)
+			var summary pingSummary
+			So(json.Unmarshal([]byte(lines[2]), &summary), ShouldBeNil)
+			So(summary.Type, ShouldEqual, "summary")
+			So(summary.Statistics.Received, ShouldEqual, 1)
+		})
+
+		Convey("json", func() {
+			var buf bytes.Buffer
+			output, err := newPingOutput("json", &buf)
+			So(err, ShouldBeNil)
+			output.reply(newPingReply(0, "example", host, "22", success))
+			So(output.summary("example", stats), ShouldBeNil)
+
+			var summary pingSummary
+			So(json.Unmarshal(buf.Bytes(), &summary), ShouldBeNil)
+			So(summary.Host, ShouldEqual, "example")
+			So(len(summary.Replies), ShouldEqual, 1)
+			So(summary.Statistics.Transmitted, ShouldEqual, 2)
+		})
+
+		Convey("invalid format", func() {
+			_, err := newPingOutput("xml", &bytes.Buffer{})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
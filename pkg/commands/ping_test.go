@@ -0,0 +1,124 @@
+package commands
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"moul.io/assh/v2/pkg/config"
+)
+
+// newBannerServer starts a TCP server sending an SSH identification line to each client
+func newBannerServer(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("SSH-2.0-assh_test\r\n"))
+			_ = conn.Close()
+		}
+	}()
+	return listener
+}
+
+// closedPort returns a local port with nothing listening on it
+func closedPort(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	_ = listener.Close()
+	return port
+}
+
+func Test_pingHost(t *testing.T) {
+	Convey("Testing pingHost()", t, func() {
+		listener := newBannerServer(t)
+		defer listener.Close()
+		_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+		conf := config.New()
+		err := conf.LoadConfig(strings.NewReader(fmt.Sprintf(`
+hosts:
+  direct-host:
+    HostName: 127.0.0.1
+    Port: %s
+  proxycommand-host:
+    ProxyCommand: /bin/sh -c "echo SSH-2.0-proxycommand"
+  silent-proxycommand-host:
+    ProxyCommand: /bin/sh -c "sleep 5"
+  gateway-host:
+    HostName: 127.0.0.1
+    Port: %s
+    Gateways: [direct]
+  unreachable-host:
+    HostName: 127.0.0.1
+    Port: %s
+    Gateways: [direct]
+`, port, port, closedPort(t))))
+		So(err, ShouldBeNil)
+		opts := pingOptions{Timeout: 2 * time.Second, Probe: "tcp"}
+
+		Convey("without gateway", func() {
+			host, err := computeHost("direct-host", 0, conf)
+			So(err, ShouldBeNil)
+			result := pingHost(host, conf, opts)
+			So(result.Err, ShouldBeNil)
+			So(result.Gateway, ShouldEqual, "")
+			So(len(result.Hops), ShouldEqual, 1)
+			So(result.Hops[0].Name, ShouldEqual, "tcp")
+			So(result.Server, ShouldBeNil)
+
+			opts.Probe = "banner"
+			result = pingHost(host, conf, opts)
+			So(result.Err, ShouldBeNil)
+			So(len(result.Hops), ShouldEqual, 2)
+			So(result.Hops[1].Name, ShouldEqual, "banner")
+			So(result.Server.Banner.Software, ShouldEqual, "assh")
+			So(result.Server.Banner.Version, ShouldEqual, "test")
+			So(result.serverString(), ShouldEqual, "server=assh_test")
+		})
+
+		Convey("with a ProxyCommand", func() {
+			host, err := computeHost("proxycommand-host", 0, conf)
+			So(err, ShouldBeNil)
+			result := pingHost(host, conf, opts)
+			So(result.Err, ShouldBeNil)
+			So(len(result.Hops), ShouldEqual, 1)
+			So(result.Hops[0].Name, ShouldEqual, "proxycommand")
+			So(result.Server.Banner.SoftwareVersion, ShouldEqual, "proxycommand")
+
+			host, err = computeHost("silent-proxycommand-host", 0, conf)
+			So(err, ShouldBeNil)
+			result = pingHost(host, conf, pingOptions{Timeout: 100 * time.Millisecond, Probe: "tcp"})
+			So(result.Err, ShouldNotBeNil)
+			So(result.Err.Error(), ShouldContainSubstring, "did not answer")
+		})
+
+		Convey("with gateways", func() {
+			host, err := computeHost("gateway-host", 0, conf)
+			So(err, ShouldBeNil)
+			result := pingHost(host, conf, opts)
+			So(result.Err, ShouldBeNil)
+			So(result.Gateway, ShouldEqual, "direct")
+
+			host, err = computeHost("unreachable-host", 0, conf)
+			So(err, ShouldBeNil)
+			result = pingHost(host, conf, opts)
+			So(result.Err, ShouldNotBeNil)
+			So(result.Err.Error(), ShouldStartWith, "no such available gateway")
+		})
+	})
+}
@@ -27,7 +27,9 @@ import (
 	"go.uber.org/zap"
 	"golang.org/x/time/rate"
 	"moul.io/assh/v2/pkg/config"
+	"moul.io/assh/v2/pkg/hooks"
 	"moul.io/assh/v2/pkg/ratelimit"
+	"moul.io/assh/v2/pkg/upstream"
 )
 
 type contextKey string
@@ -35,6 +37,7 @@ type contextKey string
 type gatewayErrorMsg struct {
 	gateway string
 	err     zap.Field
+	cause   error
 }
 
 var syncContextKey contextKey = "sync"
@@ -89,19 +92,24 @@ func runProxyCommand(cmd *cobra.Command, args []string) error {
 	}
 	if isOutdated {
 		if automaticRewrite {
-			// BeforeConfigWrite
-			type configWriteHookArgs struct {
-				SSHConfigPath string
-			}
-			hookArgs := configWriteHookArgs{
+			// BeforeConfigWrite, the hooks of the target host are called after the ones of the defaults
+			targetHost := conf.GetHostSafe(target)
+			hookArgs := ConfigWriteHookArgs{
 				SSHConfigPath: conf.SSHConfigPath(),
+				Host:          targetHost,
+			}
+			configWriteHooks := []*config.HostHooks{conf.Defaults.Hooks}
+			if targetHost.Hooks != conf.Defaults.Hooks {
+				configWriteHooks = append(configWriteHooks, targetHost.Hooks)
 			}
 
 			logger().Debug("Calling BeforeConfigWrite hooks")
-			if drivers, err := conf.Defaults.Hooks.BeforeConfigWrite.InvokeAll(hookArgs); err != nil {
-				logger().Error("BeforeConfigWrite hook failed", zap.Error(err))
-			} else {
-				defer drivers.Close()
+			for _, hostHooks := range configWriteHooks {
+				if drivers, err := hostHooks.BeforeConfigWrite.InvokeAll("BeforeConfigWrite", hookArgs); err != nil {
+					logger().Error("BeforeConfigWrite hook failed", zap.Error(err))
+				} else {
+					defer drivers.Close()
+				}
 			}
 
 			// Save
@@ -114,10 +122,12 @@ func runProxyCommand(cmd *cobra.Command, args []string) error {
 
 			// AfterConfigWrite
 			logger().Debug("Calling AfterConfigWrite hooks")
-			if drivers, err := conf.Defaults.Hooks.AfterConfigWrite.InvokeAll(hookArgs); err != nil {
-				logger().Error("AfterConfigWrite hook failed", zap.Error(err))
-			} else {
-				defer drivers.Close()
+			for _, hostHooks := range configWriteHooks {
+				if drivers, err := hostHooks.AfterConfigWrite.InvokeAll("AfterConfigWrite", hookArgs); err != nil {
+					logger().Error("AfterConfigWrite hook failed", zap.Error(err))
+				} else {
+					defer drivers.Close()
+				}
 			}
 		} else {
 			logger().Warn("The configuration file is outdated; you need to run `assh config build --no-automatic-rewrite > ~/.ssh/config` to stay updated")
@@ -144,9 +154,22 @@ func runProxyCommand(cmd *cobra.Command, args []string) error {
 	}
 
 	logger().Debug("Proxying")
-	return proxy(host, conf, dryRun)
+	start := time.Now()
+	gateway, err := proxy(host, conf, dryRun)
+	if !dryRun {
+		saveConnectionAttempt(conf, target, host, gateway, start, err)
+	}
+	return err
+}
+
+// vetoError is returned when a BeforeConnect hook aborts the connection, the next gateways are not tried
+type vetoError struct {
+	error
 }
 
+func (e vetoError) Cause() error  { return e.error }
+func (e vetoError) Unwrap() error { return e.error }
+
 // nolint:unparam
 func computeHost(dest string, portOverride int, conf *config.Config) (*config.Host, error) {
 	host := conf.GetHostSafe(dest)
@@ -224,46 +247,58 @@ func prepareHostControlPath(host *config.Host) error {
 	return os.MkdirAll(controlPathDir, 0o700)
 }
 
-func proxy(host *config.Host, conf *config.Config, dryRun bool) error {
+// proxy connects to host using the first available gateway and returns the gateway used
+func proxy(host *config.Host, conf *config.Config, dryRun bool) (string, error) {
 	if err := prepareHostControlPath(host.Clone()); err != nil {
-		return errors.Wrap(err, "failed to prepare host control-path")
+		return "", errors.Wrap(err, "failed to prepare host control-path")
+	}
+
+	// the retry policy, including its deadline, is shared by all the gateways
+	policy, err := newRetryPolicy(host, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	// the relay goes through the gateways by itself, to reconnect when the connection drops
+	if host.RelayPort != "" {
+		return "relay", proxyGo(host, conf, "relay", dryRun, policy)
 	}
 
 	if len(host.Gateways) > 0 {
-		logger().Debug("Trying gateways", zap.String("gateways", strings.Join(host.Gateways, ", ")))
+		logger().Debug("Trying gateways", zap.String("gateways", strings.Join(redactGateways(host.Gateways), ", ")))
 		var gatewayErrors []gatewayErrorMsg
-		for _, gateway := range host.Gateways {
-			if gateway == "direct" {
-				if err := proxyDirect(host, dryRun); err != nil {
+		for idx, gateway := range host.Gateways {
+			if idx > 0 {
+				drivers := onGatewayFallback(host, gatewayErrors[len(gatewayErrors)-1], gateway)
+				defer drivers.Close()
+			}
+			if upstream.IsProxy(gateway) {
+				// the upstream proxies are dialed natively, the ProxyCommand of the host is not used
+				if err := proxyGo(host, conf, gateway, dryRun, policy); errors.As(err, &vetoError{}) {
+					return "", err
+				} else if err != nil {
+					gatewayErrors = append(gatewayErrors, gatewayErrorMsg{
+						gateway: upstream.Redact(gateway), err: zap.Error(err), cause: err,
+					})
+				} else {
+					return upstream.Redact(gateway), nil
+				}
+			} else if gateway == "direct" {
+				if err := proxyDirect(host, conf, gateway, dryRun, policy); errors.As(err, &vetoError{}) {
+					return "", err
+				} else if err != nil {
 					gatewayErrors = append(gatewayErrors, gatewayErrorMsg{
-						gateway: "direct", err: zap.Error(err),
+						gateway: "direct", err: zap.Error(err), cause: err,
 					})
 				} else {
-					return nil
+					return gateway, nil
 				}
 			} else {
-				hostCopy := host.Clone()
 				gatewayHost := conf.GetGatewaySafe(gateway)
 
-				if err := prepareHostControlPath(hostCopy); err != nil {
-					return errors.Wrap(err, "failed to prepare host control-path")
-				}
-
-				// FIXME: dynamically add "-v" flags
-
-				var command string
-
-				// FIXME: detect ssh client version and use netcat if too old
-				// for now, the workaround is to configure the ProxyCommand of the host to "nc %h %p"
-
-				if err := hostPrepare(hostCopy, gateway); err != nil {
-					return errors.Wrap(err, "failed to prepare host for gateway")
-				}
-
-				if hostCopy.ProxyCommand != "" {
-					command = "ssh %name -- " + hostCopy.ExpandString(hostCopy.ProxyCommand, gateway)
-				} else {
-					command = hostCopy.ExpandString("ssh -W %h:%p ", "") + "%name"
+				command, _, err := gatewayCommand(host, gateway, policy)
+				if err != nil {
+					return "", err
 				}
 
 				logger().Debug(
@@ -271,12 +306,12 @@ func proxy(host *config.Host, conf *config.Config, dryRun bool) error {
 					zap.String("gateway", gateway),
 					zap.String("command", command),
 				)
-				if err := runProxy(gatewayHost, command, dryRun); err != nil {
+				if err := runProxy(gatewayHost, command, dryRun, policy, gateway); err != nil {
 					gatewayErrors = append(gatewayErrors, gatewayErrorMsg{
-						gateway: gateway, err: zap.Error(err),
+						gateway: gateway, err: zap.Error(err), cause: err,
 					})
 				} else {
-					return nil
+					return gateway, nil
 				}
 			}
 		}
@@ -291,21 +326,55 @@ func proxy(host *config.Host, conf *config.Config, dryRun bool) error {
 						errMsg.gateway, conType), errMsg.err)
 			}
 		}
-		return errors.New("no such available gateway")
+		return "", errors.New("no such available gateway")
 	}
 
 	logger().Debug("Connecting without gateway")
-	return proxyDirect(host, dryRun)
+	return "", proxyDirect(host, conf, "", dryRun, policy)
+}
+
+// redactGateways returns the gateways with the passwords of the upstream proxies masked, to be logged or saved
+func redactGateways(gateways []string) []string {
+	redacted := make([]string, 0, len(gateways))
+	for _, gateway := range gateways {
+		redacted = append(redacted, upstream.Redact(gateway))
+	}
+	return redacted
+}
+
+// gatewayCommand returns the command used to reach host through gateway and the prepared host,
+// the returned command still needs to be expanded against the gateway host
+func gatewayCommand(host *config.Host, gateway string, policy *retryPolicy) (string, *config.Host, error) {
+	hostCopy := host.Clone()
+
+	if err := prepareHostControlPath(hostCopy); err != nil {
+		return "", nil, errors.Wrap(err, "failed to prepare host control-path")
+	}
+
+	// FIXME: dynamically add "-v" flags
+
+	// FIXME: detect ssh client version and use netcat if too old
+	// for now, the workaround is to configure the ProxyCommand of the host to "nc %h %p"
+
+	if err := hostPrepare(hostCopy, gateway, policy); err != nil {
+		return "", nil, errors.Wrap(err, "failed to prepare host for gateway")
+	}
+
+	if hostCopy.ProxyCommand != "" {
+		return "ssh %name -- " + hostCopy.ExpandString(hostCopy.ProxyCommand, gateway), hostCopy, nil
+	}
+	return hostCopy.ExpandString("ssh -W %h:%p ", "") + "%name", hostCopy, nil
 }
 
-func proxyDirect(host *config.Host, dryRun bool) error {
+func proxyDirect(host *config.Host, conf *config.Config, gateway string, dryRun bool, policy *retryPolicy) error {
 	if host.ProxyCommand != "" {
-		return runProxy(host, host.ProxyCommand, dryRun)
+		return runProxy(host, host.ProxyCommand, dryRun, policy, gateway)
 	}
-	return proxyGo(host, dryRun)
+	return proxyGo(host, conf, gateway, dryRun, policy)
 }
 
-func runProxy(host *config.Host, command string, dryRun bool) error {
+// runProxy runs command as the connection to the host, it is retried according to policy until it answers
+func runProxy(host *config.Host, command string, dryRun bool, policy *retryPolicy, gateway string) error {
 	command = host.ExpandString(command, "")
 	logger().Debug("ProxyCommand", zap.String("command", command))
 	args, err := shlex.Split(command)
@@ -317,14 +386,89 @@ func runProxy(host *config.Host, command string, dryRun bool) error {
 		return fmt.Errorf("dry-run: Execute %s", args)
 	}
 
-	spawn := exec.Command(args[0], args[1:]...) // #nosec
-	spawn.Stdout = os.Stdout
-	spawn.Stdin = os.Stdin
-	spawn.Stderr = os.Stderr
-	return spawn.Run()
+	return policy.run(retryStepGateway, gateway, 0, func(timeout time.Duration) error {
+		spawn := exec.Command(args[0], args[1:]...) // #nosec
+		spawn.Stdin = os.Stdin
+		spawn.Stderr = os.Stderr
+		if timeout <= 0 && (policy == nil || policy.attempts <= 1) {
+			spawn.Stdout = os.Stdout
+			return spawn.Run()
+		}
+		// the output is watched to know if the command answered
+		return runWatched(spawn, timeout)
+	})
+}
+
+// runWatched runs spawn, forwarding its output to the standard output; spawn is killed if it does not write
+// anything during timeout. Its failure can only be retried if it wrote nothing.
+func runWatched(spawn *exec.Cmd, timeout time.Duration) error {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	spawn.Stdout = writer
+	err = spawn.Start()
+	// the write end of the pipe is only used by the child process
+	_ = writer.Close()
+	if err != nil {
+		return err
+	}
+
+	output := &watchedReader{Reader: reader, answered: make(chan struct{})}
+	copied := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(os.Stdout, output)
+		close(copied)
+	}()
+	exited := make(chan error, 1)
+	go func() { exited <- spawn.Wait() }()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-output.answered:
+		err = <-exited
+	case err = <-exited:
+	case <-expired:
+		_ = spawn.Process.Kill()
+		<-exited
+		// the children of the command may still hold its output
+		_ = reader.Close()
+		<-copied
+		return errors.Errorf("no answer within %s", timeout)
+	}
+	<-copied
+	select {
+	case <-output.answered:
+		if err != nil {
+			return noRetryError{err}
+		}
+	default:
+	}
+	return err
 }
 
-func hostPrepare(host *config.Host, gateway string) error {
+// watchedReader closes answered once data is read
+type watchedReader struct {
+	io.Reader
+	answered chan struct{}
+	once     sync.Once
+}
+
+func (r *watchedReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.once.Do(func() { close(r.answered) })
+	}
+	return n, err
+}
+
+func hostPrepare(host *config.Host, gateway string, policy *retryPolicy) error {
 	if host.HostName == "" {
 		host.HostName = host.Name()
 	}
@@ -340,10 +484,13 @@ func hostPrepare(host *config.Host, gateway string) error {
 		if err != nil {
 			return err
 		}
+		oldHostName := host.HostName
 		if len(results) > 0 {
 			host.HostName = results[0]
 		}
 		logger().Debug("Resolved host", zap.String("hostname", host.HostName))
+		drivers := onResolve(host, "dns", oldHostName, gateway)
+		defer drivers.Close()
 	}
 
 	if host.ResolveCommand != "" {
@@ -359,39 +506,81 @@ func hostPrepare(host *config.Host, gateway string) error {
 			return err
 		}
 
-		cmd := exec.Command(args[0], args[1:]...) // #nosec
 		var stdout bytes.Buffer
-		var stderr bytes.Buffer
-		cmd.Stdout = &stdout
-		cmd.Stderr = &stderr
-		if err := cmd.Run(); err != nil {
-			return errors.Wrap(err, "failed to run resolve-command")
+		err = policy.run(retryStepResolve, gateway, 0, func(timeout time.Duration) error {
+			ctx := context.Background()
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+			cmd := exec.CommandContext(ctx, args[0], args[1:]...) // #nosec
+			// the children of the command may still hold its output once it is killed
+			cmd.WaitDelay = 100 * time.Millisecond
+			var stderr bytes.Buffer
+			stdout.Reset()
+			cmd.Stdout = &stdout
+			cmd.Stderr = &stderr
+			if err := cmd.Run(); err != nil {
+				if ctx.Err() != nil {
+					return errors.Errorf("failed to run resolve-command: no answer within %s", timeout)
+				}
+				return errors.Wrap(err, "failed to run resolve-command")
+			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
 
+		oldHostName := host.HostName
 		host.HostName = strings.TrimSpace(stdout.String())
 		logger().Debug("Resolved host", zap.String("hostname", host.HostName))
+		drivers := onResolve(host, "command", oldHostName, gateway)
+		defer drivers.Close()
 	}
 	return nil
 }
 
-type exportReadWrite struct {
-	written uint64
-	err     error
-}
-
 // ConnectionStats contains network and timing informations about a connection
 type ConnectionStats struct {
+	// WrittenBytes is the number of bytes received from the host and written to the ssh client,
+	// it is kept for compatibility and is the same as ReceivedBytes
 	WrittenBytes            uint64
 	WrittenBytesHuman       string
+	SentBytes               uint64
+	SentBytesHuman          string
+	ReceivedBytes           uint64
+	ReceivedBytesHuman      string
+	Gateway                 string
 	CreatedAt               time.Time
 	ConnectedAt             time.Time
 	DisconnectedAt          time.Time
+	TimeToFirstByte         time.Duration
 	ConnectionDuration      time.Duration
 	ConnectionDurationHuman string
 	AverageSpeed            float64
 	AverageSpeedHuman       string
 }
 
+// disconnected computes the duration, the average speed and the human versions of the statistics of a connection
+// closed at
+func (c *ConnectionStats) disconnected(at time.Time) {
+	c.DisconnectedAt = at
+	c.ConnectionDuration = c.DisconnectedAt.Sub(c.ConnectedAt)
+	averageSpeed := float64(c.WrittenBytes) / c.ConnectionDuration.Seconds()
+	// round duraction
+	c.ConnectionDuration = ((c.ConnectionDuration + time.Second/2) / time.Second) * time.Second
+	c.AverageSpeed = math.Ceil(averageSpeed*1000) / 1000
+	// human
+	c.WrittenBytesHuman = humanize.Bytes(c.WrittenBytes)
+	c.SentBytesHuman = humanize.Bytes(c.SentBytes)
+	c.ReceivedBytesHuman = humanize.Bytes(c.ReceivedBytes)
+	connectionDurationHuman := humanize.RelTime(c.DisconnectedAt, c.ConnectedAt, "", "")
+	c.ConnectionDurationHuman = strings.ReplaceAll(connectionDurationHuman, "now", "0 sec")
+	c.AverageSpeedHuman = humanize.Bytes(uint64(c.AverageSpeed)) + "/s"
+}
+
 func (c *ConnectionStats) String() string {
 	b, err := json.Marshal(c)
 	if err != nil {
@@ -406,6 +595,10 @@ type ConnectHookArgs struct {
 	Host  *config.Host
 	Stats *ConnectionStats
 	Error string
+	// Reason is the reason of the disconnection, i.e: "client-closed" or "idle-timeout"
+	Reason string `json:",omitempty"`
+	// DisconnectIn is the time left before a forced disconnection, given to the BeforeDisconnect hooks
+	DisconnectIn time.Duration `json:",omitempty"`
 }
 
 func (c ConnectHookArgs) String() string {
@@ -417,9 +610,83 @@ func (c ConnectHookArgs) String() string {
 	return string(b)
 }
 
-func proxyGo(host *config.Host, dryRun bool) error {
+// ConfigWriteHookArgs is the structure sent to the BeforeConfigWrite and AfterConfigWrite hooks
+type ConfigWriteHookArgs struct {
+	SSHConfigPath string
+	// Host is the host being connected to when the configuration is rewritten
+	Host *config.Host
+}
+
+// ResolveHookArgs is the structure sent to the OnResolve hooks, once the HostName of a host is resolved
+type ResolveHookArgs struct {
+	Host *config.Host
+	// Method is "dns" for the ResolveNameservers, or "command" for the ResolveCommand
+	Method string
+	// OldHostName is the HostName before the resolution, HostName the resolved one
+	OldHostName string
+	HostName    string
+	// Gateway is the gateway the host is resolved for, empty for a direct connection
+	Gateway string `json:",omitempty"`
+}
+
+// GatewayFallbackHookArgs is the structure sent to the OnGatewayFallback hooks, when a gateway failed and the next
+// one is tried
+type GatewayFallbackHookArgs struct {
+	Host *config.Host
+	// Gateway is the failed gateway and Error its error, NextGateway is the gateway tried next
+	Gateway     string
+	Error       string
+	NextGateway string
+}
+
+// IdleHookArgs is the structure sent to the OnIdle hooks, when nothing was transferred for the IdleDelay of the host
+type IdleHookArgs struct {
+	Host  *config.Host
+	Stats *ConnectionStats
+	// IdleFor is the time since the last transferred data
+	IdleFor time.Duration
+}
+
+// onResolve calls the OnResolve hooks, they are closed once the host is prepared
+func onResolve(host *config.Host, method string, oldHostName string, gateway string) hooks.HookDrivers {
+	args := ResolveHookArgs{
+		Host:        host,
+		Method:      method,
+		OldHostName: oldHostName,
+		HostName:    host.HostName,
+		Gateway:     upstream.Redact(gateway),
+	}
+	logger().Debug("Calling OnResolve hooks")
+	drivers, err := host.Hooks.OnResolve.InvokeAll("OnResolve", args)
+	if err != nil {
+		logger().Error("OnResolve hook failed", zap.Error(err))
+	}
+	return drivers
+}
+
+// onGatewayFallback calls the OnGatewayFallback hooks before trying the next gateway, they are closed once the
+// gateways are tried
+func onGatewayFallback(host *config.Host, failed gatewayErrorMsg, next string) hooks.HookDrivers {
+	args := GatewayFallbackHookArgs{
+		Host:        host,
+		Gateway:     failed.gateway,
+		NextGateway: upstream.Redact(next),
+	}
+	if failed.cause != nil {
+		args.Error = failed.cause.Error()
+	}
+	logger().Debug("Calling OnGatewayFallback hooks")
+	drivers, err := host.Hooks.OnGatewayFallback.InvokeAll("OnGatewayFallback", args)
+	if err != nil {
+		logger().Error("OnGatewayFallback hook failed", zap.Error(err))
+	}
+	return drivers
+}
+
+func proxyGo(host *config.Host, conf *config.Config, gateway string, dryRun bool, policy *retryPolicy) error {
 	stats := ConnectionStats{
 		CreatedAt: time.Now(),
+		Gateway:   upstream.Redact(gateway),
 	}
 	connectHookArgs := ConnectHookArgs{
 		Host:  host,
@@ -427,18 +694,30 @@ func proxyGo(host *config.Host, dryRun bool) error {
 	}
 
 	logger().Debug("Preparing host object")
-	if err := hostPrepare(host, ""); err != nil {
+	if err := hostPrepare(host, "", policy); err != nil {
 		return errors.Wrap(err, "failed to prepare host")
 	}
 
+	limits, err := newSessionLimits(host)
+	if err != nil {
+		return err
+	}
+
 	if dryRun {
+		if host.RelayPort != "" {
+			return fmt.Errorf("dry-run: Resumable connection to the assh relay on '%s:%s'", host.HostName, host.RelayPort)
+		}
+		if upstream.IsProxy(gateway) {
+			return fmt.Errorf("dry-run: Golang native TCP connection to '%s:%s' through proxy '%s'", host.HostName, host.Port, upstream.Redact(gateway))
+		}
 		return fmt.Errorf("dry-run: Golang native TCP connection to '%s:%s'", host.HostName, host.Port)
 	}
 
 	// BeforeConnect hook
 	logger().Debug("Calling BeforeConnect hooks")
-	if drivers, err := host.Hooks.BeforeConnect.InvokeAll(connectHookArgs); err != nil {
-		logger().Error("BeforeConnect hook failed", zap.Error(err))
+	if drivers, err := host.Hooks.BeforeConnect.InvokeAll("BeforeConnect", connectHookArgs); err != nil {
+		// only the hooks with the abort failure mode, or vetoed by a plugin, return an error
+		return vetoError{errors.Wrap(err, "connection vetoed by a BeforeConnect hook")}
 	} else {
 		defer drivers.Close()
 	}
@@ -453,20 +732,30 @@ func proxyGo(host *config.Host, dryRun bool) error {
 	if timeout < 0 { // set to 0 to disable
 		timeout = 0
 	}
-	conn, err := net.DialTimeout(
-		"tcp",
-		fmt.Sprintf("%s:%s", host.HostName, host.Port),
-		time.Duration(timeout)*time.Second,
-	)
+	var conn io.ReadWriteCloser
+	err = policy.run(retryStepDial, gateway, time.Duration(timeout)*time.Second, func(timeout time.Duration) error {
+		var err error
+		switch {
+		case host.RelayPort != "":
+			conn, err = dialRelay(host, conf, timeout)
+		case upstream.IsProxy(gateway):
+			conn, err = upstream.Dial(gateway, net.JoinHostPort(host.HostName, host.Port), timeout)
+		default:
+			conn, err = net.DialTimeout("tcp", fmt.Sprintf("%s:%s", host.HostName, host.Port), timeout)
+		}
+		return err
+	})
 	if err != nil {
 		// OnConnectError hook
 		connectHookArgs.Error = err.Error()
 		logger().Debug("Calling OnConnectError hooks")
-		if drivers, err := host.Hooks.OnConnectError.InvokeAll(connectHookArgs); err != nil {
+		if drivers, err := host.Hooks.OnConnectError.InvokeAll("OnConnectError", connectHookArgs); err != nil {
 			logger().Error("OnConnectError hook failed", zap.Error(err))
 		} else {
 			defer drivers.Close()
 		}
+		stats.DisconnectedAt = time.Now()
+		saveConnectionMetrics(conf, host, &stats, err)
 
 		return errors.Wrap(err, "failed to dial")
 	}
@@ -479,7 +768,7 @@ func proxyGo(host *config.Host, dryRun bool) error {
 
 	// OnConnect hook
 	logger().Debug("Calling OnConnect hooks")
-	if drivers, err := host.Hooks.OnConnect.InvokeAll(connectHookArgs); err != nil {
+	if drivers, err := host.Hooks.OnConnect.InvokeAll("OnConnect", connectHookArgs); err != nil {
 		logger().Error("OnConnect hook failed", zap.Error(err))
 	} else {
 		defer drivers.Close()
@@ -501,99 +790,176 @@ func proxyGo(host *config.Host, dryRun bool) error {
 	var writer io.Writer
 	reader = conn
 	writer = conn
-	if host.RateLimit != "" {
-		bytes, err := humanize.ParseBytes(host.RateLimit)
+	// RateLimit is shared by both directions, RateLimitDown and RateLimitUp apply on top of it
+	for _, limit := range []struct {
+		name, value string
+	}{
+		{"RateLimit", host.RateLimit},
+		{"RateLimitDown", host.RateLimitDown},
+		{"RateLimitUp", host.RateLimitUp},
+	} {
+		if limit.value == "" {
+			continue
+		}
+		limiter, err := newRateLimiter(limit.value, host.RateLimitBurst)
 		if err != nil {
-			return errors.Wrap(err, "failed to parse rate limit configuration")
+			return errors.Wrapf(err, "failed to parse %s configuration", limit.name)
+		}
+		if limit.name != "RateLimitUp" {
+			reader = ratelimit.NewReaderWithContext(ctx, reader, limiter)
+		}
+		if limit.name != "RateLimitDown" {
+			writer = ratelimit.NewWriterWithContext(ctx, writer, limiter)
 		}
-		limit := rate.Limit(float64(bytes))
-		limiter := rate.NewLimiter(limit, int(bytes))
-		reader = ratelimit.NewReader(conn, limiter)
-		writer = ratelimit.NewWriter(conn, limiter)
 	}
-
-	c1 := readAndWrite(ctx, reader, os.Stdout)
-	c2 := readAndWrite(ctx, os.Stdin, writer)
-	select {
-	case result = <-c1:
-		stats.WrittenBytes = result.written
-	case result = <-c2:
+	// the global budget is shared with the connections of the other assh processes
+	if share := joinBandwidthBudget(conf, host); share != nil {
+		defer share.Close()
+		reader, writer = share.Wrap(ctx, reader, writer)
+	}
+
+	// IdleTimeout and MaxSessionDuration, the BeforeDisconnect hooks are called shortly before closing the connection;
+	// the OnIdle hooks are called once the connection is idle for IdleDelay
+	watcher := newSessionWatcher(limits, stats.ConnectedAt)
+	// the drivers of the BeforeDisconnect and OnIdle hooks are closed with the connection
+	var sessionDrivers []hooks.HookDrivers
+	var sessionDriversMutex sync.Mutex
+	defer func() {
+		sessionDriversMutex.Lock()
+		defer sessionDriversMutex.Unlock()
+		for _, drivers := range sessionDrivers {
+			drivers.Close()
+		}
+	}()
+	forcedDisconnect, stopWatcher := watcher.watch(func(event sessionEvent) {
+		var drivers hooks.HookDrivers
+		var err error
+		if event.idle {
+			logger().Debug("Calling OnIdle hooks", zap.Duration("idle", event.idleFor))
+			if drivers, err = host.Hooks.OnIdle.InvokeAll("OnIdle", IdleHookArgs{Host: host, Stats: &stats, IdleFor: event.idleFor}); err != nil {
+				logger().Error("OnIdle hook failed", zap.Error(err))
+				return
+			}
+		} else {
+			logger().Warn(
+				"The connection will be closed soon",
+				zap.String("reason", event.reason),
+				zap.Duration("in", event.in.Round(time.Second)),
+			)
+			warningArgs := connectHookArgs
+			warningArgs.Reason = event.reason
+			warningArgs.DisconnectIn = event.in
+			logger().Debug("Calling BeforeDisconnect hooks")
+			if drivers, err = host.Hooks.BeforeDisconnect.InvokeAll("BeforeDisconnect", warningArgs); err != nil {
+				logger().Error("BeforeDisconnect hook failed", zap.Error(err))
+				return
+			}
+		}
+		sessionDriversMutex.Lock()
+		sessionDrivers = append(sessionDrivers, drivers)
+		sessionDriversMutex.Unlock()
+	})
+
+	// the end of each direction is propagated to the other side: the ssh client reads an EOF once the host closed
+	// the connection, and the host reads an EOF once the ssh client closed its output, if the connection supports it
+	c1 := splice(ctx, os.Stdout, watcher.reader(reader), os.Stdout.Close)
+	c2 := splice(ctx, writer, watcher.reader(os.Stdin), closeWrite(conn))
+	var received, sent exportReadWrite
+wait:
+	for {
+		select {
+		case received = <-c1:
+			result = received
+			connectHookArgs.Reason = disconnectRemote
+			break wait
+		case sent = <-c2:
+			if sent.err == nil && sent.halfClosed {
+				// the host may still be sending its answer
+				logger().Debug("The client closed its output, waiting for the host", zap.Uint64("sent", sent.written))
+				c2 = nil
+				continue
+			}
+			result = sent
+			connectHookArgs.Reason = disconnectClient
+			break wait
+		case connectHookArgs.Reason = <-forcedDisconnect:
+			logger().Warn("Closing the connection", zap.String("reason", connectHookArgs.Reason))
+			break wait
+		}
 	}
-	if result.err != nil && result.err == io.EOF {
-		result.err = nil
+	stopWatcher()
+	if result.err != nil {
+		connectHookArgs.Reason = disconnectError
 	}
 
 	if err := conn.Close(); err != nil {
 		return err
 	}
+	if connectHookArgs.Reason == disconnectIdle || connectHookArgs.Reason == disconnectMaxDuration {
+		// the ssh client is not aware of the disconnection until its ProxyCommand output is closed,
+		// it then closes the standard input read by the other direction
+		_ = os.Stdout.Close()
+	}
+	// interrupt the pending read of the standard input, if it supports deadlines
+	_ = os.Stdin.SetReadDeadline(time.Now())
 	waitGroup.Wait()
 	select {
-	case res := <-c1:
-		stats.WrittenBytes = res.written
+	case received = <-c1:
+	default:
+	}
+	select {
+	case sent = <-c2:
 	default:
 	}
+	stats.ReceivedBytes = received.written
+	stats.SentBytes = sent.written
+	stats.WrittenBytes = stats.ReceivedBytes
+	if !received.firstByteAt.IsZero() {
+		stats.TimeToFirstByte = received.firstByteAt.Sub(stats.ConnectedAt)
+	}
 
-	stats.DisconnectedAt = time.Now()
-	stats.ConnectionDuration = stats.DisconnectedAt.Sub(stats.ConnectedAt)
-	averageSpeed := float64(stats.WrittenBytes) / stats.ConnectionDuration.Seconds()
-	// round duraction
-	stats.ConnectionDuration = ((stats.ConnectionDuration + time.Second/2) / time.Second) * time.Second
-	stats.AverageSpeed = math.Ceil(averageSpeed*1000) / 1000
-	// human
-	stats.WrittenBytesHuman = humanize.Bytes(stats.WrittenBytes)
-	connectionDurationHuman := humanize.RelTime(stats.DisconnectedAt, stats.ConnectedAt, "", "")
-	stats.ConnectionDurationHuman = strings.ReplaceAll(connectionDurationHuman, "now", "0 sec")
-	stats.AverageSpeedHuman = humanize.Bytes(uint64(stats.AverageSpeed)) + "/s"
+	stats.disconnected(time.Now())
 
 	// OnDisconnect hook
 	logger().Debug("Calling OnDisconnect hooks")
-	if drivers, err := host.Hooks.OnDisconnect.InvokeAll(connectHookArgs); err != nil {
+	if drivers, err := host.Hooks.OnDisconnect.InvokeAll("OnDisconnect", connectHookArgs); err != nil {
 		logger().Error("OnDisconnect hook failed", zap.Error(err))
 	} else {
 		defer drivers.Close()
 	}
 
+	saveConnectionMetrics(conf, host, &stats, result.err)
+
 	logger().Debug(
 		"Connection finished",
-		zap.Uint64("bytes written", stats.WrittenBytes),
+		zap.Uint64("bytes sent", stats.SentBytes),
+		zap.Uint64("bytes received", stats.ReceivedBytes),
 		zap.Error(result.err),
 	)
 	return result.err
 }
 
-func readAndWrite(ctx context.Context, r io.Reader, w io.Writer) <-chan exportReadWrite {
-	buff := make([]byte, 1024)
-	c := make(chan exportReadWrite, 1)
-
-	go func() {
-		defer ctx.Value(syncContextKey).(*sync.WaitGroup).Done()
-
-		export := exportReadWrite{}
-		for {
-			select {
-			case <-ctx.Done():
-				c <- export
-				return
-			default:
-				nr, err := r.Read(buff)
-				if err != nil {
-					export.err = err
-					c <- export
-					return
-				}
-				if nr > 0 {
-					wr, err := w.Write(buff[:nr])
-					if err != nil {
-						export.err = err
-						c <- export
-						return
-					}
-					if wr > 0 {
-						export.written += uint64(wr)
-					}
-				}
-			}
+// newRateLimiter returns a limiter allowing value bytes per second,
+// the burst defaults to one second of traffic
+func newRateLimiter(value, burst string) (*rate.Limiter, error) {
+	bytes, err := humanize.ParseBytes(value)
+	if err != nil {
+		return nil, err
+	}
+	if bytes == 0 {
+		return nil, errors.New("the rate limit must be positive")
+	}
+	burstBytes := bytes
+	if burst != "" {
+		if burstBytes, err = humanize.ParseBytes(burst); err != nil {
+			return nil, errors.Wrap(err, "failed to parse RateLimitBurst configuration")
 		}
-	}()
-	return c
+		if burstBytes == 0 {
+			return nil, errors.New("the rate limit burst must be positive")
+		}
+	}
+	if burstBytes > math.MaxInt32 {
+		burstBytes = math.MaxInt32
+	}
+	return rate.NewLimiter(rate.Limit(float64(bytes)), int(burstBytes)), nil
 }
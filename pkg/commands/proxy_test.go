@@ -2,6 +2,8 @@ package commands
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -71,16 +73,16 @@ func Test_runProxy(t *testing.T) {
 		host, err := computeHost("aaa", 0, config)
 		So(err, ShouldBeNil)
 
-		err = runProxy(host, "echo test from proxyCommand", false)
+		err = runProxy(host, "echo test from proxyCommand", false, nil, "")
 		So(err, ShouldBeNil)
 
-		err = runProxy(host, "/bin/sh -c 'echo test from proxyCommand'", false)
+		err = runProxy(host, "/bin/sh -c 'echo test from proxyCommand'", false, nil, "")
 		So(err, ShouldBeNil)
 
-		err = runProxy(host, "/bin/sh -c 'exit 1'", false)
+		err = runProxy(host, "/bin/sh -c 'exit 1'", false, nil, "")
 		So(err, ShouldNotBeNil)
 
-		err = runProxy(host, "blah", true)
+		err = runProxy(host, "blah", true, nil, "")
 		So(err, ShouldResemble, fmt.Errorf("dry-run: Execute [blah]"))
 	})
 }
@@ -94,19 +96,64 @@ func Test_hostPrepare(t *testing.T) {
 		host, err := computeHost("aaa", 0, config)
 		So(err, ShouldBeNil)
 		So(host.HostName, ShouldEqual, "1.2.3.4")
-		So(hostPrepare(host, ""), ShouldBeNil)
+		So(hostPrepare(host, "", nil), ShouldBeNil)
 		So(host.HostName, ShouldEqual, "1.2.3.4")
 
 		host, err = computeHost("bbb", 0, config)
 		So(err, ShouldBeNil)
 		So(host.HostName, ShouldEqual, "bbb")
-		So(hostPrepare(host, ""), ShouldBeNil)
+		So(hostPrepare(host, "", nil), ShouldBeNil)
 		So(host.HostName, ShouldEqual, "bbb")
 
 		host, err = computeHost("eee", 0, config)
 		So(err, ShouldBeNil)
 		So(host.HostName, ShouldEqual, "eee")
-		So(hostPrepare(host, ""), ShouldBeNil)
+		So(hostPrepare(host, "", nil), ShouldBeNil)
 		So(host.HostName, ShouldEqual, "42.42.42.42")
 	})
 }
+
+func Test_hostPrepare_OnResolve(t *testing.T) {
+	Convey("Testing the OnResolve hooks of hostPrepare()", t, func() {
+		hookOutput := filepath.Join(t.TempDir(), "hooks")
+		conf := config.New()
+		So(conf.LoadConfig(strings.NewReader(fmt.Sprintf(`hosts:
+  resolved:
+    HostName: old.example.com
+    ResolveCommand: /bin/sh -c "echo 42.42.42.42"
+    Hooks:
+      OnResolve:
+      - file %s {{.Method}} {{.OldHostName}} {{.HostName}} {{.Host.Name}}
+`, hookOutput))), ShouldBeNil)
+		host := conf.GetHostSafe("resolved")
+
+		So(hostPrepare(host, "", nil), ShouldBeNil)
+		So(host.HostName, ShouldEqual, "42.42.42.42")
+		output, err := os.ReadFile(hookOutput)
+		So(err, ShouldBeNil)
+		So(string(output), ShouldEqual, "command old.example.com 42.42.42.42 resolved\n")
+	})
+}
+
+func Test_proxy_OnGatewayFallback(t *testing.T) {
+	Convey("Testing the OnGatewayFallback hooks of proxy()", t, func() {
+		hookOutput := filepath.Join(t.TempDir(), "hooks")
+		conf := config.New()
+		So(conf.LoadConfig(strings.NewReader(fmt.Sprintf(`hosts:
+  target:
+    # nothing listens on the port 1, the proxy fails immediately
+    Gateways: ["socks5://127.0.0.1:1", direct]
+    ProxyCommand: /bin/sh -c "exit 1"
+    Hooks:
+      OnGatewayFallback:
+      - file %s {{.Gateway}} {{.NextGateway}} {{.Error}}
+`, hookOutput))), ShouldBeNil)
+		host := conf.GetHostSafe("target")
+
+		_, err := proxy(host, conf, false)
+		So(err, ShouldNotBeNil)
+		output, err := os.ReadFile(hookOutput)
+		So(err, ShouldBeNil)
+		So(string(output), ShouldStartWith, "socks5://127.0.0.1:1 direct failed to dial: ")
+	})
+}
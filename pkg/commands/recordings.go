@@ -0,0 +1,185 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"golang.org/x/term"
+	"moul.io/assh/v2/pkg/config"
+	"moul.io/assh/v2/pkg/recording"
+)
+
+var recordingsCommand = &cobra.Command{
+	Use:   "recordings",
+	Short: "Manage the recordings of the terminal sessions to the hosts configured with Record",
+}
+
+var recordingsListCommand = &cobra.Command{
+	Use:   "list",
+	Short: "List the recordings, optionally filtered by host pattern",
+	RunE:  runRecordingsListCommand,
+}
+
+var recordingsPlayCommand = &cobra.Command{
+	Use:   "play",
+	Short: "Replay a recording, by name or path",
+	RunE:  runRecordingsPlayCommand,
+}
+
+// nolint:gochecknoinits
+func init() {
+	// the flags are not bound to viper, to not collide with the flags of the other commands
+	recordingsListCommand.Flags().BoolP("json", "", false, "Print the recordings as JSON")
+	recordingsPlayCommand.Flags().Float64P("speed", "", 1, "Replay speed multiplier")
+	recordingsPlayCommand.Flags().DurationP("idle-limit", "", 2*time.Second, "Maximum pause between two events, 0 to keep the recorded pauses")
+	recordingsCommand.AddCommand(recordingsListCommand, recordingsPlayCommand)
+}
+
+// startRecording creates the recording of a terminal session to host, nil if the host is not recorded.
+// failures are only logged as they should never prevent a connection
+func startRecording(conf *config.Config, host *config.Host, title string, start time.Time) *recording.Recorder {
+	if !config.BoolVal(host.Record) {
+		return nil
+	}
+	if !recording.Supported || !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		logger().Debug("Not a terminal session, the connection is not recorded")
+		return nil
+	}
+	dir, err := conf.RecordingsDir()
+	if err != nil || dir == "" {
+		logger().Warn("Cannot get the recordings directory, the connection is not recorded", zap.Error(err))
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		logger().Warn("Cannot create the recordings directory", zap.String("dir", dir), zap.Error(err))
+		return nil
+	}
+
+	if conf.RecordingsRetention != "" {
+		retention, err := recording.ParseRetention(conf.RecordingsRetention)
+		if err != nil {
+			logger().Warn("Invalid recordings retention", zap.String("retention", conf.RecordingsRetention), zap.Error(err))
+		} else if removed, err := recording.Clean(dir, retention, start); err != nil {
+			logger().Warn("Cannot remove the expired recordings", zap.String("dir", dir), zap.Error(err))
+		} else if len(removed) > 0 {
+			logger().Debug("Removed expired recordings", zap.Strings("files", removed))
+		}
+	}
+
+	width, height, _ := term.GetSize(int(os.Stdout.Fd()))
+	file := filepath.Join(dir, recording.FileName(host.Name(), start, os.Getpid()))
+	recorder, err := recording.Create(file, recording.Header{
+		Width:     width,
+		Height:    height,
+		Title:     title,
+		Env:       map[string]string{"TERM": os.Getenv("TERM"), "SHELL": os.Getenv("SHELL")},
+		Host:      host.Name(),
+		Timestamp: start.Unix(),
+	})
+	if err != nil {
+		logger().Warn("Cannot create the recording", zap.String("file", file), zap.Error(err))
+		return nil
+	}
+	logger().Debug("Recording the terminal session", zap.String("file", file))
+	return recorder
+}
+
+// recordingsDir returns the configured recordings directory
+func recordingsDir() (string, error) {
+	conf, err := config.Open(viper.GetString("config"))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to load config")
+	}
+	dir, err := conf.RecordingsDir()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get the recordings directory")
+	}
+	if dir == "" {
+		return "", errors.New("the recordings are disabled (asshrecordingsdir: none)")
+	}
+	return dir, nil
+}
+
+func runRecordingsListCommand(cmd *cobra.Command, args []string) error {
+	dir, err := recordingsDir()
+	if err != nil {
+		return err
+	}
+	recordings, err := recording.List(dir)
+	if err != nil {
+		return errors.Wrap(err, "failed to list the recordings")
+	}
+	if len(args) > 0 {
+		filtered := []recording.Info{}
+		for _, info := range recordings {
+			if matched, _ := path.Match(args[0], info.Host); matched {
+				filtered = append(filtered, info)
+			}
+		}
+		recordings = filtered
+	}
+
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(recordings)
+	}
+	if len(recordings) == 0 {
+		fmt.Println("no recording.")
+		return nil
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "NAME\tHOST\tSTARTED\tDURATION\tSIZE")
+	for _, info := range recordings {
+		fmt.Fprintf(
+			writer, "%s\t%s\t%s\t%s\t%s\n",
+			info.Name, info.Host, info.StartedAt.Format("2006-01-02 15:04:05"),
+			info.Duration.Round(time.Second), humanize.Bytes(uint64(info.Size)),
+		)
+	}
+	return writer.Flush()
+}
+
+func runRecordingsPlayCommand(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("assh: \"recordings play\" requires 1 argument. See 'assh recordings play --help'")
+	}
+
+	file := args[0]
+	if _, err := os.Stat(file); os.IsNotExist(err) && !strings.ContainsRune(file, os.PathSeparator) {
+		// a recording name, as printed by "assh recordings list"
+		dir, err := recordingsDir()
+		if err != nil {
+			return err
+		}
+		file = filepath.Join(dir, strings.TrimSuffix(file, recording.Extension)+recording.Extension)
+	}
+	reader, err := os.Open(file)
+	if err != nil {
+		return errors.Wrap(err, "failed to open the recording")
+	}
+	defer reader.Close()
+
+	speed, _ := cmd.Flags().GetFloat64("speed")
+	idleLimit, _ := cmd.Flags().GetDuration("idle-limit")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err = recording.Play(ctx, os.Stdout, reader, recording.PlayOptions{Speed: speed, IdleLimit: idleLimit})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return errors.Wrap(err, "failed to play the recording")
+}
@@ -0,0 +1,91 @@
+package commands
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"moul.io/assh/v2/pkg/config"
+	"moul.io/assh/v2/pkg/relay"
+	"moul.io/assh/v2/pkg/upstream"
+)
+
+// defaultRelayTimeout is the time a disconnected session is kept by the relay and by "assh connect"
+const defaultRelayTimeout = 5 * time.Minute
+
+var relayCommand = &cobra.Command{
+	Use:   "relay",
+	Short: "Relay resumable sessions to the local SSH server, used by the hosts configured with RelayPort",
+	RunE:  runRelayCommand,
+}
+
+// nolint:gochecknoinits
+func init() {
+	relayCommand.Flags().StringP("listen", "", "127.0.0.1:2222", "Address listening for the 'assh connect' sessions")
+	relayCommand.Flags().StringP("target", "", "127.0.0.1:22", "Address of the SSH server")
+	relayCommand.Flags().DurationP("timeout", "", defaultRelayTimeout, "Time a disconnected session is kept, waiting for its client to reconnect")
+	// the flags are not bound to viper, to not collide with the flags of the other commands
+}
+
+func runRelayCommand(cmd *cobra.Command, args []string) error {
+	listen, _ := cmd.Flags().GetString("listen")
+	target, _ := cmd.Flags().GetString("target")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen")
+	}
+	if addr, ok := listener.Addr().(*net.TCPAddr); ok && !addr.IP.IsLoopback() {
+		logger().Warn("The relay has no authentication, its clients are seen by the SSH server as coming from the relay", zap.String("listen", listen))
+	}
+	logger().Info("Relaying sessions", zap.String("listen", listener.Addr().String()), zap.String("target", target))
+
+	server := &relay.Server{
+		Dial:    func() (net.Conn, error) { return net.DialTimeout("tcp", target, 10*time.Second) },
+		Timeout: timeout,
+	}
+	return server.Serve(listener)
+}
+
+// dialRelay opens a resumable session with the assh relay of host; the connections, including the
+// reconnections, go through the first available gateway of host
+func dialRelay(host *config.Host, conf *config.Config, connectTimeout time.Duration) (*relay.Session, error) {
+	gateways := host.Gateways
+	if len(gateways) == 0 {
+		gateways = []string{"direct"}
+	}
+
+	// the gateways reach the relay port instead of the SSH port
+	relayHost := host.Clone()
+	relayHost.Port = host.RelayPort
+
+	dial := func() (net.Conn, error) {
+		errs := []string{}
+		for _, gateway := range gateways {
+			// the standard error is not displayed, the session is running in the terminal; the first dial is
+			// retried by proxyGo, and the reconnections by the relay
+			conn, err := dialGateway(relayHost, conf, gateway, connectTimeout, nil, nil)
+			if command, ok := conn.(*commandConn); ok && err == nil && connectTimeout > 0 {
+				// the relay waits for the hello of the client, the command is killed if the relay does not
+				// answer it in time
+				command.killUnanswered(connectTimeout)
+			}
+			if err == nil {
+				logger().Debug("Connected to relay", zap.String("gateway", upstream.Redact(gateway)))
+				return conn, nil
+			}
+			errs = append(errs, upstream.Redact(gateway)+": "+err.Error())
+		}
+		return nil, errors.Errorf("failed to reach relay (%s)", strings.Join(errs, ", "))
+	}
+
+	timeout := defaultRelayTimeout
+	if host.RelayTimeout > 0 {
+		timeout = time.Duration(host.RelayTimeout) * time.Second
+	}
+	return relay.Dial(dial, timeout)
+}
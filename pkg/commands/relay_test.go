@@ -0,0 +1,36 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"moul.io/assh/v2/pkg/config"
+)
+
+func Test_dialRelay(t *testing.T) {
+	Convey("Testing dialRelay()", t, func() {
+		// the ssh gateway never answers
+		bin := t.TempDir()
+		So(os.WriteFile(filepath.Join(bin, "ssh"), []byte("#!/bin/sh\nexec sleep 5\n"), 0o755), ShouldBeNil)
+		t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+		conf := config.New()
+		err := conf.LoadConfig(strings.NewReader(`
+hosts:
+  resumable:
+    HostName: 127.0.0.1
+    RelayPort: 2222
+    Gateways: [hanging]
+`))
+		So(err, ShouldBeNil)
+
+		start := time.Now()
+		_, err = dialRelay(conf.GetHostSafe("resumable"), conf, 200*time.Millisecond)
+		So(err, ShouldNotBeNil)
+		So(time.Since(start), ShouldBeLessThan, 2*time.Second)
+	})
+}
@@ -0,0 +1,180 @@
+package commands
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"moul.io/assh/v2/pkg/config"
+	"moul.io/assh/v2/pkg/upstream"
+)
+
+// steps of a connection retried by the retry policy, given to the OnRetry hooks
+const (
+	retryStepResolve = "resolve"
+	retryStepDial    = "dial"
+	retryStepGateway = "gateway"
+)
+
+// defaultRetryBackoff is the delay before the first retry, doubled for each next retry
+const defaultRetryBackoff = time.Second
+
+// errRetryDeadline is returned once the RetryDeadline of a host is exceeded
+var errRetryDeadline = errors.New("retry deadline exceeded")
+
+// noRetryError is an error which must not be retried, i.e: the connection was already used by the ssh client
+type noRetryError struct {
+	error
+}
+
+func (e noRetryError) Cause() error  { return e.error }
+func (e noRetryError) Unwrap() error { return e.error }
+
+// retryPolicy is the retry policy of the connection to a host, shared by all its steps: the resolution of its
+// hostname, and the dials to the host or to its gateways. A nil policy makes a single attempt.
+type retryPolicy struct {
+	host       *config.Host
+	attempts   int
+	backoff    time.Duration
+	jitter     time.Duration
+	hopTimeout time.Duration
+	// deadline is the end of the attempts of all the steps, zero if disabled
+	deadline time.Time
+	// sleep waits before a retry, it is replaced by the tests
+	sleep func(time.Duration)
+}
+
+func newRetryPolicy(host *config.Host, start time.Time) (*retryPolicy, error) {
+	policy := &retryPolicy{
+		host:     host,
+		attempts: host.RetryAttempts,
+		backoff:  defaultRetryBackoff,
+		sleep:    time.Sleep,
+	}
+	if policy.attempts < 1 {
+		policy.attempts = 1
+	}
+	var deadline time.Duration
+	for _, option := range []struct {
+		name  string
+		value string
+		dest  *time.Duration
+	}{
+		{"RetryBackoff", host.RetryBackoff, &policy.backoff},
+		{"RetryJitter", host.RetryJitter, &policy.jitter},
+		{"RetryDeadline", host.RetryDeadline, &deadline},
+		{"HopTimeout", host.HopTimeout, &policy.hopTimeout},
+	} {
+		if option.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(option.value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s configuration", option.name)
+		}
+		*option.dest = duration
+	}
+	if deadline > 0 {
+		policy.deadline = start.Add(deadline)
+	}
+	return policy, nil
+}
+
+// timeout returns the timeout of an attempt: HopTimeout, or fallback if it is not configured, bounded by the deadline;
+// zero means no timeout
+func (p *retryPolicy) timeout(fallback time.Duration) (time.Duration, error) {
+	if p == nil {
+		return fallback, nil
+	}
+	timeout := fallback
+	if p.hopTimeout > 0 {
+		timeout = p.hopTimeout
+	}
+	if !p.deadline.IsZero() {
+		left := time.Until(p.deadline)
+		if left <= 0 {
+			return 0, errRetryDeadline
+		}
+		if timeout <= 0 || left < timeout {
+			timeout = left
+		}
+	}
+	return timeout, nil
+}
+
+// delay returns the time to wait after the failed attempt number attempt
+func (p *retryPolicy) delay(attempt int) time.Duration {
+	delay := p.backoff << uint(attempt-1)
+	if delay < p.backoff { // overflow
+		delay = p.backoff
+	}
+	if p.jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(p.jitter))) // #nosec
+	}
+	return delay
+}
+
+// run calls attempt until it succeeds, the attempts are exhausted or the deadline is exceeded; attempt receives its
+// timeout, computed by the timeout method. The retries are logged and given to the OnRetry hooks.
+func (p *retryPolicy) run(step string, gateway string, fallback time.Duration, attempt func(timeout time.Duration) error) error {
+	for number := 1; ; number++ {
+		timeout, err := p.timeout(fallback)
+		if err != nil {
+			return err
+		}
+		err = attempt(timeout)
+		if err == nil || p == nil || number >= p.attempts || errors.As(err, &noRetryError{}) {
+			return err
+		}
+
+		delay := p.delay(number)
+		if !p.deadline.IsZero() && time.Now().Add(delay).After(p.deadline) {
+			return errors.Wrap(err, errRetryDeadline.Error())
+		}
+		logger().Warn(
+			"Retrying",
+			zap.String("host", p.host.Name()),
+			zap.String("step", step),
+			zap.String("gateway", upstream.Redact(gateway)),
+			zap.Int("attempt", number),
+			zap.Int("attempts", p.attempts),
+			zap.Duration("in", delay),
+			zap.Error(err),
+		)
+		p.onRetry(step, gateway, number, delay, err)
+	}
+}
+
+// RetryHookArgs is the structure sent to the OnRetry hooks
+type RetryHookArgs struct {
+	Host  *config.Host
+	Stats *ConnectionStats
+	Error string
+	// Step is the failed step ("resolve", "dial" or "gateway"), Attempt its number and RetryIn the delay before the
+	// next attempt
+	Step    string
+	Attempt int
+	RetryIn time.Duration
+}
+
+// onRetry calls the OnRetry hooks, kept running until the next attempt
+func (p *retryPolicy) onRetry(step string, gateway string, attempt int, delay time.Duration, err error) {
+	args := RetryHookArgs{
+		Host:    p.host,
+		Stats:   &ConnectionStats{CreatedAt: time.Now(), Gateway: upstream.Redact(gateway)},
+		Error:   err.Error(),
+		Step:    step,
+		Attempt: attempt,
+		RetryIn: delay,
+	}
+	logger().Debug("Calling OnRetry hooks")
+	drivers, hookErr := p.host.Hooks.OnRetry.InvokeAll("OnRetry", args)
+	if hookErr != nil {
+		logger().Error("OnRetry hook failed", zap.Error(hookErr))
+	}
+	p.sleep(delay)
+	if hookErr == nil {
+		drivers.Close()
+	}
+}
@@ -0,0 +1,183 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"moul.io/assh/v2/pkg/config"
+)
+
+func retryHost(t *testing.T, options string) *config.Host {
+	conf := config.New()
+	if err := conf.LoadConfig(strings.NewReader("hosts:\n  retried:\n" + options)); err != nil {
+		t.Fatal(err)
+	}
+	return conf.GetHostSafe("retried")
+}
+
+func Test_retryPolicy(t *testing.T) {
+	Convey("Testing retryPolicy", t, func() {
+		Convey("newRetryPolicy()", func() {
+			start := time.Now()
+			policy, err := newRetryPolicy(retryHost(t, ""), start)
+			So(err, ShouldBeNil)
+			So(policy.attempts, ShouldEqual, 1)
+			So(policy.backoff, ShouldEqual, defaultRetryBackoff)
+			So(policy.deadline.IsZero(), ShouldBeTrue)
+
+			policy, err = newRetryPolicy(retryHost(t, "    RetryAttempts: 3\n    RetryBackoff: 100ms\n    RetryDeadline: 1m\n    HopTimeout: 5s\n"), start)
+			So(err, ShouldBeNil)
+			So(policy.attempts, ShouldEqual, 3)
+			So(policy.backoff, ShouldEqual, 100*time.Millisecond)
+			So(policy.hopTimeout, ShouldEqual, 5*time.Second)
+			So(policy.deadline, ShouldEqual, start.Add(time.Minute))
+
+			_, err = newRetryPolicy(retryHost(t, "    RetryJitter: sometimes\n"), start)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("delay()", func() {
+			policy := &retryPolicy{backoff: 100 * time.Millisecond}
+			So(policy.delay(1), ShouldEqual, 100*time.Millisecond)
+			So(policy.delay(2), ShouldEqual, 200*time.Millisecond)
+			So(policy.delay(3), ShouldEqual, 400*time.Millisecond)
+			policy.jitter = 50 * time.Millisecond
+			for i := 0; i < 10; i++ {
+				So(policy.delay(1), ShouldBeBetweenOrEqual, 100*time.Millisecond, 150*time.Millisecond)
+			}
+		})
+
+		Convey("timeout()", func() {
+			var policy *retryPolicy
+			timeout, err := policy.timeout(time.Second)
+			So(err, ShouldBeNil)
+			So(timeout, ShouldEqual, time.Second)
+
+			policy = &retryPolicy{hopTimeout: 2 * time.Second}
+			timeout, err = policy.timeout(time.Second)
+			So(err, ShouldBeNil)
+			So(timeout, ShouldEqual, 2*time.Second)
+
+			policy.deadline = time.Now().Add(time.Second)
+			timeout, err = policy.timeout(0)
+			So(err, ShouldBeNil)
+			So(timeout, ShouldBeBetweenOrEqual, 900*time.Millisecond, time.Second)
+
+			policy.deadline = time.Now().Add(-time.Second)
+			_, err = policy.timeout(0)
+			So(err, ShouldEqual, errRetryDeadline)
+		})
+
+		Convey("run()", func() {
+			dir, err := ioutil.TempDir("", "assh-retry")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			hookOutput := filepath.Join(dir, "hooks")
+			host := retryHost(t, fmt.Sprintf(`    RetryAttempts: 3
+    RetryBackoff: 10ms
+    Hooks:
+      OnRetry:
+      - exec echo {{.Step}} {{.Attempt}} {{.RetryIn}} {{.Stats.Gateway}} >> %s
+`, hookOutput))
+			policy, err := newRetryPolicy(host, time.Now())
+			So(err, ShouldBeNil)
+			delays := []time.Duration{}
+			policy.sleep = func(delay time.Duration) { delays = append(delays, delay) }
+
+			Convey("succeeds after retries", func() {
+				attempts := 0
+				err := policy.run(retryStepDial, "direct", 0, func(time.Duration) error {
+					attempts++
+					if attempts < 3 {
+						return errors.New("connection refused")
+					}
+					return nil
+				})
+				So(err, ShouldBeNil)
+				So(attempts, ShouldEqual, 3)
+				So(delays, ShouldResemble, []time.Duration{10 * time.Millisecond, 20 * time.Millisecond})
+				output, err := ioutil.ReadFile(hookOutput)
+				So(err, ShouldBeNil)
+				So(string(output), ShouldEqual, "dial 1 10ms direct\ndial 2 20ms direct\n")
+			})
+
+			Convey("gives up", func() {
+				attempts := 0
+				err := policy.run(retryStepDial, "direct", 0, func(time.Duration) error {
+					attempts++
+					return errors.New("connection refused")
+				})
+				So(err, ShouldNotBeNil)
+				So(attempts, ShouldEqual, 3)
+			})
+
+			Convey("does not retry a used connection", func() {
+				attempts := 0
+				err := policy.run(retryStepGateway, "bastion", 0, func(time.Duration) error {
+					attempts++
+					return noRetryError{errors.New("exit status 255")}
+				})
+				So(err, ShouldNotBeNil)
+				So(attempts, ShouldEqual, 1)
+			})
+
+			Convey("stops at the deadline", func() {
+				// without the hooks, to not depend on their duration
+				policy.host = retryHost(t, "")
+				policy.deadline = time.Now().Add(15 * time.Millisecond)
+				attempts := 0
+				err := policy.run(retryStepDial, "direct", 0, func(time.Duration) error {
+					attempts++
+					return errors.New("connection refused")
+				})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, errRetryDeadline.Error())
+				So(attempts, ShouldEqual, 2)
+			})
+		})
+	})
+}
+
+func Test_runWatched(t *testing.T) {
+	Convey("Testing runWatched()", t, func() {
+		var noRetry noRetryError
+
+		err := runWatched(exec.Command("/bin/sh", "-c", "echo answer"), time.Second)
+		So(err, ShouldBeNil)
+
+		err = runWatched(exec.Command("/bin/sh", "-c", "exit 255"), time.Second)
+		So(err, ShouldNotBeNil)
+		So(errors.As(err, &noRetry), ShouldBeFalse)
+
+		err = runWatched(exec.Command("/bin/sh", "-c", "echo answer; exit 255"), time.Second)
+		So(err, ShouldNotBeNil)
+		So(errors.As(err, &noRetry), ShouldBeTrue)
+
+		start := time.Now()
+		err = runWatched(exec.Command("/bin/sh", "-c", "sleep 5"), 100*time.Millisecond)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "no answer within 100ms")
+		So(time.Since(start), ShouldBeLessThan, 3*time.Second)
+	})
+}
+
+func Test_hostPrepare_timeout(t *testing.T) {
+	Convey("Testing hostPrepare() with a HopTimeout", t, func() {
+		host := retryHost(t, "    ResolveCommand: /bin/sh -c \"sleep 5\"\n    HopTimeout: 100ms\n    RetryAttempts: 2\n    RetryBackoff: 1ms\n")
+		policy, err := newRetryPolicy(host, time.Now())
+		So(err, ShouldBeNil)
+		start := time.Now()
+		err = hostPrepare(host, "", policy)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "no answer within 100ms")
+		So(time.Since(start), ShouldBeLessThan, 3*time.Second)
+	})
+}
@@ -0,0 +1,227 @@
+package commands
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"moul.io/assh/v2/pkg/config"
+)
+
+// reasons of the end of a connection, given to the OnDisconnect hooks
+const (
+	disconnectClient      = "client-closed"
+	disconnectRemote      = "remote-closed"
+	disconnectError       = "error"
+	disconnectIdle        = "idle-timeout"
+	disconnectMaxDuration = "max-session-duration"
+)
+
+// idleCheckInterval is the longest delay before noticing a connection is idle again, once the OnIdle hooks were called
+const idleCheckInterval = time.Second
+
+// defaultDisconnectWarning is the time before a forced disconnection the BeforeDisconnect hooks are called
+const defaultDisconnectWarning = time.Minute
+
+// sessionLimits are the durations after which assh closes a connection, or calls the OnIdle hooks for idleDelay, zero
+// values are disabled
+type sessionLimits struct {
+	idleTimeout time.Duration
+	maxDuration time.Duration
+	warning     time.Duration
+	idleDelay   time.Duration
+}
+
+func newSessionLimits(host *config.Host) (sessionLimits, error) {
+	limits := sessionLimits{warning: defaultDisconnectWarning}
+	for _, option := range []struct {
+		name  string
+		value string
+		dest  *time.Duration
+	}{
+		{"IdleTimeout", host.IdleTimeout, &limits.idleTimeout},
+		{"MaxSessionDuration", host.MaxSessionDuration, &limits.maxDuration},
+		{"DisconnectWarning", host.DisconnectWarning, &limits.warning},
+		{"IdleDelay", host.IdleDelay, &limits.idleDelay},
+	} {
+		if option.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(option.value)
+		if err != nil {
+			return limits, errors.Wrapf(err, "failed to parse %s configuration", option.name)
+		}
+		*option.dest = duration
+	}
+	return limits, nil
+}
+
+func (l sessionLimits) enabled() bool {
+	return l.idleTimeout > 0 || l.maxDuration > 0 || l.idleDelay > 0
+}
+
+// warningFor returns the warning delay of a limit, disabled if it is not shorter than the limit
+func (l sessionLimits) warningFor(limit time.Duration) time.Duration {
+	if l.warning >= limit {
+		return 0
+	}
+	return l.warning
+}
+
+// sessionEvent is a forced disconnection, the warning sent before it, or an idle connection
+type sessionEvent struct {
+	reason  string
+	warning bool
+	// in is the time left before the disconnection
+	in time.Duration
+	// idle is set for an idle connection, idleFor is the time since its last activity
+	idle    bool
+	idleFor time.Duration
+}
+
+// sessionWatcher tracks the activity of a connection against its limits
+type sessionWatcher struct {
+	limits sessionLimits
+	start  time.Time
+	// lastActivity is the time of the last transferred data, in nanoseconds since the epoch
+	lastActivity atomic.Int64
+	// warnedIdle is the last activity the idle warning was sent for
+	warnedIdle int64
+	warnedMax  bool
+	// notifiedIdle is the last activity the OnIdle hooks were called for
+	notifiedIdle int64
+}
+
+func newSessionWatcher(limits sessionLimits, start time.Time) *sessionWatcher {
+	w := &sessionWatcher{limits: limits, start: start}
+	w.touch(start)
+	return w
+}
+
+func (w *sessionWatcher) touch(now time.Time) {
+	w.lastActivity.Store(now.UnixNano())
+}
+
+// check returns the events due at now and the time of the next check, zero after a disconnection
+func (w *sessionWatcher) check(now time.Time) ([]sessionEvent, time.Time) {
+	events := []sessionEvent{}
+	var next time.Time
+	schedule := func(at time.Time) {
+		if next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+
+	if w.limits.maxDuration > 0 {
+		deadline := w.start.Add(w.limits.maxDuration)
+		if !now.Before(deadline) {
+			return []sessionEvent{{reason: disconnectMaxDuration}}, time.Time{}
+		}
+		if warning := w.limits.warningFor(w.limits.maxDuration); warning > 0 && !w.warnedMax {
+			if warnAt := deadline.Add(-warning); now.Before(warnAt) {
+				schedule(warnAt)
+			} else {
+				w.warnedMax = true
+				events = append(events, sessionEvent{reason: disconnectMaxDuration, warning: true, in: deadline.Sub(now)})
+			}
+		}
+		schedule(deadline)
+	}
+
+	if w.limits.idleTimeout > 0 {
+		last := w.lastActivity.Load()
+		deadline := time.Unix(0, last).Add(w.limits.idleTimeout)
+		if !now.Before(deadline) {
+			return []sessionEvent{{reason: disconnectIdle}}, time.Time{}
+		}
+		if warning := w.limits.warningFor(w.limits.idleTimeout); warning > 0 && w.warnedIdle != last {
+			if warnAt := deadline.Add(-warning); now.Before(warnAt) {
+				schedule(warnAt)
+			} else {
+				w.warnedIdle = last
+				events = append(events, sessionEvent{reason: disconnectIdle, warning: true, in: deadline.Sub(now)})
+			}
+		}
+		schedule(deadline)
+	}
+
+	if w.limits.idleDelay > 0 {
+		last := w.lastActivity.Load()
+		idleAt := time.Unix(0, last).Add(w.limits.idleDelay)
+		if w.notifiedIdle != last && !now.Before(idleAt) {
+			w.notifiedIdle = last
+			events = append(events, sessionEvent{idle: true, idleFor: now.Sub(time.Unix(0, last))})
+		}
+		if w.notifiedIdle == last {
+			// the connection is still idle, the next activity is unknown
+			interval := idleCheckInterval
+			if w.limits.idleDelay < interval {
+				interval = w.limits.idleDelay
+			}
+			schedule(now.Add(interval))
+		} else {
+			schedule(idleAt)
+		}
+	}
+	return events, next
+}
+
+// watch calls notify before a forced disconnection and when the connection is idle, the reason of the disconnection
+// is then sent on the returned channel. The returned channel is nil if no limit is configured; stop ends the watch and
+// waits for the pending notification.
+func (w *sessionWatcher) watch(notify func(sessionEvent)) (disconnect <-chan string, stop func()) {
+	if !w.limits.enabled() {
+		return nil, func() {}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	reasons := make(chan string, 1)
+	go func() {
+		defer close(done)
+		for {
+			events, next := w.check(time.Now())
+			for _, event := range events {
+				if !event.warning && !event.idle {
+					reasons <- event.reason
+					return
+				}
+				notify(event)
+			}
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+	}()
+	return reasons, func() {
+		cancel()
+		<-done
+	}
+}
+
+// reader returns a reader recording the activity of the connection, r itself if no limit is configured to keep
+// the fast paths of io.Copy
+func (w *sessionWatcher) reader(r io.Reader) io.Reader {
+	if !w.limits.enabled() {
+		return r
+	}
+	return &activityReader{r: r, watcher: w}
+}
+
+type activityReader struct {
+	r       io.Reader
+	watcher *sessionWatcher
+}
+
+func (r *activityReader) Read(buf []byte) (int, error) {
+	n, err := r.r.Read(buf)
+	if n > 0 {
+		r.watcher.touch(time.Now())
+	}
+	return n, err
+}
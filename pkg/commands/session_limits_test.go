@@ -0,0 +1,156 @@
+package commands
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"moul.io/assh/v2/pkg/config"
+)
+
+func Test_newSessionLimits(t *testing.T) {
+	Convey("Testing newSessionLimits()", t, func() {
+		limits, err := newSessionLimits(&config.Host{})
+		So(err, ShouldBeNil)
+		So(limits.enabled(), ShouldBeFalse)
+		So(limits.warning, ShouldEqual, time.Minute)
+
+		limits, err = newSessionLimits(&config.Host{IdleTimeout: "30m", MaxSessionDuration: "8h", DisconnectWarning: "5m"})
+		So(err, ShouldBeNil)
+		So(limits, ShouldResemble, sessionLimits{idleTimeout: 30 * time.Minute, maxDuration: 8 * time.Hour, warning: 5 * time.Minute})
+		So(limits.enabled(), ShouldBeTrue)
+
+		limits, err = newSessionLimits(&config.Host{IdleDelay: "5m"})
+		So(err, ShouldBeNil)
+		So(limits.idleDelay, ShouldEqual, 5*time.Minute)
+		So(limits.enabled(), ShouldBeTrue)
+
+		_, err = newSessionLimits(&config.Host{IdleTimeout: "forever"})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func Test_sessionWatcher(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	Convey("Testing sessionWatcher.check()", t, func() {
+		Convey("MaxSessionDuration", func() {
+			watcher := newSessionWatcher(sessionLimits{maxDuration: time.Hour, warning: time.Minute}, start)
+
+			events, next := watcher.check(start.Add(time.Second))
+			So(events, ShouldBeEmpty)
+			So(next, ShouldEqual, start.Add(59*time.Minute))
+
+			// the activity does not extend the session
+			watcher.touch(start.Add(58 * time.Minute))
+			events, next = watcher.check(start.Add(59*time.Minute + 30*time.Second))
+			So(events, ShouldResemble, []sessionEvent{{reason: disconnectMaxDuration, warning: true, in: 30 * time.Second}})
+			So(next, ShouldEqual, start.Add(time.Hour))
+
+			// the warning is sent once
+			events, _ = watcher.check(start.Add(59*time.Minute + 40*time.Second))
+			So(events, ShouldBeEmpty)
+
+			events, next = watcher.check(start.Add(time.Hour))
+			So(events, ShouldResemble, []sessionEvent{{reason: disconnectMaxDuration}})
+			So(next.IsZero(), ShouldBeTrue)
+		})
+
+		Convey("IdleTimeout", func() {
+			watcher := newSessionWatcher(sessionLimits{idleTimeout: 10 * time.Minute, warning: time.Minute}, start)
+
+			events, next := watcher.check(start)
+			So(events, ShouldBeEmpty)
+			So(next, ShouldEqual, start.Add(9*time.Minute))
+
+			events, _ = watcher.check(start.Add(9 * time.Minute))
+			So(events, ShouldResemble, []sessionEvent{{reason: disconnectIdle, warning: true, in: time.Minute}})
+
+			// the activity postpones the disconnection, the warning is sent again when idle again
+			watcher.touch(start.Add(9*time.Minute + 30*time.Second))
+			events, next = watcher.check(start.Add(10 * time.Minute))
+			So(events, ShouldBeEmpty)
+			So(next, ShouldEqual, start.Add(18*time.Minute+30*time.Second))
+			events, _ = watcher.check(start.Add(19 * time.Minute))
+			So(events, ShouldResemble, []sessionEvent{{reason: disconnectIdle, warning: true, in: 30 * time.Second}})
+
+			events, _ = watcher.check(start.Add(19*time.Minute + 30*time.Second))
+			So(events, ShouldResemble, []sessionEvent{{reason: disconnectIdle}})
+		})
+
+		Convey("IdleDelay", func() {
+			watcher := newSessionWatcher(sessionLimits{idleDelay: 5 * time.Minute}, start)
+
+			events, next := watcher.check(start.Add(time.Minute))
+			So(events, ShouldBeEmpty)
+			So(next, ShouldEqual, start.Add(5*time.Minute))
+
+			events, next = watcher.check(start.Add(5*time.Minute + time.Second))
+			So(events, ShouldResemble, []sessionEvent{{idle: true, idleFor: 5*time.Minute + time.Second}})
+			So(next, ShouldEqual, start.Add(5*time.Minute+2*time.Second))
+
+			// the hooks are called once per idle period
+			events, _ = watcher.check(start.Add(10 * time.Minute))
+			So(events, ShouldBeEmpty)
+
+			watcher.touch(start.Add(11 * time.Minute))
+			events, next = watcher.check(start.Add(11*time.Minute + time.Second))
+			So(events, ShouldBeEmpty)
+			So(next, ShouldEqual, start.Add(16*time.Minute))
+			events, _ = watcher.check(start.Add(16 * time.Minute))
+			So(events, ShouldResemble, []sessionEvent{{idle: true, idleFor: 5 * time.Minute}})
+		})
+
+		Convey("Warning longer than the limit", func() {
+			watcher := newSessionWatcher(sessionLimits{idleTimeout: time.Minute, warning: 5 * time.Minute}, start)
+			events, next := watcher.check(start)
+			So(events, ShouldBeEmpty)
+			So(next, ShouldEqual, start.Add(time.Minute))
+		})
+	})
+
+	Convey("Testing sessionWatcher.watch()", t, func() {
+		disconnect, stop := newSessionWatcher(sessionLimits{}, time.Now()).watch(func(sessionEvent) {})
+		So(disconnect, ShouldBeNil)
+		stop()
+
+		var mutex sync.Mutex
+		warnings := []sessionEvent{}
+		watcher := newSessionWatcher(sessionLimits{maxDuration: 200 * time.Millisecond, warning: 100 * time.Millisecond}, time.Now())
+		disconnect, stop = watcher.watch(func(event sessionEvent) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			warnings = append(warnings, event)
+		})
+		defer stop()
+
+		select {
+		case reason := <-disconnect:
+			So(reason, ShouldEqual, disconnectMaxDuration)
+		case <-time.After(5 * time.Second):
+			t.Fatal("the session was not closed")
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		So(len(warnings), ShouldEqual, 1)
+		So(warnings[0].reason, ShouldEqual, disconnectMaxDuration)
+	})
+
+	Convey("Testing sessionWatcher.watch() with an IdleDelay", t, func() {
+		idle := make(chan sessionEvent, 1)
+		watcher := newSessionWatcher(sessionLimits{idleDelay: 50 * time.Millisecond}, time.Now())
+		disconnect, stop := watcher.watch(func(event sessionEvent) { idle <- event })
+		defer stop()
+
+		select {
+		case event := <-idle:
+			So(event.idle, ShouldBeTrue)
+			So(event.idleFor, ShouldBeGreaterThanOrEqualTo, 50*time.Millisecond)
+		case <-disconnect:
+			t.Fatal("the session was closed")
+		case <-time.After(5 * time.Second):
+			t.Fatal("the OnIdle hooks were not called")
+		}
+	})
+}
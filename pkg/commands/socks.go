@@ -0,0 +1,88 @@
+package commands
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"moul.io/assh/v2/pkg/config"
+	"moul.io/assh/v2/pkg/socks"
+)
+
+var socksCommand = &cobra.Command{
+	Use:   "socks",
+	Short: "Start a SOCKS5 server reaching the targets through the gateways of the assh configuration",
+	RunE:  runSocksCommand,
+}
+
+// nolint:gochecknoinits
+func init() {
+	socksCommand.Flags().StringP("listen", "", "127.0.0.1:1080", "Address listening for the SOCKS clients")
+	socksCommand.Flags().DurationP("timeout", "", 10*time.Second, "Timeout to reach a target, 0 to disable")
+	// the flags are not bound to viper, to not collide with the flags of the other commands
+}
+
+func runSocksCommand(cmd *cobra.Command, args []string) error {
+	listen, _ := cmd.Flags().GetString("listen")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+
+	conf, err := config.Open(viper.GetString("config"))
+	if err != nil {
+		return errors.Wrap(err, "failed to load config")
+	}
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen")
+	}
+	if addr, ok := listener.Addr().(*net.TCPAddr); ok && !addr.IP.IsLoopback() {
+		logger().Warn("The SOCKS server has no authentication and is reachable from the network", zap.String("listen", listen))
+	}
+	logger().Info("Serving SOCKS5", zap.String("listen", listener.Addr().String()))
+
+	server := &socks.Server{Dial: socksDialer(conf, timeout)}
+	return server.Serve(listener)
+}
+
+// socksDialer returns a dialer reaching the targets as 'assh connect' does: the names are resolved against the
+// hosts and the aliases of conf, then the targets are reached through their gateways
+func socksDialer(conf *config.Config, timeout time.Duration) socks.Dialer {
+	var mu sync.Mutex
+	return func(ctx context.Context, name string, port string) (net.Conn, error) {
+		// a slash selects a gateway and the wildcards would match the patterns of the configuration
+		if name == "" || strings.ContainsAny(name, `/*?[]\`) {
+			return nil, errors.Errorf("invalid target name %q", name)
+		}
+		portNumber, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid target port")
+		}
+
+		// the configuration is shared between the connections
+		mu.Lock()
+		host, err := computeHost(name, portNumber, conf)
+		mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		conn, gateway, err := dialGateways(host, conf, timeout, os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		logger().Info(
+			"SOCKS connection",
+			zap.String("target", net.JoinHostPort(name, port)),
+			zap.String("gateway", gateway),
+		)
+		return conn, nil
+	}
+}
@@ -0,0 +1,162 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"moul.io/assh/v2/pkg/config"
+)
+
+func Test_socksDialer(t *testing.T) {
+	Convey("Testing socksDialer()", t, func() {
+		listener := newBannerServer(t)
+		defer listener.Close()
+		_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+		// the ssh gateways fail, never answer, or forward the connections with Test_helperSSH
+		bin := t.TempDir()
+		So(os.WriteFile(filepath.Join(bin, "ssh"), []byte(fmt.Sprintf(`#!/bin/sh
+case "$*" in
+*hanging*) exec sleep 5;;
+*bastion*) ASSH_TEST_HELPER_SSH=1 exec %q -test.run=Test_helperSSH -- "$@";;
+esac
+exit 255
+`, os.Args[0])), 0o755), ShouldBeNil)
+		t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+		conf := config.New()
+		err := conf.LoadConfig(strings.NewReader(fmt.Sprintf(`
+hosts:
+  web:
+    HostName: 127.0.0.1
+    Aliases: [intranet]
+  resolved:
+    ResolveCommand: /bin/sh -c "echo 127.0.0.1"
+  fallback:
+    HostName: 127.0.0.1
+    Gateways: [socks5://127.0.0.1:%s, direct]
+  dead-gateway:
+    HostName: 127.0.0.1
+    Gateways: [dead, direct]
+  hanging-gateway:
+    HostName: 127.0.0.1
+    Gateways: [hanging, direct]
+  behind-bastion:
+    HostName: 127.0.0.1
+    Gateways: [bastion]
+  "*.lan":
+    HostName: 127.0.0.1
+`, closedPort(t))))
+		So(err, ShouldBeNil)
+		dial := socksDialer(conf, 2*time.Second)
+
+		for _, name := range []string{"web", "intranet", "resolved", "fallback", "dead-gateway", "printer.lan", "127.0.0.1"} {
+			Convey(name, func() {
+				conn, err := dial(context.Background(), name, port)
+				So(err, ShouldBeNil)
+				defer conn.Close()
+				banner, err := bufio.NewReader(conn).ReadString('\n')
+				So(err, ShouldBeNil)
+				So(banner, ShouldEqual, "SSH-2.0-assh_test\r\n")
+			})
+		}
+
+		Convey("hanging gateway", func() {
+			start := time.Now()
+			conn, err := socksDialer(conf, 200*time.Millisecond)(context.Background(), "hanging-gateway", port)
+			So(err, ShouldBeNil)
+			defer conn.Close()
+			So(time.Since(start), ShouldBeLessThan, 2*time.Second)
+			banner, err := bufio.NewReader(conn).ReadString('\n')
+			So(err, ShouldBeNil)
+			So(banner, ShouldEqual, "SSH-2.0-assh_test\r\n")
+		})
+
+		Convey("client talking first through an ssh gateway", func() {
+			server := newEchoServer(t)
+			defer server.Close()
+			_, echoPort, _ := net.SplitHostPort(server.Addr().String())
+
+			conn, err := dial(context.Background(), "behind-bastion", echoPort)
+			So(err, ShouldBeNil)
+			defer conn.Close()
+			_, err = conn.Write([]byte("GET / HTTP/1.0\n"))
+			So(err, ShouldBeNil)
+			answer, err := bufio.NewReader(conn).ReadString('\n')
+			So(err, ShouldBeNil)
+			So(answer, ShouldEqual, "echo: GET / HTTP/1.0\n")
+		})
+
+		Convey("unreachable target", func() {
+			_, err := dial(context.Background(), "web", closedPort(t))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "no such available gateway (direct: ")
+		})
+
+		Convey("invalid names", func() {
+			for _, name := range []string{"", "web/fallback", "*.lan", "web?"} {
+				_, err := dial(context.Background(), name, port)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "invalid target name")
+			}
+		})
+	})
+}
+
+// newEchoServer answers the first line sent by its clients, which talk first as the HTTP clients do
+func newEchoServer(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, err := bufio.NewReader(conn).ReadString('\n')
+				if err == nil {
+					_, _ = conn.Write([]byte("echo: " + line))
+				}
+			}()
+		}
+	}()
+	return listener
+}
+
+// Test_helperSSH is not a test, it is run by the fake ssh of Test_socksDialer as "ssh -W host:port gateway" and
+// forwards its standard input and output to host:port
+func Test_helperSSH(t *testing.T) {
+	if os.Getenv("ASSH_TEST_HELPER_SSH") != "1" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "-W" {
+		args = args[1:]
+	}
+	if len(args) < 2 {
+		os.Exit(255)
+	}
+	conn, err := net.Dial("tcp", args[1])
+	if err != nil {
+		os.Exit(255)
+	}
+	go func() {
+		_, _ = io.Copy(conn, os.Stdin)
+	}()
+	_, _ = io.Copy(os.Stdout, conn)
+	os.Exit(0)
+}
@@ -0,0 +1,75 @@
+package commands
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// spliceBufferSize is the size of the buffer copying the data when neither side provides a fast path
+const spliceBufferSize = 128 * 1024
+
+// exportReadWrite is the result of a direction of a connection
+type exportReadWrite struct {
+	written     uint64
+	firstByteAt time.Time
+	err         error
+	// halfClosed is true if the write side of the destination was closed after the end of the source
+	halfClosed bool
+}
+
+// halfCloser is implemented by the connections able to close their write side only, i.e: *net.TCPConn
+type halfCloser interface {
+	CloseWrite() error
+}
+
+// closeWrite returns a function closing the write side of conn, nil if conn does not support it
+func closeWrite(conn interface{}) func() error {
+	if closer, ok := conn.(halfCloser); ok {
+		return closer.CloseWrite
+	}
+	return nil
+}
+
+// splice copies src to dst until the end of src, then calls closeWrite to propagate the end of the stream to the
+// other side of dst. io.Copy uses the splice(2) fast path when neither side is wrapped.
+// The WaitGroup of ctx is done once the copy is finished.
+func splice(ctx context.Context, dst io.Writer, src io.Reader, closeWrite func() error) <-chan exportReadWrite {
+	c := make(chan exportReadWrite, 1)
+
+	go func() {
+		defer ctx.Value(syncContextKey).(*sync.WaitGroup).Done()
+
+		export := exportReadWrite{}
+		buf := make([]byte, spliceBufferSize)
+
+		// the first chunk is copied by hand to get the time of the first byte
+		for export.firstByteAt.IsZero() && export.err == nil {
+			nr, err := src.Read(buf)
+			if nr > 0 {
+				export.firstByteAt = time.Now()
+				nw, werr := dst.Write(buf[:nr])
+				export.written += uint64(nw)
+				if werr != nil {
+					err = werr
+				}
+			}
+			export.err = err
+		}
+		if export.err == nil {
+			n, err := io.CopyBuffer(dst, src, buf)
+			export.written += uint64(n)
+			export.err = err
+		}
+		if export.err == io.EOF {
+			export.err = nil
+		}
+
+		if export.err == nil && closeWrite != nil {
+			export.halfClosed = closeWrite() == nil
+		}
+		c <- export
+	}()
+	return c
+}
@@ -0,0 +1,168 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// tcpPair returns both sides of a loopback TCP connection
+func tcpPair(tb testing.TB) (*net.TCPConn, *net.TCPConn) {
+	tb.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	server := <-accepted
+	if server == nil {
+		tb.Fatal("failed to accept the connection")
+	}
+	tb.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	return client.(*net.TCPConn), server.(*net.TCPConn)
+}
+
+func spliceContext() (context.Context, *sync.WaitGroup) {
+	waitGroup := &sync.WaitGroup{}
+	return context.WithValue(context.Background(), syncContextKey, waitGroup), waitGroup
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("broken pipe") }
+
+func Test_splice(t *testing.T) {
+	Convey("Testing splice()", t, func() {
+		ctx, waitGroup := spliceContext()
+
+		Convey("Half-close", func() {
+			client, server := tcpPair(t)
+			// the server answers once it read the whole input
+			go func() {
+				input, _ := ioutil.ReadAll(server)
+				_, _ = fmt.Fprintf(server, "received %d bytes", len(input))
+				_ = server.Close()
+			}()
+
+			input := strings.Repeat("x", 1024*1024)
+			var output bytes.Buffer
+			waitGroup.Add(2)
+			sent := <-splice(ctx, client, strings.NewReader(input), closeWrite(client))
+			received := <-splice(ctx, &output, client, nil)
+			waitGroup.Wait()
+
+			So(sent.err, ShouldBeNil)
+			So(sent.halfClosed, ShouldBeTrue)
+			So(sent.written, ShouldEqual, len(input))
+			So(received.err, ShouldBeNil)
+			So(received.halfClosed, ShouldBeFalse)
+			So(output.String(), ShouldEqual, "received 1048576 bytes")
+			So(received.written, ShouldEqual, output.Len())
+			So(received.firstByteAt.IsZero(), ShouldBeFalse)
+		})
+
+		Convey("Without half-close support", func() {
+			So(closeWrite(&bytes.Buffer{}), ShouldBeNil)
+
+			waitGroup.Add(1)
+			var output bytes.Buffer
+			result := <-splice(ctx, &output, strings.NewReader("hello"), closeWrite(&output))
+			So(result.err, ShouldBeNil)
+			So(result.halfClosed, ShouldBeFalse)
+			So(result.written, ShouldEqual, 5)
+		})
+
+		Convey("Write error", func() {
+			waitGroup.Add(1)
+			result := <-splice(ctx, failingWriter{}, strings.NewReader("hello"), func() error {
+				t.Error("the write side is closed after an error")
+				return nil
+			})
+			So(result.err, ShouldNotBeNil)
+			So(result.halfClosed, ShouldBeFalse)
+		})
+
+		Convey("Empty source", func() {
+			waitGroup.Add(1)
+			closed := false
+			result := <-splice(ctx, ioutil.Discard, strings.NewReader(""), func() error {
+				closed = true
+				return nil
+			})
+			So(result.err, ShouldBeNil)
+			So(result.written, ShouldEqual, 0)
+			So(result.firstByteAt.IsZero(), ShouldBeTrue)
+			So(closed, ShouldBeTrue)
+		})
+	})
+}
+
+// onlyReader hides the WriterTo implementation of a connection, as the rate limiters and the recorders do
+type onlyReader struct {
+	io.Reader
+}
+
+// benchmarkSplice measures the throughput of splice between two loopback TCP connections
+func benchmarkSplice(b *testing.B, wrap func(io.Reader) io.Reader) {
+	const chunkSize = 64 * 1024
+	inputClient, inputServer := tcpPair(b)
+	outputClient, outputServer := tcpPair(b)
+
+	go func() {
+		chunk := make([]byte, chunkSize)
+		for i := 0; i < b.N; i++ {
+			if _, err := inputClient.Write(chunk); err != nil {
+				return
+			}
+		}
+		_ = inputClient.CloseWrite()
+	}()
+	drained := make(chan int64, 1)
+	go func() {
+		n, _ := io.Copy(ioutil.Discard, outputServer)
+		drained <- n
+	}()
+
+	b.SetBytes(chunkSize)
+	b.ResetTimer()
+	ctx, waitGroup := spliceContext()
+	waitGroup.Add(1)
+	result := <-splice(ctx, outputClient, wrap(inputServer), closeWrite(outputClient))
+	if result.err != nil {
+		b.Fatal(result.err)
+	}
+	if n := <-drained; n != int64(b.N)*chunkSize {
+		b.Fatalf("%d bytes received, %d expected", n, int64(b.N)*chunkSize)
+	}
+}
+
+func BenchmarkSplice(b *testing.B) {
+	b.Run("direct", func(b *testing.B) {
+		benchmarkSplice(b, func(r io.Reader) io.Reader { return r })
+	})
+	b.Run("wrapped", func(b *testing.B) {
+		benchmarkSplice(b, func(r io.Reader) io.Reader { return onlyReader{r} })
+	})
+}
@@ -0,0 +1,143 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"text/tabwriter"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"moul.io/assh/v2/pkg/config"
+	"moul.io/assh/v2/pkg/metrics"
+)
+
+var statsCommand = &cobra.Command{
+	Use:   "stats",
+	Short: "Display statistics about the connections made through assh",
+	RunE:  runStatsCommand,
+}
+
+// nolint:gochecknoinits
+func init() {
+	statsCommand.Flags().StringP("format", "", "text", "Output format: 'text', 'json' or 'prometheus'")
+	statsCommand.Flags().StringP("prometheus-file", "", "", "Also write the statistics to a file for the node_exporter textfile collector")
+	// the flags are not bound to viper, "format" is already bound by the ping command
+}
+
+func runStatsCommand(cmd *cobra.Command, args []string) error {
+	conf, err := config.Open(viper.GetString("config"))
+	if err != nil {
+		return errors.Wrap(err, "failed to load config")
+	}
+	metricsFile, err := conf.MetricsFile()
+	if err != nil {
+		return errors.Wrap(err, "failed to get the metrics file path")
+	}
+	if metricsFile == "" {
+		return errors.New("the connection metrics are disabled (asshmetricsfile: none)")
+	}
+
+	records, err := metrics.Read(metricsFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to read the metrics file")
+	}
+
+	// the arguments are host patterns
+	if len(args) > 0 {
+		filtered := make([]metrics.Record, 0, len(records))
+		for _, record := range records {
+			for _, pattern := range args {
+				if matched, _ := path.Match(pattern, record.Host); matched {
+					filtered = append(filtered, record)
+					break
+				}
+			}
+		}
+		records = filtered
+	}
+	hosts := metrics.Aggregate(records)
+
+	if file, _ := cmd.Flags().GetString("prometheus-file"); file != "" {
+		if err := metrics.WritePrometheusFile(file, hosts); err != nil {
+			return errors.Wrap(err, "failed to write prometheus file")
+		}
+	}
+
+	format, _ := cmd.Flags().GetString("format")
+	switch format {
+	case "text":
+		return printHostStats(hosts)
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(hosts)
+	case "prometheus":
+		return metrics.WritePrometheus(os.Stdout, hosts)
+	default:
+		return fmt.Errorf("invalid output format %q, should be 'text', 'json' or 'prometheus'", format)
+	}
+}
+
+func printHostStats(hosts []metrics.HostStats) error {
+	if len(hosts) == 0 {
+		fmt.Println("no connection recorded yet.")
+		return nil
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "HOST\tSESSIONS\tFAILURES\tSENT\tRECEIVED\tTOTAL TIME\tAVG TIME\tMAX TIME\tLAST SESSION")
+	for _, stats := range hosts {
+		lastSession := "-"
+		if !stats.LastConnectedAt.IsZero() {
+			lastSession = humanize.Time(stats.LastConnectedAt)
+		}
+		fmt.Fprintf(
+			writer, "%s\t%d\t%d\t%s\t%s\t%v\t%v\t%v\t%s\n",
+			stats.Host, stats.Sessions, stats.Failures,
+			humanize.Bytes(stats.SentBytes), humanize.Bytes(stats.ReceivedBytes),
+			stats.TotalDuration.Round(time.Second), stats.AverageDuration.Round(time.Second), stats.MaxDuration.Round(time.Second),
+			lastSession,
+		)
+	}
+	return writer.Flush()
+}
+
+// saveConnectionMetrics appends a finished connection to the metrics file,
+// failures are only logged as they should never prevent a connection
+func saveConnectionMetrics(conf *config.Config, host *config.Host, stats *ConnectionStats, err error) {
+	metricsFile, pathErr := conf.MetricsFile()
+	if pathErr != nil {
+		logger().Warn("Cannot get the metrics file path", zap.Error(pathErr))
+		return
+	}
+	if metricsFile == "" {
+		return
+	}
+
+	record := metrics.Record{
+		Host:            host.Name(),
+		HostName:        host.HostName,
+		Port:            host.Port,
+		Gateway:         stats.Gateway,
+		CreatedAt:       stats.CreatedAt,
+		ConnectedAt:     stats.ConnectedAt,
+		DisconnectedAt:  stats.DisconnectedAt,
+		TimeToFirstByte: stats.TimeToFirstByte,
+		SentBytes:       stats.SentBytes,
+		ReceivedBytes:   stats.ReceivedBytes,
+	}
+	if !stats.ConnectedAt.IsZero() {
+		record.Duration = stats.DisconnectedAt.Sub(stats.ConnectedAt)
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if err := metrics.Append(metricsFile, record); err != nil {
+		logger().Warn("Cannot save connection metrics", zap.String("file", metricsFile), zap.Error(err))
+	}
+}
@@ -4,13 +4,16 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 	"moul.io/assh/v2/pkg/config"
+	"moul.io/assh/v2/pkg/recording"
 )
 
 var wrapperCommand = &cobra.Command{
@@ -103,6 +106,20 @@ func runSSHWrapperCommand(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	// Record runs the command in a recorded pseudo-terminal, instead of replacing assh
+	host := conf.GetHostSafe(target[strings.LastIndex(target, "@")+1:])
+	if recorder := startRecording(conf, host, strings.Join(sshArgs, " "), time.Now()); recorder != nil {
+		err := recording.Run(exec.Command(bin, sshArgs[1:]...), recorder) // #nosec
+		if err := recorder.Close(); err != nil {
+			logger().Warn("Failed to write the recording", zap.Error(err))
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+		return err
+	}
+
 	// Execute Binary
 	return syscall.Exec(bin, sshArgs, os.Environ()) // #nosec
 }
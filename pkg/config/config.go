@@ -12,9 +12,11 @@ import (
 	"strings"
 	"time"
 
+	humanize "github.com/dustin/go-humanize"
 	"github.com/imdario/mergo"
 	"github.com/moul/flexyaml"
 	"go.uber.org/zap"
+	"moul.io/assh/v2/pkg/recording"
 	"moul.io/assh/v2/pkg/utils"
 	"moul.io/assh/v2/pkg/version"
 )
@@ -23,14 +25,28 @@ var asshBinaryPath = "assh"
 
 const defaultSSHConfigPath = "~/.ssh/config"
 
+const (
+	defaultMetricsFile     = "~/.ssh/assh_metrics.ndjson"
+	defaultHistoryFile     = "~/.ssh/assh_history.ndjson"
+	defaultBandwidthSocket = "~/.ssh/assh_bandwidth.sock"
+	defaultRecordingsDir   = "~/.ssh/assh_recordings"
+)
+
 // Config contains a list of Hosts sections and a Defaults section representing a configuration file
 type Config struct {
-	Hosts             HostsMap `yaml:"hosts,omitempty,flow" json:"hosts"`
-	Templates         HostsMap `yaml:"templates,omitempty,flow" json:"templates"`
-	Defaults          Host     `yaml:"defaults,omitempty,flow" json:"defaults,omitempty"`
-	Includes          []string `yaml:"includes,omitempty,flow" json:"includes,omitempty"`
-	ASSHKnownHostFile string   `yaml:"asshknownhostfile,omitempty,flow" json:"asshknownhostfile,omitempty"`
-	ASSHBinaryPath    string   `yaml:"asshbinarypath,omitempty,flow" json:"asshbinarypath,omitempty"`
+	Hosts               HostsMap `yaml:"hosts,omitempty,flow" json:"hosts"`
+	Templates           HostsMap `yaml:"templates,omitempty,flow" json:"templates"`
+	Defaults            Host     `yaml:"defaults,omitempty,flow" json:"defaults,omitempty"`
+	Includes            []string `yaml:"includes,omitempty,flow" json:"includes,omitempty"`
+	ASSHKnownHostFile   string   `yaml:"asshknownhostfile,omitempty,flow" json:"asshknownhostfile,omitempty"`
+	ASSHBinaryPath      string   `yaml:"asshbinarypath,omitempty,flow" json:"asshbinarypath,omitempty"`
+	ASSHMetricsFile     string   `yaml:"asshmetricsfile,omitempty,flow" json:"asshmetricsfile,omitempty"`
+	ASSHHistoryFile     string   `yaml:"asshhistoryfile,omitempty,flow" json:"asshhistoryfile,omitempty"`
+	BandwidthBudget     string   `yaml:"bandwidthbudget,omitempty,flow" json:"bandwidthbudget,omitempty"`
+	ASSHBandwidthSocket string   `yaml:"asshbandwidthsocket,omitempty,flow" json:"asshbandwidthsocket,omitempty"`
+	ASSHRecordingsDir   string   `yaml:"asshrecordingsdir,omitempty,flow" json:"asshrecordingsdir,omitempty"`
+	RecordingsRetention string   `yaml:"recordingsretention,omitempty,flow" json:"recordingsretention,omitempty"`
+	ASSHHookPluginsDir  string   `yaml:"asshhookpluginsdir,omitempty,flow" json:"asshhookpluginsdir,omitempty"`
 
 	includedFiles map[string]bool
 	sshConfigPath string
@@ -129,6 +145,39 @@ func (c *Config) LoadKnownHosts() error {
 	return scanner.Err()
 }
 
+// MetricsFile returns the path of the file storing the connection metrics, an empty string if disabled with "none"
+func (c *Config) MetricsFile() (string, error) {
+	return dataFile(c.ASSHMetricsFile, defaultMetricsFile)
+}
+
+// HistoryFile returns the path of the file storing the connection attempts, an empty string if disabled with "none"
+func (c *Config) HistoryFile() (string, error) {
+	return dataFile(c.ASSHHistoryFile, defaultHistoryFile)
+}
+
+// BandwidthSocket returns the path of the unix socket used to share the bandwidth budget between the assh processes
+func (c *Config) BandwidthSocket() (string, error) {
+	return dataFile(c.ASSHBandwidthSocket, defaultBandwidthSocket)
+}
+
+// RecordingsDir returns the path of the directory storing the recordings of the terminal sessions, an empty string if
+// disabled with "none"
+func (c *Config) RecordingsDir() (string, error) {
+	return dataFile(c.ASSHRecordingsDir, defaultRecordingsDir)
+}
+
+// dataFile returns the expanded path of a file written by assh, configured value or the default one
+func dataFile(configured string, defaultPath string) (string, error) {
+	switch configured {
+	case "none":
+		return "", nil
+	case "":
+		return utils.ExpandUser(defaultPath)
+	default:
+		return utils.ExpandUser(configured)
+	}
+}
+
 // IncludedFiles returns the list of the included files
 func (c *Config) IncludedFiles() []string {
 	includedFiles := make([]string, 0, len(c.includedFiles))
@@ -545,6 +594,13 @@ func (c *Config) LoadFile(filename string) error {
 		}
 	}
 
+	// the plugin drivers of the hooks are searched in ASSHHookPluginsDir
+	c.Defaults.Hooks.setPluginsDir(c.ASSHHookPluginsDir)
+	for _, hosts := range []HostsMap{c.Hosts, c.Templates} {
+		for _, host := range hosts {
+			host.Hooks.setPluginsDir(c.ASSHHookPluginsDir)
+		}
+	}
 	return nil
 }
 
@@ -595,6 +651,19 @@ func (c *Config) Validate() []error {
 	for _, host := range c.Hosts {
 		errs = append(errs, host.Validate()...)
 	}
+	for _, err := range c.Defaults.Hooks.Validate() {
+		errs = append(errs, fmt.Errorf("defaults: %v", err))
+	}
+	if c.BandwidthBudget != "" {
+		if _, err := humanize.ParseBytes(c.BandwidthBudget); err != nil {
+			errs = append(errs, fmt.Errorf("invalid value for 'BandwidthBudget': %q", c.BandwidthBudget))
+		}
+	}
+	if c.RecordingsRetention != "" {
+		if _, err := recording.ParseRetention(c.RecordingsRetention); err != nil {
+			errs = append(errs, fmt.Errorf("invalid value for 'RecordingsRetention': %q", c.RecordingsRetention))
+		}
+	}
 	return errs
 }
 
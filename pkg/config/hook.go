@@ -2,6 +2,8 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
+	"strings"
 
 	"moul.io/assh/v2/pkg/hooks"
 )
@@ -14,18 +16,82 @@ type HostHooks struct {
 	OnConnect         hooks.Hooks `yaml:"onconnect,omitempty,flow" json:"OnConnect,omitempty"`
 	OnConnectError    hooks.Hooks `yaml:"onconnecterror,omitempty,flow" json:"OnConnectError,omitempty"`
 	OnDisconnect      hooks.Hooks `yaml:"ondisconnect,omitempty,flow" json:"OnDisconnect,omitempty"`
+	BeforeDisconnect  hooks.Hooks `yaml:"beforedisconnect,omitempty,flow" json:"BeforeDisconnect,omitempty"`
+	OnRetry           hooks.Hooks `yaml:"onretry,omitempty,flow" json:"OnRetry,omitempty"`
+	OnGatewayFallback hooks.Hooks `yaml:"ongatewayfallback,omitempty,flow" json:"OnGatewayFallback,omitempty"`
+	OnResolve         hooks.Hooks `yaml:"onresolve,omitempty,flow" json:"OnResolve,omitempty"`
+	OnIdle            hooks.Hooks `yaml:"onidle,omitempty,flow" json:"OnIdle,omitempty"`
+}
+
+// HookEvent is an event and its hooks
+type HookEvent struct {
+	Name  string
+	Hooks hooks.Hooks
+}
+
+// Events returns the hooks of every event, in the order of a connection
+func (hh *HostHooks) Events() []HookEvent {
+	if hh == nil {
+		hh = &HostHooks{}
+	}
+	return []HookEvent{
+		{"BeforeConfigWrite", hh.BeforeConfigWrite},
+		{"AfterConfigWrite", hh.AfterConfigWrite},
+		{"OnResolve", hh.OnResolve},
+		{"BeforeConnect", hh.BeforeConnect},
+		{"OnRetry", hh.OnRetry},
+		{"OnGatewayFallback", hh.OnGatewayFallback},
+		{"OnConnectError", hh.OnConnectError},
+		{"OnConnect", hh.OnConnect},
+		{"OnIdle", hh.OnIdle},
+		{"BeforeDisconnect", hh.BeforeDisconnect},
+		{"OnDisconnect", hh.OnDisconnect},
+	}
+}
+
+// Event returns an event and its hooks, the name is case-insensitive
+func (hh *HostHooks) Event(name string) (HookEvent, bool) {
+	for _, event := range hh.Events() {
+		if strings.EqualFold(event.Name, name) {
+			return event, true
+		}
+	}
+	return HookEvent{}, false
+}
+
+// setPluginsDir sets the directory of the plugin drivers of every hook
+func (hh *HostHooks) setPluginsDir(dir string) {
+	if hh == nil {
+		return
+	}
+	for _, event := range hh.Events() {
+		// the hooks of the events are shared with hh
+		for i := range event.Hooks {
+			event.Hooks[i].PluginsDir = dir
+		}
+	}
+}
+
+// Validate checks the hooks of every event
+func (hh *HostHooks) Validate() []error {
+	errs := []error{}
+	for _, event := range hh.Events() {
+		for _, hook := range event.Hooks {
+			if err := hook.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", event.Name, err))
+			}
+		}
+	}
+	return errs
 }
 
 // Length returns the quantity of hooks of any type
 func (hh *HostHooks) Length() int {
-	if hh == nil {
-		return 0
+	length := 0
+	for _, event := range hh.Events() {
+		length += len(event.Hooks)
 	}
-	return len(hh.AfterConfigWrite) +
-		len(hh.BeforeConnect) +
-		len(hh.OnConnectError) +
-		len(hh.OnDisconnect) +
-		len(hh.OnConnect)
+	return length
 }
 
 // String returns the JSON output
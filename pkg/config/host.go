@@ -6,8 +6,11 @@ import (
 	"io"
 	"os/user"
 	"strings"
+	"time"
 
 	composeyaml "github.com/docker/libcompose/yaml"
+	humanize "github.com/dustin/go-humanize"
+	"moul.io/assh/v2/pkg/upstream"
 	"moul.io/assh/v2/pkg/utils"
 )
 
@@ -128,7 +131,23 @@ type Host struct {
 	Hooks                 *HostHooks                `yaml:"hooks,omitempty,flow" json:"Hooks,omitempty"`
 	Comment               composeyaml.Stringorslice `yaml:"comment,omitempty,flow" json:"Comment,omitempty"`
 	RateLimit             string                    `yaml:"ratelimit,omitempty,flow" json:"RateLimit,omitempty"`
+	RateLimitUp           string                    `yaml:"ratelimitup,omitempty,flow" json:"RateLimitUp,omitempty"`
+	RateLimitDown         string                    `yaml:"ratelimitdown,omitempty,flow" json:"RateLimitDown,omitempty"`
+	RateLimitBurst        string                    `yaml:"ratelimitburst,omitempty,flow" json:"RateLimitBurst,omitempty"`
+	BandwidthWeight       int                       `yaml:"bandwidthweight,omitempty,flow" json:"BandwidthWeight,omitempty"`
+	RelayPort             string                    `yaml:"relayport,omitempty,flow" json:"RelayPort,omitempty"`
+	RelayTimeout          int                       `yaml:"relaytimeout,omitempty,flow" json:"RelayTimeout,omitempty"`
+	IdleTimeout           string                    `yaml:"idletimeout,omitempty,flow" json:"IdleTimeout,omitempty"`
+	MaxSessionDuration    string                    `yaml:"maxsessionduration,omitempty,flow" json:"MaxSessionDuration,omitempty"`
+	DisconnectWarning     string                    `yaml:"disconnectwarning,omitempty,flow" json:"DisconnectWarning,omitempty"`
+	IdleDelay             string                    `yaml:"idledelay,omitempty,flow" json:"IdleDelay,omitempty"`
+	Record                string                    `yaml:"record,omitempty,flow" json:"Record,omitempty"`
 	GatewayConnectTimeout int                       `yaml:"gatewayconnecttimeout,omitempty,flow" json:"GatewayConnectTimeout,omitempty"`
+	RetryAttempts         int                       `yaml:"retryattempts,omitempty,flow" json:"RetryAttempts,omitempty"`
+	RetryBackoff          string                    `yaml:"retrybackoff,omitempty,flow" json:"RetryBackoff,omitempty"`
+	RetryJitter           string                    `yaml:"retryjitter,omitempty,flow" json:"RetryJitter,omitempty"`
+	RetryDeadline         string                    `yaml:"retrydeadline,omitempty,flow" json:"RetryDeadline,omitempty"`
+	HopTimeout            string                    `yaml:"hoptimeout,omitempty,flow" json:"HopTimeout,omitempty"`
 
 	// private assh fields
 	noAutomaticRewrite bool
@@ -166,6 +185,59 @@ func (h *Host) Validate() []error {
 		errs = append(errs, fmt.Errorf("%q: invalid value for 'ControlMaster': %q", h.name, h.ControlMaster))
 	}
 
+	for _, gateway := range h.Gateways {
+		if !upstream.IsProxy(gateway) {
+			continue
+		}
+		if _, err := upstream.Parse(gateway); err != nil {
+			errs = append(errs, fmt.Errorf("%q: invalid gateway %q: %v", h.name, upstream.Redact(gateway), err))
+		}
+	}
+
+	if h.BandwidthWeight < 0 {
+		errs = append(errs, fmt.Errorf("%q: invalid value for 'BandwidthWeight': %d", h.name, h.BandwidthWeight))
+	}
+
+	if h.RetryAttempts < 0 {
+		errs = append(errs, fmt.Errorf("%q: invalid value for 'RetryAttempts': %d", h.name, h.RetryAttempts))
+	}
+
+	for _, field := range []struct{ name, value string }{
+		{"IdleTimeout", h.IdleTimeout},
+		{"MaxSessionDuration", h.MaxSessionDuration},
+		{"DisconnectWarning", h.DisconnectWarning},
+		{"IdleDelay", h.IdleDelay},
+		{"RetryBackoff", h.RetryBackoff},
+		{"RetryJitter", h.RetryJitter},
+		{"RetryDeadline", h.RetryDeadline},
+		{"HopTimeout", h.HopTimeout},
+	} {
+		if field.value == "" {
+			continue
+		}
+		if duration, err := time.ParseDuration(field.value); err != nil || duration < 0 {
+			errs = append(errs, fmt.Errorf("%q: invalid value for '%s': %q", h.name, field.name, field.value))
+		}
+	}
+
+	for _, field := range []struct{ name, value string }{
+		{"RateLimit", h.RateLimit},
+		{"RateLimitUp", h.RateLimitUp},
+		{"RateLimitDown", h.RateLimitDown},
+		{"RateLimitBurst", h.RateLimitBurst},
+	} {
+		if field.value == "" {
+			continue
+		}
+		if _, err := humanize.ParseBytes(field.value); err != nil {
+			errs = append(errs, fmt.Errorf("%q: invalid value for '%s': %q", h.name, field.name, field.value))
+		}
+	}
+
+	for _, err := range h.Hooks.Validate() {
+		errs = append(errs, fmt.Errorf("%q: %v", h.name, err))
+	}
+
 	return errs
 }
 
@@ -572,7 +644,10 @@ func (h *Host) prepare() {
 		h.Inherits[key] = strings.ToLower(name)
 	}
 	for key, name := range h.Gateways {
-		h.Gateways[key] = strings.ToLower(name)
+		// the credentials of the upstream proxies are case sensitive
+		if !upstream.IsProxy(name) {
+			h.Gateways[key] = strings.ToLower(name)
+		}
 	}
 }
 
@@ -1123,10 +1198,74 @@ func (h *Host) ApplyDefaults(defaults *Host) {
 		h.RateLimit = defaults.RateLimit
 	}
 
+	if len(h.RateLimitUp) == 0 {
+		h.RateLimitUp = defaults.RateLimitUp
+	}
+
+	if len(h.RateLimitDown) == 0 {
+		h.RateLimitDown = defaults.RateLimitDown
+	}
+
+	if len(h.RateLimitBurst) == 0 {
+		h.RateLimitBurst = defaults.RateLimitBurst
+	}
+
+	if h.BandwidthWeight == 0 {
+		h.BandwidthWeight = defaults.BandwidthWeight
+	}
+
+	if len(h.RelayPort) == 0 {
+		h.RelayPort = defaults.RelayPort
+	}
+
+	if h.RelayTimeout == 0 {
+		h.RelayTimeout = defaults.RelayTimeout
+	}
+
+	if len(h.IdleTimeout) == 0 {
+		h.IdleTimeout = defaults.IdleTimeout
+	}
+
+	if len(h.MaxSessionDuration) == 0 {
+		h.MaxSessionDuration = defaults.MaxSessionDuration
+	}
+
+	if len(h.DisconnectWarning) == 0 {
+		h.DisconnectWarning = defaults.DisconnectWarning
+	}
+
+	if len(h.IdleDelay) == 0 {
+		h.IdleDelay = defaults.IdleDelay
+	}
+
+	if len(h.Record) == 0 {
+		h.Record = defaults.Record
+	}
+
 	if h.GatewayConnectTimeout == 0 {
 		h.GatewayConnectTimeout = defaults.GatewayConnectTimeout
 	}
 
+	if h.RetryAttempts == 0 {
+		h.RetryAttempts = defaults.RetryAttempts
+	}
+
+	if len(h.RetryBackoff) == 0 {
+		h.RetryBackoff = defaults.RetryBackoff
+	}
+
+	if len(h.RetryJitter) == 0 {
+		h.RetryJitter = defaults.RetryJitter
+	}
+
+	if len(h.RetryDeadline) == 0 {
+		h.RetryDeadline = defaults.RetryDeadline
+	}
+
+	if len(h.HopTimeout) == 0 {
+		h.HopTimeout = defaults.HopTimeout
+	}
+
 	if h.Hooks == nil {
 		h.Hooks = defaults.Hooks
 		if h.Hooks == nil {
@@ -1499,7 +1638,12 @@ func (h *Host) WriteSSHConfigTo(w io.Writer) error {
 			_, _ = fmt.Fprint(w, sliceComment("Inherits", h.Inherits))
 		}
 		if len(h.Gateways) > 0 {
-			_, _ = fmt.Fprint(w, sliceComment("Gateways", h.Gateways))
+			// the passwords of the upstream proxies are not written in the ssh config
+			gateways := make([]string, 0, len(h.Gateways))
+			for _, gateway := range h.Gateways {
+				gateways = append(gateways, upstream.Redact(gateway))
+			}
+			_, _ = fmt.Fprint(w, sliceComment("Gateways", gateways))
 		}
 		if len(h.Comment) > 0 {
 			_, _ = fmt.Fprint(w, sliceComment("Comment", h.Comment))
@@ -1534,6 +1678,54 @@ func (h *Host) WriteSSHConfigTo(w io.Writer) error {
 		if h.RateLimit != "" {
 			_, _ = fmt.Fprint(w, stringComment("RateLimit", h.RateLimit))
 		}
+		if h.RateLimitUp != "" {
+			_, _ = fmt.Fprint(w, stringComment("RateLimitUp", h.RateLimitUp))
+		}
+		if h.RateLimitDown != "" {
+			_, _ = fmt.Fprint(w, stringComment("RateLimitDown", h.RateLimitDown))
+		}
+		if h.RateLimitBurst != "" {
+			_, _ = fmt.Fprint(w, stringComment("RateLimitBurst", h.RateLimitBurst))
+		}
+		if h.BandwidthWeight > 0 {
+			_, _ = fmt.Fprint(w, stringComment("BandwidthWeight", fmt.Sprintf("%d", h.BandwidthWeight)))
+		}
+		if h.RelayPort != "" {
+			_, _ = fmt.Fprint(w, stringComment("RelayPort", h.RelayPort))
+		}
+		if h.RelayTimeout != 0 {
+			_, _ = fmt.Fprint(w, stringComment("RelayTimeout", fmt.Sprintf("%d", h.RelayTimeout)))
+		}
+		if h.IdleTimeout != "" {
+			_, _ = fmt.Fprint(w, stringComment("IdleTimeout", h.IdleTimeout))
+		}
+		if h.MaxSessionDuration != "" {
+			_, _ = fmt.Fprint(w, stringComment("MaxSessionDuration", h.MaxSessionDuration))
+		}
+		if h.DisconnectWarning != "" {
+			_, _ = fmt.Fprint(w, stringComment("DisconnectWarning", h.DisconnectWarning))
+		}
+		if h.IdleDelay != "" {
+			_, _ = fmt.Fprint(w, stringComment("IdleDelay", h.IdleDelay))
+		}
+		if BoolVal(h.Record) {
+			_, _ = fmt.Fprint(w, "  # Record: true\n")
+		}
+		if h.RetryAttempts != 0 {
+			_, _ = fmt.Fprint(w, stringComment("RetryAttempts", fmt.Sprintf("%d", h.RetryAttempts)))
+		}
+		if h.RetryBackoff != "" {
+			_, _ = fmt.Fprint(w, stringComment("RetryBackoff", h.RetryBackoff))
+		}
+		if h.RetryJitter != "" {
+			_, _ = fmt.Fprint(w, stringComment("RetryJitter", h.RetryJitter))
+		}
+		if h.RetryDeadline != "" {
+			_, _ = fmt.Fprint(w, stringComment("RetryDeadline", h.RetryDeadline))
+		}
+		if h.HopTimeout != "" {
+			_, _ = fmt.Fprint(w, stringComment("HopTimeout", h.HopTimeout))
+		}
 
 		aliasIdx++
 	}
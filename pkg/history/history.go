@@ -0,0 +1,170 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+	"moul.io/assh/v2/pkg/logfile"
+)
+
+// Outcome values
+const (
+	Success = "success"
+	Failure = "failure"
+)
+
+// Entry is a connection attempt
+type Entry struct {
+	Time time.Time
+	// Input is the name given to assh, i.e: "bart" or "bart/homer"
+	Input    string
+	HostName string
+	Port     string
+	// Gateways are the configured gateways, tried in order
+	Gateways []string `json:",omitempty"`
+	// Gateway is the gateway used to reach the host, empty if the host was not reached or has no gateway
+	Gateway  string `json:",omitempty"`
+	Outcome  string
+	Duration time.Duration
+	Error    string `json:",omitempty"`
+}
+
+// Failed returns true if the attempt failed
+func (e Entry) Failed() bool {
+	return e.Outcome != Success
+}
+
+// Path returns a human readable description of how the host was reached
+func (e Entry) Path() string {
+	switch e.Gateway {
+	case "":
+		if len(e.Gateways) > 0 {
+			return "-"
+		}
+		return "direct"
+	case "direct":
+		return "direct"
+	default:
+		return "via " + e.Gateway
+	}
+}
+
+// Append appends an entry to the history log
+func Append(log *logfile.File, entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return log.Append(append(line, '\n'))
+}
+
+// Read returns the entries of the history log and its rotated files, from the oldest to the most recent
+func Read(log *logfile.File) ([]Entry, error) {
+	entries := []Entry{}
+	for _, file := range log.Files() {
+		fileEntries, err := readFile(file)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fileEntries...)
+	}
+	// concurrent processes may append their entries out of order
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	return entries, nil
+}
+
+func readFile(name string) ([]Entry, error) {
+	file, err := logfile.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		// rotated meanwhile
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := []Entry{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			logger().Debug("skipping malformed history entry", zap.String("file", name), zap.Error(err))
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// Filter selects history entries, empty fields match every entry
+type Filter struct {
+	// Host is a pattern matched against the input name and the resolved hostname
+	Host    string
+	Since   time.Time
+	Gateway string
+	Failed  bool
+}
+
+// Match returns true if entry is selected by the filter
+func (f Filter) Match(entry Entry) bool {
+	if f.Host != "" {
+		input, _ := path.Match(f.Host, entry.Input)
+		hostname, _ := path.Match(f.Host, entry.HostName)
+		if !input && !hostname {
+			return false
+		}
+	}
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if f.Gateway != "" && entry.Gateway != f.Gateway {
+		return false
+	}
+	if f.Failed && !entry.Failed() {
+		return false
+	}
+	return true
+}
+
+// Select returns the entries matching the filter, keeping the order
+func Select(entries []Entry, filter Filter) []Entry {
+	selected := []Entry{}
+	for _, entry := range entries {
+		if filter.Match(entry) {
+			selected = append(selected, entry)
+		}
+	}
+	return selected
+}
+
+// Last returns the most recent entry of each input name, the most recent first
+func Last(entries []Entry) []Entry {
+	latest := map[string]Entry{}
+	for _, entry := range entries {
+		if previous, found := latest[entry.Input]; !found || !entry.Time.Before(previous.Time) {
+			latest[entry.Input] = entry
+		}
+	}
+	last := make([]Entry, 0, len(latest))
+	for _, entry := range latest {
+		last = append(last, entry)
+	}
+	sort.Slice(last, func(i, j int) bool {
+		if last[i].Time.Equal(last[j].Time) {
+			return last[i].Input < last[j].Input
+		}
+		return last[i].Time.After(last[j].Time)
+	})
+	return last
+}
@@ -0,0 +1,70 @@
+package history
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"moul.io/assh/v2/pkg/logfile"
+)
+
+func Test_AppendRead(t *testing.T) {
+	log := &logfile.File{Path: filepath.Join(t.TempDir(), "history.ndjson"), MaxSize: 400, MaxBackups: 5}
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	entries, err := Read(log)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	for i := 0; i < 10; i++ {
+		entry := Entry{Time: start.Add(time.Duration(i) * time.Minute), Input: "host", HostName: "1.2.3.4", Port: "22", Outcome: Success}
+		require.NoError(t, Append(log, entry))
+	}
+	require.Greater(t, len(log.Files()), 1)
+
+	entries, err = Read(log)
+	require.NoError(t, err)
+	require.Len(t, entries, 10)
+	for i, entry := range entries {
+		require.Equal(t, start.Add(time.Duration(i)*time.Minute), entry.Time.UTC())
+	}
+}
+
+func Test_Entry_Path(t *testing.T) {
+	require.Equal(t, "direct", Entry{}.Path())
+	require.Equal(t, "direct", Entry{Gateways: []string{"direct", "bastion"}, Gateway: "direct"}.Path())
+	require.Equal(t, "via bastion", Entry{Gateways: []string{"direct", "bastion"}, Gateway: "bastion"}.Path())
+	require.Equal(t, "-", Entry{Gateways: []string{"direct", "bastion"}}.Path())
+}
+
+func Test_Select(t *testing.T) {
+	now := time.Now()
+	entries := []Entry{
+		{Time: now.Add(-48 * time.Hour), Input: "web-1", HostName: "10.0.0.1", Outcome: Success},
+		{Time: now.Add(-2 * time.Hour), Input: "web-2", HostName: "10.0.0.2", Gateway: "bastion", Outcome: Success},
+		{Time: now.Add(-time.Hour), Input: "db", HostName: "10.0.1.1", Outcome: Failure, Error: "failed to dial"},
+		{Time: now, Input: "web-1", HostName: "10.0.0.1", Gateway: "bastion", Outcome: Failure},
+	}
+
+	tt := map[string]struct {
+		filter Filter
+		want   []Entry
+	}{
+		"All":      {filter: Filter{}, want: entries},
+		"Pattern":  {filter: Filter{Host: "web-*"}, want: []Entry{entries[0], entries[1], entries[3]}},
+		"HostName": {filter: Filter{Host: "10.0.1.*"}, want: []Entry{entries[2]}},
+		"Since":    {filter: Filter{Since: now.Add(-24 * time.Hour)}, want: entries[1:]},
+		"Gateway":  {filter: Filter{Gateway: "bastion"}, want: []Entry{entries[1], entries[3]}},
+		"Failed":   {filter: Filter{Failed: true}, want: []Entry{entries[2], entries[3]}},
+		"Combined": {filter: Filter{Host: "web-*", Failed: true}, want: []Entry{entries[3]}},
+		"None":     {filter: Filter{Host: "unknown"}, want: []Entry{}},
+	}
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.want, Select(entries, tc.filter))
+		})
+	}
+
+	require.Equal(t, []Entry{entries[3], entries[2], entries[1]}, Last(entries))
+}
@@ -0,0 +1,77 @@
+package hooks
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"moul.io/assh/v2/pkg/templates"
+)
+
+// auditField is a field of the audit messages, extracted from the hook arguments
+type auditField struct {
+	name     string
+	template *template.Template
+}
+
+// auditFields are the fields of the syslog and journald messages, also given to the commands as environment
+// variables; the fields missing from the arguments of a hook are skipped
+var auditFields = func() []auditField {
+	fields := []auditField{}
+	for _, field := range []struct{ name, template string }{
+		{"host", "{{.Host.Name}}"},
+		{"hostname", "{{.Host.HostName}}"},
+		{"port", "{{.Host.Port}}"},
+		{"user", "{{.Host.User}}"},
+		{"gateway", "{{.Stats.Gateway}}"},
+		{"sent", "{{.Stats.SentBytes}}"},
+		{"received", "{{.Stats.ReceivedBytes}}"},
+		{"duration", "{{.Stats.ConnectionDuration}}"},
+		{"reason", "{{.Reason}}"},
+		{"error", "{{.Error}}"},
+	} {
+		fields = append(fields, auditField{name: field.name, template: template.Must(templates.New(field.template))})
+	}
+	return fields
+}()
+
+// auditValue is the value of an audit field
+type auditValue struct {
+	name  string
+	value string
+}
+
+// auditValues returns the values of the audit fields available in args
+func auditValues(args RunArgs) []auditValue {
+	values := []auditValue{}
+	for _, field := range auditFields {
+		var buff bytes.Buffer
+		if err := field.template.Execute(&buff, args); err != nil {
+			continue
+		}
+		if value := buff.String(); value != "" && value != "<no value>" {
+			values = append(values, auditValue{name: field.name, value: value})
+		}
+	}
+	return values
+}
+
+// auditMessage returns the default message of an audit hook, made of the available audit fields
+func auditMessage(values []auditValue) string {
+	parts := []string{"assh"}
+	for _, value := range values {
+		parts = append(parts, value.name+"="+strconv.Quote(value.value))
+	}
+	return strings.Join(parts, " ")
+}
+
+// hasAuditError returns whether the values contain an error, the default severity of the message is then raised
+func hasAuditError(values []auditValue) bool {
+	for _, value := range values {
+		if value.name == "error" {
+			return true
+		}
+	}
+	return false
+}
@@ -2,65 +2,253 @@ package hooks
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
 
 	"go.uber.org/zap"
 	"moul.io/assh/v2/pkg/templates"
 )
 
-// DaemonDriver is a driver that daemons some texts to the terminal
+// the restart policies of a daemon
+const (
+	daemonRestartNever     = "never"
+	daemonRestartOnFailure = "on-failure"
+	daemonRestartAlways    = "always"
+)
+
+const (
+	defaultDaemonRestartDelay = time.Second
+	defaultDaemonStopTimeout  = 5 * time.Second
+)
+
+// DaemonLogsDir contains the logs of the daemons, unless a log file is given
+var DaemonLogsDir = "~/.ssh/assh_daemons"
+
+// DaemonDriver is a driver that runs a command in the background until it is closed
 type DaemonDriver struct {
 	line string
-	cmd  *exec.Cmd
+	// argv is the command run without a shell, instead of line
+	argv []string
+	// env are added to the environment of the command
+	env          []string
+	log          *template.Template
+	restart      string
+	restartDelay time.Duration
+	stopTimeout  time.Duration
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	output   *os.File
+	stopping bool
+	stop     chan struct{}
+	done     chan struct{}
 }
 
 // NewDaemonDriver returns a DaemonDriver instance
-func NewDaemonDriver(line string) (DaemonDriver, error) {
-	return DaemonDriver{
-		line: line,
-	}, nil
+//
+// Usage: daemon [log=path] [restart=never|on-failure|always] [restart-delay=1s] [stop-timeout=5s] command
+func NewDaemonDriver(line string) (*DaemonDriver, error) {
+	opts, rest, err := parseOptions(line, "log", "restart", "restart-delay", "stop-timeout")
+	if err != nil {
+		return nil, err
+	}
+	d := &DaemonDriver{
+		line:         rest,
+		restart:      opts.get("restart", daemonRestartNever),
+		restartDelay: defaultDaemonRestartDelay,
+		stopTimeout:  defaultDaemonStopTimeout,
+	}
+	if !contains([]string{daemonRestartNever, daemonRestartOnFailure, daemonRestartAlways}, d.restart) {
+		return nil, fmt.Errorf("invalid daemon restart %q, expected never, on-failure or always", d.restart)
+	}
+	if value := opts.get("restart-delay", ""); value != "" {
+		if d.restartDelay, err = time.ParseDuration(value); err != nil || d.restartDelay < 0 {
+			return nil, fmt.Errorf("invalid daemon restart-delay %q", value)
+		}
+	}
+	if value := opts.get("stop-timeout", ""); value != "" {
+		if d.stopTimeout, err = time.ParseDuration(value); err != nil || d.stopTimeout < 0 {
+			return nil, fmt.Errorf("invalid daemon stop-timeout %q", value)
+		}
+	}
+	if value := opts.get("log", ""); value != "" {
+		if d.log, err = templates.New(value); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
 }
 
-// Run daemons a line to the terminal
-func (d DaemonDriver) Run(args RunArgs) error {
-	var buff bytes.Buffer
-	tmpl, err := templates.New(d.line + "\n")
+// Run starts the command in its own process group, its output is appended to the log of the daemon; the command is
+// restarted according to the restart policy until the driver is closed
+func (d *DaemonDriver) Run(args RunArgs) error {
+	path, err := d.logPath(args)
 	if err != nil {
 		return err
 	}
-
-	if err := tmpl.Execute(&buff, args); err != nil {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	if d.output, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600); err != nil {
 		return err
 	}
 
-	d.cmd = exec.Command("/bin/sh", "-c", buff.String()) // #nosec
-	d.cmd.Stdout = os.Stderr
-	d.cmd.Stderr = os.Stderr
-	d.cmd.Stdin = os.Stdin
-	if err := d.cmd.Start(); err != nil {
+	d.stop = make(chan struct{})
+	d.done = make(chan struct{})
+	if err := d.start(args); err != nil {
+		close(d.done)
+		_ = d.output.Close()
 		return err
 	}
+	go d.supervise(args)
+	return nil
+}
 
-	go func() {
-		if err := d.cmd.Wait(); err != nil {
-			logger().Error("daemon driver error", zap.Error(err))
+// logPath returns the log file of the daemon, by default named after the host and the command
+func (d *DaemonDriver) logPath(args RunArgs) (string, error) {
+	if d.log != nil {
+		var path bytes.Buffer
+		if err := d.log.Execute(&path, args); err != nil {
+			return "", fmt.Errorf("failed to render the daemon log: %w", err)
 		}
-		logger().Info("daemon exited", zap.String("line", d.line))
-	}()
+		return expandPath(path.String()), nil
+	}
+	name := "daemon"
+	for _, value := range auditValues(args) {
+		if value.name == "host" {
+			name = strings.Map(func(r rune) rune {
+				if r == '/' || r == '\\' || r == ':' || r == '*' {
+					return '_'
+				}
+				return r
+			}, value.value)
+		}
+	}
+	sum := sha256.Sum256([]byte(d.line + "\x00" + strings.Join(d.argv, "\x00")))
+	return filepath.Join(expandPath(DaemonLogsDir), name+"-"+hex.EncodeToString(sum[:4])+".log"), nil
+}
 
+// start starts a new process of the command
+func (d *DaemonDriver) start(args RunArgs) error {
+	cmd, err := newCommand(context.Background(), d.line, d.argv, d.env, args)
+	if err != nil {
+		return err
+	}
+	cmd.Stdout = d.output
+	cmd.Stderr = d.output
+	setProcessGroup(cmd)
+	fmt.Fprintf(d.output, "# %s: starting %s\n", time.Now().Format(time.RFC3339), strings.Join(cmd.Args, " "))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopping {
+		return fmt.Errorf("daemon stopped")
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	d.cmd = cmd
 	return nil
 }
 
-// Close closes a running command
-func (d DaemonDriver) Close() error {
-	if d.cmd == nil || d.cmd.Process == nil {
-		return nil
+// supervise waits for the end of the command and restarts it according to the restart policy
+func (d *DaemonDriver) supervise(args RunArgs) {
+	defer close(d.done)
+	for {
+		d.mu.Lock()
+		cmd := d.cmd
+		d.mu.Unlock()
+
+		err := cmd.Wait()
+		fmt.Fprintf(d.output, "# %s: exited: %v\n", time.Now().Format(time.RFC3339), exitStatus(err))
+		d.mu.Lock()
+		stopping := d.stopping
+		d.mu.Unlock()
+		if stopping {
+			return
+		}
+		if d.restart == daemonRestartNever || (d.restart == daemonRestartOnFailure && err == nil) {
+			if err != nil {
+				logger().Warn("daemon exited", zap.String("line", d.line), zap.Error(err))
+			} else {
+				logger().Debug("daemon exited", zap.String("line", d.line))
+			}
+			return
+		}
+
+		logger().Debug("restarting daemon", zap.String("line", d.line), zap.Error(err), zap.Duration("in", d.restartDelay))
+		select {
+		case <-d.stop:
+			return
+		case <-time.After(d.restartDelay):
+		}
+		if err := d.start(args); err != nil {
+			logger().Warn("failed to restart daemon", zap.String("line", d.line), zap.Error(err))
+			return
+		}
 	}
+}
 
-	err := d.cmd.Process.Kill()
+// Describe returns the command and the log of the daemon
+func (d *DaemonDriver) Describe(args RunArgs) (string, error) {
+	command, err := describeCommand(d.line, d.argv, d.env, args)
 	if err != nil {
-		logger().Warn("daemon failed to stop", zap.String("line", d.line), zap.Error(err))
+		return "", err
+	}
+	path, err := d.logPath(args)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s (in background, restart=%s, logged to %s)", command, d.restart, path), nil
+}
+
+func exitStatus(err error) string {
+	if err == nil {
+		return "exit status 0"
+	}
+	return err.Error()
+}
+
+// Close stops the command: its process group receives SIGTERM, then SIGKILL if it is still running after the stop
+// timeout
+func (d *DaemonDriver) Close() error {
+	d.mu.Lock()
+	if d.done == nil || d.stopping {
+		d.mu.Unlock()
+		return nil
+	}
+	d.stopping = true
+	close(d.stop)
+	cmd := d.cmd
+	d.mu.Unlock()
+
+	var err error
+	if cmd != nil {
+		// the group is signaled even if the command exited, to stop its remaining children
+		if err = signalProcessGroup(cmd, false); err != nil {
+			logger().Warn("daemon failed to stop", zap.String("line", d.line), zap.Error(err))
+		}
+		select {
+		case <-d.done:
+		case <-time.After(d.stopTimeout):
+			logger().Warn("daemon still running, killing it", zap.String("line", d.line))
+			if err = signalProcessGroup(cmd, true); err != nil {
+				logger().Warn("daemon failed to stop", zap.String("line", d.line), zap.Error(err))
+			}
+		}
+		// the children still running after the SIGTERM of the command are killed
+		_ = signalProcessGroup(cmd, true)
 	}
+	<-d.done
+	_ = d.output.Close()
 	return err
 }
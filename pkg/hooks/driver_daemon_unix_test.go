@@ -0,0 +1,151 @@
+//go:build !windows
+// +build !windows
+
+package hooks
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// processRunning returns whether the process is running, the zombies waiting for their parent are not
+func processRunning(pid int) bool {
+	if err := syscall.Kill(pid, 0); err != nil {
+		return false
+	}
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return true
+	}
+	// the state follows the command name, which is in parenthesis
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) == 0 || fields[0] != "Z"
+}
+
+// readPid waits for a pid to be written in the file at path
+func readPid(t *testing.T, path string) int {
+	var pid int
+	require.Eventually(t, func() bool {
+		content, err := os.ReadFile(path)
+		if err != nil || !strings.HasSuffix(string(content), "\n") {
+			return false
+		}
+		pid, err = strconv.Atoi(strings.TrimSpace(string(content)))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	return pid
+}
+
+func Test_DaemonDriver_Close(t *testing.T) {
+	tt := map[string]struct {
+		line    string
+		maxStop time.Duration
+	}{
+		"terminated": {
+			line:    "sleep 60 & echo $! > {{.Dir}}/child; echo $$ > {{.Dir}}/leader; wait",
+			maxStop: 2 * time.Second,
+		},
+		"killed": {
+			// the ignored signals are inherited by the children
+			line:    "stop-timeout=200ms trap '' TERM; sleep 60 & echo $! > {{.Dir}}/child; echo $$ > {{.Dir}}/leader; wait",
+			maxStop: 2 * time.Second,
+		},
+	}
+	for name, test := range tt {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			driver, err := NewDaemonDriver("log=" + dir + "/daemon.log " + test.line)
+			require.NoError(t, err)
+			require.NoError(t, driver.Run(map[string]string{"Dir": dir}))
+			leader, child := readPid(t, filepath.Join(dir, "leader")), readPid(t, filepath.Join(dir, "child"))
+			require.True(t, processRunning(leader))
+			require.True(t, processRunning(child))
+
+			start := time.Now()
+			require.NoError(t, driver.Close())
+			require.Less(t, time.Since(start), test.maxStop)
+			require.Eventually(t, func() bool {
+				return !processRunning(leader) && !processRunning(child)
+			}, time.Second, 10*time.Millisecond)
+			require.NoError(t, driver.Close())
+		})
+	}
+}
+
+func Test_DaemonDriver_Restart(t *testing.T) {
+	countRuns := func(path string) int {
+		content, _ := os.ReadFile(path)
+		return strings.Count(string(content), "run\n")
+	}
+
+	t.Run("always", func(t *testing.T) {
+		dir := t.TempDir()
+		driver, err := NewDaemonDriver("log=" + dir + "/daemon.log restart=always restart-delay=10ms echo run >> " + dir + "/runs")
+		require.NoError(t, err)
+		require.NoError(t, driver.Run(nil))
+		require.Eventually(t, func() bool { return countRuns(dir+"/runs") >= 3 }, 5*time.Second, 10*time.Millisecond)
+		require.NoError(t, driver.Close())
+		runs := countRuns(dir + "/runs")
+		time.Sleep(50 * time.Millisecond)
+		require.Equal(t, runs, countRuns(dir+"/runs"))
+	})
+
+	t.Run("on-failure", func(t *testing.T) {
+		dir := t.TempDir()
+		driver, err := NewDaemonDriver("log=" + dir + "/daemon.log restart=on-failure restart-delay=10ms echo run >> " + dir + "/runs; test -f " + dir + "/runs.stop || { touch " + dir + "/runs.stop; exit 1; }")
+		require.NoError(t, err)
+		require.NoError(t, driver.Run(nil))
+		require.Eventually(t, func() bool { return countRuns(dir+"/runs") == 2 }, 5*time.Second, 10*time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+		require.Equal(t, 2, countRuns(dir+"/runs"))
+		require.NoError(t, driver.Close())
+	})
+}
+
+func Test_DaemonDriver_Log(t *testing.T) {
+	previousLogsDir := DaemonLogsDir
+	DaemonLogsDir = t.TempDir()
+	defer func() { DaemonLogsDir = previousLogsDir }()
+
+	driver, err := NewDaemonDriver("echo out; echo err >&2; exec sleep 60")
+	require.NoError(t, err)
+	require.NoError(t, driver.Run(testConnectArgs))
+	logs, err := filepath.Glob(filepath.Join(DaemonLogsDir, "bastion-*.log"))
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	require.Eventually(t, func() bool {
+		content, _ := os.ReadFile(logs[0])
+		return strings.Contains(string(content), "out\nerr\n")
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, driver.Close())
+
+	content, err := os.ReadFile(logs[0])
+	require.NoError(t, err)
+	require.Contains(t, string(content), ": starting /bin/sh -c echo out")
+	require.Contains(t, string(content), ": exited: signal: terminated")
+	if info, err := os.Stat(logs[0]); err == nil {
+		require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	}
+}
+
+func Test_NewDaemonDriver_Errors(t *testing.T) {
+	for name, line := range map[string]string{
+		"invalid restart":       "restart=sometimes sleep 1",
+		"invalid restart-delay": "restart-delay=soon sleep 1",
+		"invalid stop-timeout":  "stop-timeout=-1s sleep 1",
+		"invalid log":           "log={{.Host sleep 1",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewDaemonDriver(line)
+			require.Error(t, err)
+		})
+	}
+}
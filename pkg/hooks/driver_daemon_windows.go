@@ -0,0 +1,21 @@
+//go:build windows
+// +build windows
+
+package hooks
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup is a no-op, only the command itself is stopped on this platform
+func setProcessGroup(_ *exec.Cmd) {}
+
+// signalProcessGroup kills the command, there is no graceful termination on this platform
+func signalProcessGroup(cmd *exec.Cmd, _ bool) error {
+	if err := cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	return nil
+}
@@ -2,10 +2,13 @@ package hooks
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"runtime"
+	"strings"
+	"text/template"
 
 	"moul.io/assh/v2/pkg/templates"
 )
@@ -23,6 +26,10 @@ var possibleShells = []string{
 // ExecDriver is a driver that execs some texts to the terminal
 type ExecDriver struct {
 	line string
+	// argv is the command run without a shell, instead of line
+	argv []string
+	// env are added to the environment of the command
+	env []string
 }
 
 // NewExecDriver returns a ExecDriver instance
@@ -34,18 +41,16 @@ func NewExecDriver(line string) (ExecDriver, error) {
 
 // Run execs a line to the terminal
 func (d ExecDriver) Run(args RunArgs) error {
-	selectedShell := findAvailableShell(possibleShells)
-	if selectedShell == "" {
-		return fmt.Errorf("no available shell found. (tried %s)", possibleShells)
-	}
+	return d.RunContext(context.Background(), args)
+}
 
-	command, err := renderCommand(d.line, args)
+// RunContext execs a line to the terminal, the command is killed when ctx is done
+func (d ExecDriver) RunContext(ctx context.Context, args RunArgs) error {
+	proc, err := newCommand(ctx, d.line, d.argv, d.env, args)
 	if err != nil {
-		return fmt.Errorf("failed to render command: %w", err)
+		return err
 	}
 
-	proc := exec.Command(selectedShell, "-c", command) // #nosec
-
 	proc.Stdout = os.Stderr
 	proc.Stderr = os.Stderr
 	proc.Stdin = os.Stdin
@@ -57,9 +62,61 @@ func (d ExecDriver) Run(args RunArgs) error {
 	return proc.Wait()
 }
 
+// Describe returns the command
+func (d ExecDriver) Describe(args RunArgs) (string, error) {
+	return describeCommand(d.line, d.argv, d.env, args)
+}
+
 // Close is mandatory for the interface, here it does nothing
 func (d ExecDriver) Close() error { return nil }
 
+// newCommand returns the command of a hook: the line run by a shell, or argv run without a shell. The context of the
+// hook is given as environment variables, followed by env.
+func newCommand(ctx context.Context, line string, argv []string, env []string, args RunArgs) (*exec.Cmd, error) {
+	var proc *exec.Cmd
+	if len(argv) > 0 {
+		rendered := make([]string, 0, len(argv))
+		for _, arg := range argv {
+			value, err := renderTemplate(arg, args)
+			if err != nil {
+				return nil, fmt.Errorf("failed to render command: %w", err)
+			}
+			rendered = append(rendered, value)
+		}
+		proc = exec.CommandContext(ctx, rendered[0], rendered[1:]...) // #nosec
+	} else {
+		selectedShell := findAvailableShell(possibleShells)
+		if selectedShell == "" {
+			return nil, fmt.Errorf("no available shell found. (tried %s)", possibleShells)
+		}
+
+		command, err := renderCommand(line, args)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render command: %w", err)
+		}
+		proc = exec.CommandContext(ctx, selectedShell, "-c", command) // #nosec
+	}
+	proc.Env = append(append(os.Environ(), contextEnv(args)...), env...)
+	return proc, nil
+}
+
+// statsJSON renders the statistics of a connection, given as ASSH_STATS_JSON
+var statsJSON = template.Must(templates.New("{{json .Stats}}"))
+
+// contextEnv returns the context of a hook as environment variables: the audit fields, as ASSH_HOST, ASSH_HOSTNAME,
+// ASSH_PORT, ASSH_GATEWAY, ASSH_ERROR..., and the statistics of the connection as ASSH_STATS_JSON
+func contextEnv(args RunArgs) []string {
+	env := []string{}
+	for _, value := range auditValues(args) {
+		env = append(env, "ASSH_"+strings.ToUpper(value.name)+"="+value.value)
+	}
+	var buff bytes.Buffer
+	if err := statsJSON.Execute(&buff, args); err == nil && buff.String() != "null" {
+		env = append(env, "ASSH_STATS_JSON="+buff.String())
+	}
+	return env
+}
+
 func renderCommand(line string, tmplArgs RunArgs) (string, error) {
 	tmpl, err := templates.New(line + "\n")
 	if err != nil {
@@ -4,7 +4,10 @@
 package hooks
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -116,3 +119,51 @@ func Test_findAvailableShell(t *testing.T) {
 		})
 	}
 }
+
+func Test_contextEnv(t *testing.T) {
+	args := testConnectArgs
+	args.Error = "it's $(broken)"
+	env := contextEnv(args)
+	require.Contains(t, env, "ASSH_HOST=bastion")
+	require.Contains(t, env, "ASSH_HOSTNAME=10.0.0.1")
+	require.Contains(t, env, "ASSH_PORT=22")
+	require.Contains(t, env, "ASSH_GATEWAY=direct")
+	require.Contains(t, env, "ASSH_ERROR=it's $(broken)")
+	require.Contains(t, env, `ASSH_STATS_JSON={"Gateway":"direct","SentBytes":42,"ReceivedBytes":1337,"ConnectionDuration":60000000000}`)
+
+	require.Equal(t, []string{}, contextEnv(map[string]string{"SSHConfigPath": "~/.ssh/config"}))
+}
+
+func Test_ExecDriver_Env(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "env")
+	args := testConnectArgs
+	args.Error = `"quoted" $(touch /tmp/injected) ; exit 1`
+
+	driver, err := NewExecDriver(`printf '%s|%s|%s' "$ASSH_HOST" "$ASSH_PORT" "$ASSH_ERROR" > ` + path)
+	require.NoError(t, err)
+	require.NoError(t, driver.Run(args))
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, `bastion|22|"quoted" $(touch /tmp/injected) ; exit 1`, string(content))
+}
+
+func Test_Hooks_InvokeAll_Argv(t *testing.T) {
+	dir := t.TempDir()
+	previousLogsDir := DaemonLogsDir
+	DaemonLogsDir = dir
+	defer func() { DaemonLogsDir = previousLogsDir }()
+	hooks := Hooks{
+		{Driver: "exec", Args: []string{"touch", dir + "/{{.Host.Name}}"}, OnFailure: OnFailureAbort},
+		{Driver: "daemon", Args: []string{"touch", dir + "/daemon {{.Host.Name}}"}, OnFailure: OnFailureAbort},
+	}
+	drivers, err := hooks.InvokeAll("OnConnect", map[string]interface{}{"Host": map[string]string{"Name": "a; touch b"}})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(dir, "daemon a; touch b"))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	drivers.Close()
+
+	require.FileExists(t, filepath.Join(dir, "a; touch b"))
+	require.NoFileExists(t, filepath.Join(dir, "b"))
+}
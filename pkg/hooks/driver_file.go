@@ -0,0 +1,146 @@
+package hooks
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"moul.io/assh/v2/pkg/logfile"
+	"moul.io/assh/v2/pkg/templates"
+	"moul.io/assh/v2/pkg/utils"
+)
+
+const defaultFileMaxBackups = 5
+
+// FileDriver is a driver that appends some texts to a file
+type FileDriver struct {
+	path       *template.Template
+	line       *template.Template
+	mode       os.FileMode
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	compress   bool
+}
+
+// NewFileDriver returns a FileDriver instance
+//
+// Usage: file [mode=0600] [max-size=10MB] [max-age=24h] [max-backups=5] [compress=true] path|"quoted path" [line]
+func NewFileDriver(line string) (FileDriver, error) {
+	opts, rest, err := parseOptions(line, "mode", "max-size", "max-age", "max-backups", "compress")
+	if err != nil {
+		return FileDriver{}, err
+	}
+
+	d := FileDriver{mode: 0o600, maxBackups: defaultFileMaxBackups}
+	if value := opts.get("mode", ""); value != "" {
+		mode, err := strconv.ParseUint(value, 8, 32)
+		if err != nil || mode > 0o777 {
+			return FileDriver{}, fmt.Errorf("invalid file mode %q", value)
+		}
+		d.mode = os.FileMode(mode)
+	}
+	if value := opts.get("max-size", ""); value != "" {
+		size, err := humanize.ParseBytes(value)
+		if err != nil {
+			return FileDriver{}, fmt.Errorf("invalid file max-size %q", value)
+		}
+		d.maxSize = int64(size)
+	}
+	if value := opts.get("max-age", ""); value != "" {
+		if d.maxAge, err = time.ParseDuration(value); err != nil || d.maxAge < 0 {
+			return FileDriver{}, fmt.Errorf("invalid file max-age %q", value)
+		}
+	}
+	if value := opts.get("max-backups", ""); value != "" {
+		if d.maxBackups, err = strconv.Atoi(value); err != nil || d.maxBackups < 0 {
+			return FileDriver{}, fmt.Errorf("invalid file max-backups %q", value)
+		}
+	}
+	if value := opts.get("compress", ""); value != "" {
+		if d.compress, err = strconv.ParseBool(value); err != nil {
+			return FileDriver{}, fmt.Errorf("invalid file compress %q", value)
+		}
+	}
+
+	path, rest, err := parseArgument(rest)
+	if err != nil {
+		return FileDriver{}, err
+	}
+	if path == "" {
+		return FileDriver{}, errors.New("missing file path")
+	}
+	if d.path, err = templates.New(path); err != nil {
+		return FileDriver{}, err
+	}
+	// the arguments are written as JSON by default
+	format := "{{json .}}"
+	if strings.TrimSpace(rest) != "" {
+		format = rest
+	}
+	if d.line, err = templates.New(format); err != nil {
+		return FileDriver{}, err
+	}
+	return d, nil
+}
+
+// Run appends a line to the file, rotating it first if needed
+func (d FileDriver) Run(args RunArgs) error {
+	path, line, err := d.render(args)
+	if err != nil {
+		return err
+	}
+
+	file := logfile.File{
+		Path:       path,
+		MaxSize:    d.maxSize,
+		MaxAge:     d.maxAge,
+		MaxBackups: d.maxBackups,
+		Compress:   d.compress,
+		Mode:       d.mode,
+	}
+	return file.Append(line)
+}
+
+// Describe returns the path of the file and the line appended to it
+func (d FileDriver) Describe(args RunArgs) (string, error) {
+	path, line, err := d.render(args)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s: %s", path, bytes.TrimSuffix(line, []byte("\n"))), nil
+}
+
+// render returns the path of the file and the line, ending with a newline
+func (d FileDriver) render(args RunArgs) (string, []byte, error) {
+	var path, line bytes.Buffer
+	if err := d.path.Execute(&path, args); err != nil {
+		return "", nil, err
+	}
+	if err := d.line.Execute(&line, args); err != nil {
+		return "", nil, err
+	}
+	if !bytes.HasSuffix(line.Bytes(), []byte("\n")) {
+		line.WriteByte('\n')
+	}
+	return expandPath(path.String()), line.Bytes(), nil
+}
+
+// expandPath expands the environment variables and the leading tilde of a path
+func expandPath(path string) string {
+	path = utils.ExpandEnvSafe(path)
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		path = utils.GetHomeDir() + path[1:]
+	}
+	return filepath.FromSlash(path)
+}
+
+// Close is mandatory for the interface, here it does nothing
+func (d FileDriver) Close() error { return nil }
@@ -0,0 +1,137 @@
+package hooks
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_FileDriver(t *testing.T) {
+	args := map[string]interface{}{"Host": map[string]string{"Name": "bastion"}, "Error": `it's "quoted"`}
+
+	tt := map[string]struct {
+		line     string
+		expected string
+	}{
+		"json by default": {
+			line:     "{{.Dir}}/audit.log",
+			expected: `{"Error":"it's \"quoted\"","Host":{"Name":"bastion"}}` + "\n",
+		},
+		"template": {
+			line:     "{{.Dir}}/audit.log {{.Host.Name}}: {{.Error}}",
+			expected: `bastion: it's "quoted"` + "\n",
+		},
+		"templated path": {
+			line:     "{{.Dir}}/hosts/{{.Host.Name}}.log connected",
+			expected: "connected\n",
+		},
+		"quoted path with a space": {
+			line:     `"{{.Dir}}/my logs/audit.log" {{.Host.Name}}`,
+			expected: "bastion\n",
+		},
+		"trailing newline kept": {
+			line:     "{{.Dir}}/audit.log {{.Host.Name}}{{\"\\n\"}}",
+			expected: "bastion\n",
+		},
+	}
+	for name, test := range tt {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			driver, err := NewFileDriver(strings.Replace(test.line, "{{.Dir}}", filepath.ToSlash(dir), 1))
+			require.NoError(t, err)
+			require.NoError(t, driver.Run(args))
+			require.NoError(t, driver.Run(args))
+			require.NoError(t, driver.Close())
+
+			path := filepath.Join(dir, "audit.log")
+			switch name {
+			case "templated path":
+				path = filepath.Join(dir, "hosts", "bastion.log")
+			case "quoted path with a space":
+				path = filepath.Join(dir, "my logs", "audit.log")
+			}
+			content, err := os.ReadFile(path)
+			require.NoError(t, err)
+			require.Equal(t, test.expected+test.expected, string(content))
+			if os.PathSeparator == '/' {
+				info, err := os.Stat(path)
+				require.NoError(t, err)
+				require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+			}
+		})
+	}
+}
+
+func Test_FileDriver_Rotation(t *testing.T) {
+	dir := t.TempDir()
+	driver, err := NewFileDriver("max-size=10B max-backups=1 compress=true mode=0640 " + filepath.ToSlash(dir) + "/audit.log {{.}}")
+	require.NoError(t, err)
+	for _, line := range []string{"aaaa", "bbbb", "cccc"} {
+		require.NoError(t, driver.Run(line))
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "audit.log"))
+	require.NoError(t, err)
+	require.Equal(t, "cccc\n", string(content))
+	require.FileExists(t, filepath.Join(dir, "audit.log.1.gz"))
+	if os.PathSeparator == '/' {
+		info, err := os.Stat(filepath.Join(dir, "audit.log"))
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o640), info.Mode().Perm())
+	}
+}
+
+func Test_FileDriver_Concurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	driver, err := NewFileDriver(filepath.ToSlash(path) + " {{.}}")
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				require.NoError(t, driver.Run(strings.Repeat("x", 1000)))
+			}
+		}()
+	}
+	wg.Wait()
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	require.Len(t, lines, 100)
+	for _, line := range lines {
+		require.Len(t, line, 1000)
+	}
+}
+
+func Test_expandPath(t *testing.T) {
+	t.Setenv("HOME", "/home/test")
+	t.Setenv("ASSH_TEST_DIR", "logs")
+	require.Equal(t, filepath.FromSlash("/home/test/.ssh/audit.log"), expandPath("~/.ssh/audit.log"))
+	require.Equal(t, filepath.FromSlash("/home/test/logs/a b.log"), expandPath("~/$ASSH_TEST_DIR/a b.log"))
+	require.Equal(t, filepath.FromSlash("/var/log/~/audit.log"), expandPath("/var/log/~/audit.log"))
+}
+
+func Test_NewFileDriver_Errors(t *testing.T) {
+	for name, line := range map[string]string{
+		"no path":             "",
+		"invalid mode":        "mode=rw /tmp/audit.log",
+		"invalid max-size":    "max-size=big /tmp/audit.log",
+		"invalid max-age":     "max-age=1 /tmp/audit.log",
+		"invalid max-backups": "max-backups=-1 /tmp/audit.log",
+		"invalid compress":    "compress=maybe /tmp/audit.log",
+		"invalid template":    "/tmp/audit.log {{ not_a_function }}",
+		"unterminated quote":  `"/tmp/my logs/audit.log {{.Host.Name}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewFileDriver(line)
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package hooks
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"moul.io/assh/v2/pkg/templates"
+)
+
+// journaldSocket is the socket of the native protocol of journald, it is replaced by the tests
+var journaldSocket = "/run/systemd/journal/socket"
+
+// JournaldDriver is a driver that sends some texts to journald, with the details of the connection as fields
+type JournaldDriver struct {
+	priority   int
+	identifier string
+	message    *template.Template
+}
+
+// NewJournaldDriver returns a JournaldDriver instance
+//
+// Usage: journald [priority=info] [identifier=assh] [message]
+func NewJournaldDriver(line string) (JournaldDriver, error) {
+	opts, rest, err := parseOptions(line, "priority", "identifier")
+	if err != nil {
+		return JournaldDriver{}, err
+	}
+	d := JournaldDriver{priority: -1, identifier: opts.get("identifier", "assh")}
+	if priority := opts.get("priority", ""); priority != "" {
+		var ok bool
+		if d.priority, ok = syslogSeverities[priority]; !ok {
+			return JournaldDriver{}, fmt.Errorf("invalid journald priority %q", priority)
+		}
+	}
+	if strings.TrimSpace(rest) != "" {
+		if d.message, err = templates.New(rest); err != nil {
+			return JournaldDriver{}, err
+		}
+	}
+	return d, nil
+}
+
+// Run sends an entry to journald, the audit values are sent as ASSH_* fields
+func (d JournaldDriver) Run(args RunArgs) error {
+	fields, err := d.fields(args)
+	if err != nil {
+		return err
+	}
+	var entry bytes.Buffer
+	for _, field := range fields {
+		writeJournaldField(&entry, field.name, field.value)
+	}
+
+	conn, err := net.DialTimeout("unixgram", journaldSocket, syslogTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to reach journald: %w", err)
+	}
+	defer conn.Close()
+	_ = conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	_, err = conn.Write(entry.Bytes())
+	return err
+}
+
+// Describe returns the fields of the entry sent to journald
+func (d JournaldDriver) Describe(args RunArgs) (string, error) {
+	fields, err := d.fields(args)
+	if err != nil {
+		return "", err
+	}
+	parts := []string{}
+	for _, field := range fields {
+		parts = append(parts, field.name+"="+strconv.Quote(field.value))
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// fields returns the fields of the entry: the message, the priority, the identifier and the audit values
+func (d JournaldDriver) fields(args RunArgs) ([]auditValue, error) {
+	values := auditValues(args)
+	message := auditMessage(values)
+	if d.message != nil {
+		var buff bytes.Buffer
+		if err := d.message.Execute(&buff, args); err != nil {
+			return nil, err
+		}
+		message = strings.TrimRight(buff.String(), "\n")
+	}
+	priority := d.priority
+	if priority < 0 {
+		priority = syslogSeverities["info"]
+		if hasAuditError(values) {
+			priority = syslogSeverities["warning"]
+		}
+	}
+
+	fields := []auditValue{
+		{name: "MESSAGE", value: message},
+		{name: "PRIORITY", value: strconv.Itoa(priority)},
+		{name: "SYSLOG_IDENTIFIER", value: d.identifier},
+	}
+	for _, value := range values {
+		fields = append(fields, auditValue{name: "ASSH_" + strings.ToUpper(value.name), value: value.value})
+	}
+	return fields, nil
+}
+
+// writeJournaldField appends a field in the native journald format: "NAME=value\n", or the name followed by the
+// little-endian 64 bits length of the value and the value for the values containing newlines
+func writeJournaldField(w *bytes.Buffer, name string, value string) {
+	if !strings.Contains(value, "\n") {
+		w.WriteString(name + "=" + value + "\n")
+		return
+	}
+	w.WriteString(name + "\n")
+	_ = binary.Write(w, binary.LittleEndian, uint64(len(value)))
+	w.WriteString(value + "\n")
+}
+
+// Close is mandatory for the interface, here it does nothing
+func (d JournaldDriver) Close() error { return nil }
@@ -41,5 +41,10 @@ func (d NotificationDriver) Run(args RunArgs) error {
 	return notification.Push()
 }
 
+// Describe returns the text of the notification
+func (d NotificationDriver) Describe(args RunArgs) (string, error) {
+	return renderTemplate(d.line, args)
+}
+
 // Close is mandatory for the interface, here it does nothing
 func (d NotificationDriver) Close() error { return nil }
@@ -3,8 +3,13 @@
 
 package hooks
 
+import "errors"
+
 type NotificationDriver struct{}
 
 func NewNotificationDriver(_ string) (NotificationDriver, error) { return NotificationDriver{}, nil }
 func (NotificationDriver) Run(_ RunArgs) error                   { return nil }
 func (d NotificationDriver) Close() error                        { return nil }
+func (NotificationDriver) Describe(_ RunArgs) (string, error) {
+	return "", errors.New("the notifications are not supported on this platform")
+}
@@ -0,0 +1,213 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"text/template"
+
+	"moul.io/assh/v2/pkg/templates"
+)
+
+// pluginPrefix is the prefix of the executables of the plugin drivers, the "vpn" driver runs assh-hook-vpn
+const pluginPrefix = "assh-hook-"
+
+// DefaultPluginsDir contains the executables of the plugin drivers of the hooks without PluginsDir, it is searched
+// before the PATH
+const DefaultPluginsDir = "~/.ssh/assh_hooks.d"
+
+// pluginName matches the names of the plugin drivers
+var pluginName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+// isPluginName returns whether name can be the name of a plugin driver, the names of the drivers of assh take
+// precedence
+func isPluginName(name string) bool {
+	return pluginName.MatchString(name) && !contains(driverNames, name)
+}
+
+// PluginRequest is the JSON sent on the standard input of a plugin
+type PluginRequest struct {
+	// Event is the name of the event, i.e: "BeforeConnect"
+	Event string
+	// Params are the rendered parameters of the hook, the text following the name of the driver
+	Params string
+	// Args are the arguments of the event, as given to the templates
+	Args RunArgs
+}
+
+// PluginResult is the JSON a plugin can write on its standard output, an empty output changes nothing
+type PluginResult struct {
+	// Veto fails the hook whatever its on-failure policy, a BeforeConnect hook then vetoes the connection
+	Veto   bool
+	Reason string
+	// Host contains the fields of the host to change, i.e: {"HostName": "10.0.0.2", "Port": "2222"}
+	Host json.RawMessage
+}
+
+// pluginVeto is the error of a plugin vetoing an event
+type pluginVeto struct {
+	plugin string
+	reason string
+}
+
+func (v pluginVeto) Error() string {
+	if v.reason == "" {
+		return v.plugin + " vetoed"
+	}
+	return v.plugin + " vetoed: " + v.reason
+}
+
+// isVeto returns whether err is the veto of a plugin
+func isVeto(err error) bool {
+	var veto pluginVeto
+	return errors.As(err, &veto)
+}
+
+// PluginDriver is a driver that runs an external executable, assh-hook-<name>, with the event and its arguments as
+// JSON on its standard input
+type PluginDriver struct {
+	name   string
+	path   string
+	params *template.Template
+	// event is the name of the event, set when the hook is invoked
+	event string
+}
+
+// NewPluginDriver returns a PluginDriver instance, the executable is searched in dir, then in the PATH
+//
+// Usage: <name> [params]
+func NewPluginDriver(dir string, name string, params string) (*PluginDriver, error) {
+	if !isPluginName(name) {
+		return nil, fmt.Errorf("invalid plugin driver name %q", name)
+	}
+	path, err := exec.LookPath(filepath.Join(expandPath(dir), pluginPrefix+name))
+	if err != nil {
+		if path, err = exec.LookPath(pluginPrefix + name); err != nil {
+			return nil, fmt.Errorf("no such driver %q: %s%s not found in %s nor in the PATH", name, pluginPrefix, name, dir)
+		}
+	}
+	tmpl, err := templates.New(params)
+	if err != nil {
+		return nil, err
+	}
+	return &PluginDriver{name: name, path: path, params: tmpl}, nil
+}
+
+// Run runs the plugin
+func (d *PluginDriver) Run(args RunArgs) error {
+	return d.RunContext(context.Background(), args)
+}
+
+// RunContext runs the plugin and applies its result, the plugin is killed when ctx is done
+func (d *PluginDriver) RunContext(ctx context.Context, args RunArgs) error {
+	request, err := d.request(args)
+	if err != nil {
+		return err
+	}
+
+	var stdout bytes.Buffer
+	proc := exec.CommandContext(ctx, d.path) // #nosec
+	proc.Env = append(os.Environ(), contextEnv(args)...)
+	proc.Stdin = bytes.NewReader(request)
+	proc.Stdout = &stdout
+	proc.Stderr = os.Stderr
+	if err := proc.Run(); err != nil {
+		return fmt.Errorf("%s%s: %w", pluginPrefix, d.name, err)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return d.apply(stdout.Bytes(), args)
+}
+
+// Describe returns the command piping the request to the plugin
+func (d *PluginDriver) Describe(args RunArgs) (string, error) {
+	request, err := d.request(args)
+	if err != nil {
+		return "", err
+	}
+	return "echo " + quoteArg(string(request)) + " | " + quoteArg(d.path), nil
+}
+
+// request returns the JSON sent to the plugin
+func (d *PluginDriver) request(args RunArgs) ([]byte, error) {
+	var params bytes.Buffer
+	if err := d.params.Execute(&params, args); err != nil {
+		return nil, err
+	}
+	request, err := json.Marshal(PluginRequest{Event: d.event, Params: params.String(), Args: args})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the arguments of the plugin: %w", err)
+	}
+	return request, nil
+}
+
+// apply parses the result of the plugin, the fields of the host are changed in place
+func (d *PluginDriver) apply(output []byte, args RunArgs) error {
+	if len(bytes.TrimSpace(output)) == 0 {
+		return nil
+	}
+	var result PluginResult
+	if err := json.Unmarshal(output, &result); err != nil {
+		return fmt.Errorf("invalid result of %s%s: %w", pluginPrefix, d.name, err)
+	}
+	if result.Veto {
+		return pluginVeto{plugin: pluginPrefix + d.name, reason: result.Reason}
+	}
+	if len(result.Host) == 0 || string(result.Host) == "null" {
+		return nil
+	}
+	host := mutableHost(args)
+	if host == nil {
+		return fmt.Errorf("%s%s changed the host, but the host of the %s event cannot be changed", pluginPrefix, d.name, d.event)
+	}
+	if err := json.Unmarshal(result.Host, host); err != nil {
+		return fmt.Errorf("invalid host in the result of %s%s: %w", pluginPrefix, d.name, err)
+	}
+	return nil
+}
+
+// mutableHost returns a pointer to the Host of the arguments, shared with the caller, or nil
+func mutableHost(args RunArgs) interface{} {
+	value := reflect.ValueOf(args)
+	if value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+	var host reflect.Value
+	switch value.Kind() {
+	case reflect.Struct:
+		host = value.FieldByName("Host")
+	case reflect.Map:
+		if value.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		host = value.MapIndex(reflect.ValueOf("Host").Convert(value.Type().Key()))
+	default:
+		return nil
+	}
+	if host.IsValid() && host.Kind() == reflect.Interface {
+		host = host.Elem()
+	}
+	switch {
+	case !host.IsValid():
+		return nil
+	case host.Kind() == reflect.Ptr && !host.IsNil() && host.Elem().Kind() == reflect.Struct:
+		return host.Interface()
+	case host.Kind() == reflect.Map && !host.IsNil():
+		// a map is shared, the result is merged into it through a pointer to a copy of the reference
+		ptr := reflect.New(host.Type())
+		ptr.Elem().Set(host)
+		return ptr.Interface()
+	}
+	return nil
+}
+
+// Close is mandatory for the interface, here it does nothing
+func (d *PluginDriver) Close() error { return nil }
@@ -0,0 +1,117 @@
+//go:build !windows
+// +build !windows
+
+package hooks
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// installPlugin writes a plugin driver running script in a temporary directory of plugins
+func installPlugin(t *testing.T, name string, script string) string {
+	dir := t.TempDir()
+	path := filepath.Join(dir, pluginPrefix+name)
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0o755))
+	return dir
+}
+
+func Test_PluginDriver_Request(t *testing.T) {
+	dir := installPlugin(t, "audit", `cat > "$(dirname "$0")/request"; echo "$ASSH_HOST" > "$(dirname "$0")/env"`)
+	args := map[string]interface{}{"Host": map[string]string{"Name": "bastion"}}
+
+	drivers, err := (&Hooks{{Expr: "audit corp {{.Host.Name}}", PluginsDir: dir}}).InvokeAll("BeforeConnect", args)
+	require.NoError(t, err)
+	require.Len(t, drivers, 1)
+
+	content, err := os.ReadFile(filepath.Join(dir, "request"))
+	require.NoError(t, err)
+	var request PluginRequest
+	require.NoError(t, json.Unmarshal(content, &request))
+	require.Equal(t, "BeforeConnect", request.Event)
+	require.Equal(t, "corp bastion", request.Params)
+	require.Equal(t, map[string]interface{}{"Host": map[string]interface{}{"Name": "bastion"}}, request.Args)
+
+	content, err = os.ReadFile(filepath.Join(dir, "env"))
+	require.NoError(t, err)
+	require.Equal(t, "bastion\n", string(content))
+}
+
+func Test_PluginDriver_Result(t *testing.T) {
+	tt := map[string]struct {
+		script   string
+		hook     string
+		err      bool
+		expected map[string]string
+	}{
+		"no output": {script: "cat > /dev/null", expected: map[string]string{"Name": "bastion"}},
+		"host": {
+			script:   `echo '{"Host": {"HostName": "10.0.0.2", "Port": "2222"}}'`,
+			expected: map[string]string{"Name": "bastion", "HostName": "10.0.0.2", "Port": "2222"},
+		},
+		"veto": {script: `echo '{"Veto": true, "Reason": "outside of office hours"}'`, err: true},
+		// a veto aborts whatever the on-failure policy
+		"ignored veto":   {script: `echo '{"Veto": true}'`, hook: "on-failure=ignore ", err: true},
+		"failure":        {script: "exit 1", hook: "on-failure=abort ", err: true},
+		"warned failure": {script: "exit 1", expected: map[string]string{"Name": "bastion"}},
+		"invalid result": {script: "echo oops", hook: "on-failure=abort ", err: true},
+	}
+	for name, test := range tt {
+		t.Run(name, func(t *testing.T) {
+			dir := installPlugin(t, "check", test.script)
+			host := map[string]string{"Name": "bastion"}
+
+			_, err := (&Hooks{{Expr: test.hook + "check", PluginsDir: dir}}).InvokeAll("BeforeConnect", map[string]interface{}{"Host": host})
+			if test.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.expected, host)
+		})
+	}
+}
+
+func Test_PluginDriver_Host(t *testing.T) {
+	type host struct {
+		Name     string
+		HostName string
+	}
+	dir := installPlugin(t, "resolve", `echo '{"Host": {"HostName": "10.0.0.2"}}'`)
+	driver, err := NewPluginDriver(dir, "resolve", "")
+	require.NoError(t, err)
+
+	args := struct{ Host *host }{Host: &host{Name: "bastion"}}
+	require.NoError(t, driver.Run(args))
+	require.Equal(t, host{Name: "bastion", HostName: "10.0.0.2"}, *args.Host)
+
+	// the host of the arguments cannot be changed through a copy
+	require.Error(t, driver.Run(struct{ Host host }{Host: host{Name: "bastion"}}))
+}
+
+func Test_PluginDriver_Describe(t *testing.T) {
+	dir := installPlugin(t, "audit", "cat > /dev/null")
+	results := (&Hooks{{Expr: "audit {{.Host.Name}}", PluginsDir: dir}}).DryRunAll("OnConnect", map[string]interface{}{"Host": map[string]string{"Name": "bastion"}})
+	require.Equal(t, []DryRun{{
+		Hook:   "audit {{.Host.Name}}",
+		Output: `echo '{"Event":"OnConnect","Params":"bastion","Args":{"Host":{"Name":"bastion"}}}' | ` + filepath.Join(dir, "assh-hook-audit"),
+	}}, results)
+}
+
+func Test_NewPluginDriver(t *testing.T) {
+	dir := installPlugin(t, "audit", "true")
+	t.Setenv("PATH", t.TempDir())
+
+	_, err := parseDriver("audit", dir)
+	require.NoError(t, err)
+	_, err = parseDriver("missing", dir)
+	require.Error(t, err)
+	_, err = parseDriver("../audit", dir)
+	require.Error(t, err)
+	_, err = parseDriver("audit {{ .Host", dir)
+	require.Error(t, err)
+}
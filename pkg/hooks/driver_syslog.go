@@ -0,0 +1,219 @@
+package hooks
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"moul.io/assh/v2/pkg/templates"
+)
+
+// syslogTimeout bounds the connection to the syslog server and the sending of a message
+const syslogTimeout = 5 * time.Second
+
+// syslogStructuredDataID is the SD-ID of the structured data of the messages, 32473 is the private enterprise
+// number reserved for the documentation (RFC 5612)
+const syslogStructuredDataID = "assh@32473"
+
+// syslogSockets are the local syslog sockets, the first available one is used
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7, "uucp": 8,
+	"cron": 9, "authpriv": 10, "ftp": 11, "local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20,
+	"local5": 21, "local6": 22, "local7": 23,
+}
+
+var syslogSeverities = map[string]int{
+	"emerg": 0, "alert": 1, "crit": 2, "err": 3, "warning": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+// SyslogDriver is a driver that sends some texts to syslog, with the details of the connection as structured data
+type SyslogDriver struct {
+	network  string
+	address  string
+	facility int
+	severity int
+	app      string
+	message  *template.Template
+}
+
+// NewSyslogDriver returns a SyslogDriver instance
+//
+// Usage: syslog [address=unix:///dev/log|udp://host:514|tcp://host:601] [facility=auth] [severity=info] [app=assh] [message]
+func NewSyslogDriver(line string) (SyslogDriver, error) {
+	opts, rest, err := parseOptions(line, "address", "facility", "severity", "app")
+	if err != nil {
+		return SyslogDriver{}, err
+	}
+	d := SyslogDriver{severity: -1, app: opts.get("app", "assh")}
+
+	if address := opts.get("address", ""); address != "" {
+		u, err := url.Parse(address)
+		if err != nil {
+			return SyslogDriver{}, fmt.Errorf("invalid syslog address %q: %w", address, err)
+		}
+		switch u.Scheme {
+		case "udp", "tcp":
+			if u.Port() == "" {
+				return SyslogDriver{}, fmt.Errorf("invalid syslog address %q: missing port", address)
+			}
+			d.network, d.address = u.Scheme, u.Host
+		case "unix":
+			d.network, d.address = "unix", u.Path
+		default:
+			return SyslogDriver{}, fmt.Errorf("invalid syslog address %q, expected unix://, udp:// or tcp://", address)
+		}
+	}
+
+	var ok bool
+	if d.facility, ok = syslogFacilities[opts.get("facility", "auth")]; !ok {
+		return SyslogDriver{}, fmt.Errorf("invalid syslog facility %q", opts.get("facility", ""))
+	}
+	if severity := opts.get("severity", ""); severity != "" {
+		if d.severity, ok = syslogSeverities[severity]; !ok {
+			return SyslogDriver{}, fmt.Errorf("invalid syslog severity %q", severity)
+		}
+	}
+	if strings.TrimSpace(rest) != "" {
+		if d.message, err = templates.New(rest); err != nil {
+			return SyslogDriver{}, err
+		}
+	}
+	return d, nil
+}
+
+// Run sends a message to syslog
+func (d SyslogDriver) Run(args RunArgs) error {
+	record, err := d.record(args)
+	if err != nil {
+		return err
+	}
+	return d.send(record)
+}
+
+// Describe returns the record sent to syslog
+func (d SyslogDriver) Describe(args RunArgs) (string, error) {
+	record, err := d.record(args)
+	return string(record), err
+}
+
+// record returns the RFC 5424 record of the message
+func (d SyslogDriver) record(args RunArgs) ([]byte, error) {
+	values := auditValues(args)
+	message := auditMessage(values)
+	if d.message != nil {
+		var buff bytes.Buffer
+		if err := d.message.Execute(&buff, args); err != nil {
+			return nil, err
+		}
+		message = buff.String()
+	}
+	severity := d.severity
+	if severity < 0 {
+		severity = syslogSeverities["info"]
+		if hasAuditError(values) {
+			severity = syslogSeverities["warning"]
+		}
+	}
+
+	hostname, _ := os.Hostname()
+	return formatSyslog(d.facility*8+severity, time.Now(), hostname, d.app, os.Getpid(), values, message), nil
+}
+
+// send sends a record to the syslog server: a datagram per record for the datagram sockets, with the octet
+// counting framing of RFC 6587 for tcp
+func (d SyslogDriver) send(record []byte) error {
+	conn, err := d.dial()
+	if err != nil {
+		return fmt.Errorf("failed to reach syslog: %w", err)
+	}
+	defer conn.Close()
+	_ = conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	switch conn.RemoteAddr().Network() {
+	case "tcp":
+		record = append([]byte(strconv.Itoa(len(record))+" "), record...)
+	case "unix":
+		// the local stream sockets separate the records with newlines
+		record = append(record, '\n')
+	}
+	_, err = conn.Write(record)
+	return err
+}
+
+func (d SyslogDriver) dial() (net.Conn, error) {
+	switch d.network {
+	case "udp", "tcp":
+		return net.DialTimeout(d.network, d.address, syslogTimeout)
+	case "unix":
+		return dialUnixSyslog(d.address)
+	}
+	var lastErr error
+	for _, socket := range syslogSockets {
+		conn, err := dialUnixSyslog(socket)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// dialUnixSyslog connects to a local syslog socket, in datagram mode if supported
+func dialUnixSyslog(path string) (net.Conn, error) {
+	conn, err := net.DialTimeout("unixgram", path, syslogTimeout)
+	if err == nil {
+		return conn, nil
+	}
+	return net.DialTimeout("unix", path, syslogTimeout)
+}
+
+// formatSyslog returns an RFC 5424 record, the values are sent as structured data
+func formatSyslog(priority int, timestamp time.Time, hostname string, app string, pid int, values []auditValue, message string) []byte {
+	var record bytes.Buffer
+	fmt.Fprintf(&record, "<%d>1 %s %s %s %d - ",
+		priority,
+		timestamp.Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeaderField(hostname),
+		syslogHeaderField(app),
+		pid,
+	)
+	if len(values) == 0 {
+		record.WriteString("-")
+	} else {
+		record.WriteString("[" + syslogStructuredDataID)
+		for _, value := range values {
+			record.WriteString(" " + value.name + `="` + syslogParamEscaper.Replace(value.value) + `"`)
+		}
+		record.WriteString("]")
+	}
+	if message = strings.TrimRight(message, "\n"); message != "" {
+		record.WriteString(" " + message)
+	}
+	return record.Bytes()
+}
+
+var syslogParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslogHeaderField returns a valid header field: printable ASCII without space, "-" if empty
+func syslogHeaderField(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, value)
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// Close is mandatory for the interface, here it does nothing
+func (d SyslogDriver) Close() error { return nil }
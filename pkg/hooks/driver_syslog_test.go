@@ -0,0 +1,197 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package hooks
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// shortTempDir returns a temporary directory short enough for unix socket paths
+func shortTempDir(t *testing.T) string {
+	dir, err := os.MkdirTemp("", "assh")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return dir
+}
+
+// listenUnixgram returns a datagram socket and a function reading its next datagram
+func listenUnixgram(t *testing.T) (string, func() []byte) {
+	path := filepath.Join(shortTempDir(t), "log")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return path, func() []byte {
+		buf := make([]byte, 65536)
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		return buf[:n]
+	}
+}
+
+func Test_formatSyslog(t *testing.T) {
+	timestamp := time.Date(2020, 6, 1, 10, 15, 3, 4000, time.UTC)
+	record := formatSyslog(38, timestamp, "my host", "assh", 4242, auditValues(testConnectArgs), "connected\n")
+	require.Equal(t,
+		`<38>1 2020-06-01T10:15:03.000004Z myhost assh 4242 - [assh@32473 host="bastion" hostname="10.0.0.1" port="22" `+
+			`user="moul" gateway="direct" sent="42" received="1337" duration="1m0s" reason="client \"closed\"\]"] connected`,
+		string(record),
+	)
+
+	record = formatSyslog(14, timestamp, "", "assh", 1, auditValues(map[string]string{"SSHConfigPath": "~/.ssh/config"}), "")
+	require.Equal(t, "<14>1 2020-06-01T10:15:03.000004Z - assh 1 - -", string(record))
+}
+
+func Test_SyslogDriver(t *testing.T) {
+	t.Run("unix", func(t *testing.T) {
+		path, read := listenUnixgram(t)
+		driver, err := NewSyslogDriver("address=unix://" + path)
+		require.NoError(t, err)
+		require.NoError(t, driver.Run(testConnectArgs))
+		record := string(read())
+		require.True(t, strings.HasPrefix(record, "<38>1 "), record)
+		require.Contains(t, record, ` assh `+strconv.Itoa(os.Getpid())+` - [assh@32473 host="bastion" `)
+		require.Contains(t, record, `] assh host="bastion" hostname="10.0.0.1"`)
+	})
+
+	t.Run("error raises the severity", func(t *testing.T) {
+		path, read := listenUnixgram(t)
+		driver, err := NewSyslogDriver("address=unix://" + path + " facility=local3 app=audit {{.Host.Name}}: {{.Error}}")
+		require.NoError(t, err)
+		args := testConnectArgs
+		args.Error = "connection refused"
+		require.NoError(t, driver.Run(args))
+		record := string(read())
+		require.True(t, strings.HasPrefix(record, "<156>1 "), record) // local3.warning
+		require.Contains(t, record, ` audit `)
+		require.Contains(t, record, ` error="connection refused"] bastion: connection refused`)
+	})
+
+	t.Run("udp", func(t *testing.T) {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer conn.Close()
+		driver, err := NewSyslogDriver("address=udp://" + conn.LocalAddr().String() + " severity=notice")
+		require.NoError(t, err)
+		require.NoError(t, driver.Run(testConnectArgs))
+		buf := make([]byte, 65536)
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(string(buf[:n]), "<37>1 "))
+	})
+
+	t.Run("tcp", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+		received := make(chan string, 1)
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			// octet counting framing: "<length> <record>"
+			reader := bufio.NewReader(conn)
+			length, _ := reader.ReadString(' ')
+			size, _ := strconv.Atoi(strings.TrimSpace(length))
+			record := make([]byte, size)
+			_, _ = reader.Read(record)
+			received <- string(record)
+		}()
+		driver, err := NewSyslogDriver("address=tcp://" + listener.Addr().String())
+		require.NoError(t, err)
+		require.NoError(t, driver.Run(testConnectArgs))
+		require.True(t, strings.HasPrefix(<-received, "<38>1 "))
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		driver, err := NewSyslogDriver("address=unix://" + filepath.Join(shortTempDir(t), "missing"))
+		require.NoError(t, err)
+		require.Error(t, driver.Run(testConnectArgs))
+	})
+}
+
+func Test_NewSyslogDriver_Errors(t *testing.T) {
+	for name, line := range map[string]string{
+		"invalid scheme":   "address=http://example.com",
+		"missing port":     "address=udp://example.com",
+		"invalid facility": "facility=unknown",
+		"invalid severity": "severity=loud",
+		"invalid template": "{{ not_a_function }}",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewSyslogDriver(line)
+			require.Error(t, err)
+		})
+	}
+}
+
+// parseJournaldEntry parses the fields of the native journald protocol
+func parseJournaldEntry(t *testing.T, entry []byte) map[string]string {
+	fields := map[string]string{}
+	reader := bufio.NewReader(bytes.NewReader(entry))
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return fields
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if parts := strings.SplitN(line, "=", 2); len(parts) == 2 {
+			fields[parts[0]] = parts[1]
+			continue
+		}
+		var size uint64
+		require.NoError(t, binary.Read(reader, binary.LittleEndian, &size))
+		value := make([]byte, size+1)
+		_, err = reader.Read(value)
+		require.NoError(t, err)
+		fields[line] = string(value[:size])
+	}
+}
+
+func Test_JournaldDriver(t *testing.T) {
+	path, read := listenUnixgram(t)
+	previousSocket := journaldSocket
+	journaldSocket = path
+	defer func() { journaldSocket = previousSocket }()
+
+	driver, err := NewJournaldDriver("")
+	require.NoError(t, err)
+	require.NoError(t, driver.Run(testConnectArgs))
+	fields := parseJournaldEntry(t, read())
+	require.Equal(t, "6", fields["PRIORITY"])
+	require.Equal(t, "assh", fields["SYSLOG_IDENTIFIER"])
+	require.Equal(t, "bastion", fields["ASSH_HOST"])
+	require.Equal(t, "10.0.0.1", fields["ASSH_HOSTNAME"])
+	require.Equal(t, "direct", fields["ASSH_GATEWAY"])
+	require.Equal(t, "1337", fields["ASSH_RECEIVED"])
+	require.True(t, strings.HasPrefix(fields["MESSAGE"], `assh host="bastion"`))
+
+	driver, err = NewJournaldDriver("priority=err identifier=audit connection to {{.Host.Name}}\nfailed: {{.Error}}")
+	require.NoError(t, err)
+	args := testConnectArgs
+	args.Error = "timeout"
+	require.NoError(t, driver.Run(args))
+	fields = parseJournaldEntry(t, read())
+	require.Equal(t, "3", fields["PRIORITY"])
+	require.Equal(t, "audit", fields["SYSLOG_IDENTIFIER"])
+	require.Equal(t, "connection to bastion\nfailed: timeout", fields["MESSAGE"])
+	require.Equal(t, "timeout", fields["ASSH_ERROR"])
+
+	_, err = NewJournaldDriver("priority=loud")
+	require.Error(t, err)
+}
@@ -0,0 +1,245 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"go.uber.org/zap"
+	"moul.io/assh/v2/pkg/templates"
+	"moul.io/assh/v2/pkg/version"
+)
+
+const (
+	defaultWebhookTimeout = 5 * time.Second
+	defaultWebhookRetries = 2
+	// webhookSignatureHeader contains the HMAC-SHA256 of the payload, when a secret is configured
+	webhookSignatureHeader = "X-Assh-Signature-256"
+)
+
+// webhookBackoff is the delay before the first retry of a delivery, doubled for each next retry
+var webhookBackoff = 500 * time.Millisecond
+
+// webhookCloseGrace is the time Close waits for the pending deliveries, they are abandoned after it
+var webhookCloseGrace = 2 * time.Second
+
+var webhookMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// WebhookDriver is a driver that sends some texts to an HTTP endpoint, in the background
+type WebhookDriver struct {
+	method  string
+	url     *template.Template
+	payload *template.Template
+	header  http.Header
+	timeout time.Duration
+	retries int
+	secret  []byte
+	client  *http.Client
+
+	deliveries sync.WaitGroup
+	// ctx is canceled when the pending deliveries are abandoned
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewWebhookDriver returns a WebhookDriver instance
+//
+// Usage: webhook [timeout=5s] [retries=2] [header="Name: value"]... [secret=xxx|secret-env=NAME] [METHOD] URL [payload]
+func NewWebhookDriver(line string) (*WebhookDriver, error) {
+	opts, rest, err := parseOptions(line, "timeout", "retries", "header", "secret", "secret-env")
+	if err != nil {
+		return nil, err
+	}
+
+	d := &WebhookDriver{
+		method:  http.MethodPost,
+		header:  http.Header{"Content-Type": {"application/json"}, "User-Agent": {"assh/" + version.Version}},
+		timeout: defaultWebhookTimeout,
+		retries: defaultWebhookRetries,
+		client:  &http.Client{},
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	if value := opts.get("timeout", ""); value != "" {
+		if d.timeout, err = time.ParseDuration(value); err != nil || d.timeout <= 0 {
+			return nil, fmt.Errorf("invalid webhook timeout %q", value)
+		}
+	}
+	if value := opts.get("retries", ""); value != "" {
+		if d.retries, err = strconv.Atoi(value); err != nil || d.retries < 0 {
+			return nil, fmt.Errorf("invalid webhook retries %q", value)
+		}
+	}
+	for _, header := range opts["header"] {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid webhook header %q, expected \"Name: value\"", header)
+		}
+		d.header.Set(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	if name := opts.get("secret-env", ""); name != "" {
+		secret := os.Getenv(name)
+		if secret == "" {
+			return nil, fmt.Errorf("the webhook secret variable %s is empty", name)
+		}
+		d.secret = []byte(secret)
+	} else if secret := opts.get("secret", ""); secret != "" {
+		d.secret = []byte(secret)
+	}
+
+	fields := strings.SplitN(rest, " ", 2)
+	if contains(webhookMethods, fields[0]) {
+		d.method = fields[0]
+		rest = ""
+		if len(fields) > 1 {
+			rest = strings.TrimLeft(fields[1], " ")
+		}
+		fields = strings.SplitN(rest, " ", 2)
+	}
+	if !strings.HasPrefix(fields[0], "http://") && !strings.HasPrefix(fields[0], "https://") {
+		return nil, fmt.Errorf("invalid webhook URL %q, expected http:// or https://", fields[0])
+	}
+	if d.url, err = templates.New(fields[0]); err != nil {
+		return nil, err
+	}
+	// the arguments are sent as JSON by default
+	payload := "{{json .}}"
+	if len(fields) > 1 && strings.TrimSpace(fields[1]) != "" {
+		payload = fields[1]
+	}
+	if d.payload, err = templates.New(payload); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Run sends the payload in the background, the errors of the delivery are only logged
+func (d *WebhookDriver) Run(args RunArgs) error {
+	endpoint, payload, err := d.render(args)
+	if err != nil {
+		return err
+	}
+
+	d.deliveries.Add(1)
+	go func() {
+		defer d.deliveries.Done()
+		if err := d.deliver(endpoint, payload); err != nil {
+			logger().Warn("webhook delivery failed", zap.String("url", redactURL(endpoint)), zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+// Describe returns the request of the webhook, the credentials of the URL are masked
+func (d *WebhookDriver) Describe(args RunArgs) (string, error) {
+	endpoint, payload, err := d.render(args)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %s %s", d.method, redactURL(endpoint), payload), nil
+}
+
+// render returns the URL and the payload of the webhook
+func (d *WebhookDriver) render(args RunArgs) (string, []byte, error) {
+	var endpoint, payload bytes.Buffer
+	if err := d.url.Execute(&endpoint, args); err != nil {
+		return "", nil, err
+	}
+	if _, err := url.ParseRequestURI(endpoint.String()); err != nil {
+		return "", nil, fmt.Errorf("invalid webhook URL: %w", err)
+	}
+	if err := d.payload.Execute(&payload, args); err != nil {
+		return "", nil, err
+	}
+	return endpoint.String(), payload.Bytes(), nil
+}
+
+// deliver sends the payload, retrying on network errors, on 5xx and on 429 responses
+func (d *WebhookDriver) deliver(endpoint string, payload []byte) error {
+	var err error
+	for attempt := 0; attempt <= d.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(webhookBackoff << uint(attempt-1)):
+			case <-d.ctx.Done():
+				return fmt.Errorf("abandoned after %d attempts: %w", attempt, err)
+			}
+		}
+		var retry bool
+		if retry, err = d.send(endpoint, payload); err == nil || !retry {
+			return err
+		}
+		logger().Debug("webhook delivery failed", zap.Int("attempt", attempt+1), zap.Error(err))
+	}
+	return err
+}
+
+// send makes a single delivery, it returns whether a failed delivery can be retried
+func (d *WebhookDriver) send(endpoint string, payload []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(d.ctx, d.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, d.method, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	req.Header = d.header.Clone()
+	if d.secret != nil {
+		req.Header.Set(webhookSignatureHeader, Signature(d.secret, payload))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_ = resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("unexpected status %q", resp.Status)
+	case resp.StatusCode >= 300:
+		return false, fmt.Errorf("unexpected status %q", resp.Status)
+	}
+	return false, nil
+}
+
+// Close waits for the pending deliveries during webhookCloseGrace, then abandons them
+func (d *WebhookDriver) Close() error {
+	done := make(chan struct{})
+	go func() {
+		d.deliveries.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(webhookCloseGrace):
+		logger().Warn("webhook deliveries abandoned", zap.Duration("after", webhookCloseGrace))
+	}
+	d.cancel()
+	return nil
+}
+
+// Signature returns the value of the X-Assh-Signature-256 header of a payload: "sha256=" followed by the
+// hexadecimal HMAC-SHA256 of the payload
+func Signature(secret []byte, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// redactURL removes the credentials, the path and the query of a URL, they may contain tokens
+func redactURL(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "invalid URL"
+	}
+	return u.Scheme + "://" + u.Host
+}
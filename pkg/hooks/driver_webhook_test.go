@@ -0,0 +1,216 @@
+package hooks
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// webhookRequest is a request received by a webhookServer
+type webhookRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   string
+}
+
+// webhookServer records the requests, and answers with the given statuses then with 204
+func webhookServer(t *testing.T, statuses ...int) (*httptest.Server, func() []webhookRequest) {
+	var mu sync.Mutex
+	requests := []webhookRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, webhookRequest{method: r.Method, path: r.URL.Path, header: r.Header, body: string(body)})
+		status := http.StatusNoContent
+		if len(requests) <= len(statuses) {
+			status = statuses[len(requests)-1]
+		}
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []webhookRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]webhookRequest{}, requests...)
+	}
+}
+
+func Test_WebhookDriver(t *testing.T) {
+	previousBackoff := webhookBackoff
+	webhookBackoff = time.Millisecond
+	defer func() { webhookBackoff = previousBackoff }()
+	t.Setenv("ASSH_TEST_WEBHOOK_SECRET", "s3cr3t")
+	args := map[string]interface{}{"Host": map[string]string{"Name": "bastion"}}
+
+	tt := map[string]struct {
+		line     string
+		statuses []int
+		method   string
+		path     string
+		body     string
+		header   map[string]string
+		requests int
+	}{
+		"default": {
+			line: "{{.URL}}/hook", method: http.MethodPost, path: "/hook", requests: 1,
+			body: `{"Host":{"Name":"bastion"}}`, header: map[string]string{"Content-Type": "application/json"},
+		},
+		"method and payload": {
+			line: "PUT {{.URL}}/hosts/{{.Host.Name}} connected to {{.Host.Name}}", method: http.MethodPut,
+			path: "/hosts/bastion", body: "connected to bastion", requests: 1,
+		},
+		"headers": {
+			line:   `header="Content-Type: text/plain" header="Authorization: Bearer token" POST {{.URL}}/ {{.Host.Name}}`,
+			method: http.MethodPost, path: "/", body: "bastion", requests: 1,
+			header: map[string]string{"Content-Type": "text/plain", "Authorization": "Bearer token"},
+		},
+		"signature": {
+			line: "secret-env=ASSH_TEST_WEBHOOK_SECRET {{.URL}}/ payload", method: http.MethodPost, path: "/",
+			body: "payload", requests: 1,
+			header: map[string]string{webhookSignatureHeader: Signature([]byte("s3cr3t"), []byte("payload"))},
+		},
+		"retried": {
+			line: "retries=2 {{.URL}}/", statuses: []int{500, 429}, method: http.MethodPost, path: "/",
+			body: `{"Host":{"Name":"bastion"}}`, requests: 3,
+		},
+		"retries exhausted": {
+			line: "retries=1 {{.URL}}/", statuses: []int{502, 502, 502}, method: http.MethodPost, path: "/",
+			body: `{"Host":{"Name":"bastion"}}`, requests: 2,
+		},
+		"client error not retried": {
+			line: "{{.URL}}/", statuses: []int{404}, method: http.MethodPost, path: "/",
+			body: `{"Host":{"Name":"bastion"}}`, requests: 1,
+		},
+	}
+	for name, test := range tt {
+		t.Run(name, func(t *testing.T) {
+			server, requests := webhookServer(t, test.statuses...)
+			driver, err := NewWebhookDriver(strings.Replace(test.line, "{{.URL}}", server.URL, 1))
+			require.NoError(t, err)
+			require.NoError(t, driver.Run(args))
+			require.NoError(t, driver.Close())
+
+			received := requests()
+			require.Len(t, received, test.requests)
+			for _, request := range received {
+				require.Equal(t, test.method, request.method)
+				require.Equal(t, test.path, request.path)
+				require.Equal(t, test.body, request.body)
+				for name, value := range test.header {
+					require.Equal(t, value, request.header.Get(name))
+				}
+			}
+		})
+	}
+}
+
+func Test_WebhookDriver_Async(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+
+	driver, err := NewWebhookDriver("timeout=10s " + server.URL)
+	require.NoError(t, err)
+	start := time.Now()
+	require.NoError(t, driver.Run(nil))
+	require.Less(t, time.Since(start), time.Second)
+
+	closed := make(chan struct{})
+	go func() {
+		_ = driver.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close returned before the end of the delivery")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-closed
+}
+
+func Test_WebhookDriver_Timeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	}))
+	defer server.Close()
+
+	driver, err := NewWebhookDriver("timeout=50ms retries=0 " + server.URL)
+	require.NoError(t, err)
+	start := time.Now()
+	require.NoError(t, driver.Run(nil))
+	require.NoError(t, driver.Close())
+	require.Less(t, time.Since(start), 900*time.Millisecond)
+}
+
+func Test_WebhookDriver_CloseGrace(t *testing.T) {
+	previous := webhookCloseGrace
+	webhookCloseGrace = 100 * time.Millisecond
+	defer func() { webhookCloseGrace = previous }()
+	canceled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the cancellation of the request is only detected once its body is read
+		_, _ = io.ReadAll(r.Body)
+		<-r.Context().Done()
+		close(canceled)
+	}))
+	defer server.Close()
+
+	driver, err := NewWebhookDriver("timeout=10s " + server.URL)
+	require.NoError(t, err)
+	require.NoError(t, driver.Run(nil))
+	start := time.Now()
+	require.NoError(t, driver.Close())
+	require.Less(t, time.Since(start), time.Second)
+
+	// the abandoned delivery is canceled
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("the delivery was not canceled")
+	}
+}
+
+func Test_NewWebhookDriver_Errors(t *testing.T) {
+	for name, line := range map[string]string{
+		"no URL":               "",
+		"method without URL":   "POST",
+		"invalid scheme":       "ftp://example.com",
+		"invalid timeout":      "timeout=never https://example.com",
+		"invalid retries":      "retries=-1 https://example.com",
+		"invalid header":       "header=invalid https://example.com",
+		"empty secret":         "secret-env=ASSH_TEST_UNSET_SECRET https://example.com",
+		"unterminated quote":   `header="Name: value https://example.com`,
+		"invalid template":     "https://example.com {{ not_a_function }}",
+		"invalid URL template": "https://{{ .Host",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewWebhookDriver(line)
+			require.Error(t, err)
+		})
+	}
+}
+
+func Test_parseOptions(t *testing.T) {
+	opts, rest, err := parseOptions(`timeout=5s header="A: b c" header=X:y unknown=1 POST https://example.com a=b`, "timeout", "header")
+	require.NoError(t, err)
+	require.Equal(t, options{"timeout": {"5s"}, "header": {"A: b c", "X:y"}}, opts)
+	require.Equal(t, "unknown=1 POST https://example.com a=b", rest)
+	require.Equal(t, "5s", opts.get("timeout", "1s"))
+	require.Equal(t, "1s", opts.get("retries", "1s"))
+
+	opts, rest, err = parseOptions("timeout=5s", "timeout")
+	require.NoError(t, err)
+	require.Equal(t, options{"timeout": {"5s"}}, opts)
+	require.Equal(t, "", rest)
+}
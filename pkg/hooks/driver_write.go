@@ -28,5 +28,10 @@ func (d WriteDriver) Run(args RunArgs) error {
 	return tmpl.Execute(os.Stderr, args)
 }
 
+// Describe returns the line written to the terminal
+func (d WriteDriver) Describe(args RunArgs) (string, error) {
+	return renderTemplate(d.line, args)
+}
+
 // Close is mandatory for the interface, here it does nothing
 func (d WriteDriver) Close() error { return nil }
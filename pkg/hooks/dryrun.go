@@ -0,0 +1,92 @@
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// describer is implemented by the drivers, Describe renders what the driver would do with args without doing it
+type describer interface {
+	Describe(args RunArgs) (string, error)
+}
+
+// DryRun is a hook rendered without being run
+type DryRun struct {
+	Hook string
+	// Output is what the hook would do: the line of write, the command of exec, the payload of webhook...
+	Output string
+	// Skipped is the reason why the hook would not run, i.e. its condition rendered false
+	Skipped string
+	Err     error
+}
+
+// DryRunAll renders the hooks of an event as InvokeAll would run them, without running them; the run-once-per
+// policies are ignored
+func (h *Hooks) DryRunAll(event string, args RunArgs) []DryRun {
+	results := []DryRun{}
+	for _, hook := range *h {
+		result := DryRun{Hook: hook.String()}
+		results = append(results, result.render(hook, event, args))
+	}
+	return results
+}
+
+func (r DryRun) render(hook Hook, event string, args RunArgs) DryRun {
+	_, driverExpr, err := hook.policy()
+	if err != nil {
+		r.Err = fmt.Errorf("invalid hook: %w", err)
+		return r
+	}
+	enabled, err := hook.enabled(args)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	if !enabled {
+		r.Skipped = "when is false"
+		return r
+	}
+	driver, err := hook.newDriver(event, driverExpr, args)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	d, ok := driver.(describer)
+	if !ok {
+		r.Err = fmt.Errorf("the %s driver does not support the dry run", strings.Split(driverExpr, " ")[0])
+		return r
+	}
+	r.Output, r.Err = d.Describe(args)
+	return r
+}
+
+// describeCommand returns the command run by the exec and daemon drivers, preceded by the environment variables of
+// the hook
+func describeCommand(line string, argv []string, env []string, args RunArgs) (string, error) {
+	cmd, err := newCommand(context.Background(), line, argv, env, args)
+	if err != nil {
+		return "", err
+	}
+	parts := []string{}
+	for _, variable := range env {
+		name, value, _ := strings.Cut(variable, "=")
+		parts = append(parts, name+"="+quoteArg(value))
+	}
+	if len(argv) > 0 {
+		for _, arg := range cmd.Args {
+			parts = append(parts, quoteArg(arg))
+		}
+	} else {
+		parts = append(parts, strings.TrimSpace(cmd.Args[len(cmd.Args)-1]))
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// quoteArg quotes the arguments which would be split by a shell
+func quoteArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n\"'\\$`;&|<>()*?[]#~") {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
@@ -0,0 +1,240 @@
+package hooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"moul.io/assh/v2/pkg/templates"
+)
+
+// hookKeys are the keys of the map form of a hook
+var hookKeys = []string{"driver", "command", "args", "timeout", "async", "onfailure", "runonceper", "env", "when"}
+
+// commandDrivers are the drivers supporting the args and env keys
+var commandDrivers = []string{"exec", "daemon"}
+
+// Hook is the definition of a hook, either an expression: "[policy options] <driver> [parameters]", or a map:
+//
+//	{driver: exec, command: ./check-vpn, timeout: 5s, onfailure: abort, env: {VPN: corp}, when: '{{ eq .Host.User "root" }}'}
+type Hook struct {
+	// Expr is the expression of a hook defined by a string
+	Expr string `yaml:"-" json:"-"`
+
+	// Driver is the name of the driver of a hook defined by a map
+	Driver string `yaml:"driver,omitempty" json:"Driver,omitempty"`
+	// Command is the parameters of the driver, as in the expressions: the command of exec and daemon, the line of
+	// write, the URL and the payload of webhook...
+	Command string `yaml:"command,omitempty" json:"Command,omitempty"`
+	// Args is the command of exec and daemon as a list of arguments, run without a shell, the arguments are templates
+	Args []string `yaml:"args,omitempty" json:"Args,omitempty"`
+	// Timeout, Async, OnFailure and RunOncePer are the policy of the hook, as the timeout, async, on-failure and
+	// run-once-per options of the expressions
+	Timeout    string `yaml:"timeout,omitempty" json:"Timeout,omitempty"`
+	Async      bool   `yaml:"async,omitempty" json:"Async,omitempty"`
+	OnFailure  string `yaml:"onfailure,omitempty" json:"OnFailure,omitempty"`
+	RunOncePer string `yaml:"runonceper,omitempty" json:"RunOncePer,omitempty"`
+	// Env are the environment variables of the commands of exec and daemon, the values are templates
+	Env map[string]string `yaml:"env,omitempty" json:"Env,omitempty"`
+	// When is a template, the hook only runs if it renders "true"
+	When string `yaml:"when,omitempty" json:"When,omitempty"`
+
+	// PluginsDir contains the executables of the plugin drivers, DefaultPluginsDir if empty; it is not a key of the
+	// hook, it is given by the configuration
+	PluginsDir string `yaml:"-" json:"-"`
+}
+
+// String returns the expression of the hook, or the driver and the command of its map
+func (h Hook) String() string {
+	if h.Expr != "" {
+		return h.Expr
+	}
+	if len(h.Args) > 0 {
+		return h.Driver + " " + strings.Join(h.Args, " ")
+	}
+	return strings.TrimSpace(h.Driver + " " + h.Command)
+}
+
+// MarshalJSON keeps the hooks defined by a string as strings
+func (h Hook) MarshalJSON() ([]byte, error) {
+	if h.Expr != "" {
+		return json.Marshal(h.Expr)
+	}
+	type hookMap Hook
+	return json.Marshal(hookMap(h))
+}
+
+// UnmarshalYAML accepts both the string and the map forms, the hooks are validated
+func (h *Hook) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var expr string
+	if err := unmarshal(&expr); err == nil {
+		*h = Hook{Expr: expr}
+		return h.Validate()
+	}
+
+	var keys map[string]interface{}
+	if err := unmarshal(&keys); err != nil {
+		return fmt.Errorf("invalid hook, expected a string or a map: %w", err)
+	}
+	for key := range keys {
+		if !contains(hookKeys, key) {
+			return fmt.Errorf("invalid hook: unknown key %q, expected one of %s", key, strings.Join(hookKeys, ", "))
+		}
+	}
+	type hookMap Hook
+	var hook hookMap
+	if err := unmarshal(&hook); err != nil {
+		return fmt.Errorf("invalid hook: %w", err)
+	}
+	*h = Hook(hook)
+	// the keys of the configuration are case-insensitive, they are lowercased when loaded
+	if len(h.Env) > 0 {
+		env := map[string]string{}
+		for name, value := range h.Env {
+			env[strings.ToUpper(name)] = value
+		}
+		h.Env = env
+	}
+	return h.Validate()
+}
+
+// Validate checks the policy of a hook, and the driver and the templates of a hook defined by a map; the expressions
+// of the drivers are only parsed when the hook is invoked, as the executables of the plugin drivers are only searched
+// then
+func (h Hook) Validate() error {
+	if h.Expr != "" {
+		if _, _, err := h.policy(); err != nil {
+			return fmt.Errorf("invalid hook: %w", err)
+		}
+		return nil
+	}
+	if h.Driver == "" {
+		return fmt.Errorf("invalid hook: missing driver")
+	}
+	if !contains(driverNames, h.Driver) && !isPluginName(h.Driver) {
+		return fmt.Errorf("invalid hook: invalid driver name %q", h.Driver)
+	}
+	if len(h.Env) > 0 && !contains(commandDrivers, h.Driver) {
+		return fmt.Errorf("invalid hook: env is not supported by the %s driver", h.Driver)
+	}
+	if len(h.Args) > 0 {
+		if !contains(commandDrivers, h.Driver) {
+			return fmt.Errorf("invalid hook: args is not supported by the %s driver", h.Driver)
+		}
+		if h.Command != "" {
+			return fmt.Errorf("invalid hook: command and args are exclusive")
+		}
+		for _, arg := range h.Args {
+			if _, err := templates.New(arg); err != nil {
+				return fmt.Errorf("invalid hook: args: %w", err)
+			}
+		}
+	}
+	if _, _, err := h.policy(); err != nil {
+		return fmt.Errorf("invalid hook: %w", err)
+	}
+	for name, value := range h.Env {
+		if _, err := templates.New(value); err != nil {
+			return fmt.Errorf("invalid hook: env %s: %w", name, err)
+		}
+	}
+	if h.When != "" {
+		if _, err := templates.New(h.When); err != nil {
+			return fmt.Errorf("invalid hook: when: %w", err)
+		}
+	}
+	return nil
+}
+
+// policy returns the policy of the hook and the expression of its driver
+func (h Hook) policy() (policy, string, error) {
+	if h.Expr != "" {
+		return parsePolicy(h.Expr)
+	}
+	opts := options{}
+	for name, value := range map[string]string{
+		"timeout":      h.Timeout,
+		"on-failure":   h.OnFailure,
+		"run-once-per": h.RunOncePer,
+		"async":        strconv.FormatBool(h.Async),
+	} {
+		if value != "" {
+			opts[name] = []string{value}
+		}
+	}
+	p, err := newPolicy(opts)
+	return p, strings.TrimSpace(h.Driver + " " + h.Command), err
+}
+
+// enabled renders the When template of the hook
+func (h Hook) enabled(args RunArgs) (bool, error) {
+	if h.When == "" {
+		return true, nil
+	}
+	value, err := renderTemplate(h.When, args)
+	if err != nil {
+		return false, fmt.Errorf("failed to render when: %w", err)
+	}
+	enabled, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return false, fmt.Errorf("when rendered %q, expected true or false", value)
+	}
+	return enabled, nil
+}
+
+// environ renders the environment variables of the hook, sorted by name
+func (h Hook) environ(args RunArgs) ([]string, error) {
+	env := []string{}
+	for name, value := range h.Env {
+		rendered, err := renderTemplate(value, args)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render env %s: %w", name, err)
+		}
+		env = append(env, name+"="+rendered)
+	}
+	sort.Strings(env)
+	return env, nil
+}
+
+// newDriver returns the driver of the hook for an event
+func (h Hook) newDriver(event string, expr string, args RunArgs) (HookDriver, error) {
+	pluginsDir := h.PluginsDir
+	if pluginsDir == "" {
+		pluginsDir = DefaultPluginsDir
+	}
+	driver, err := parseDriver(expr, pluginsDir)
+	if plugin, ok := driver.(*PluginDriver); ok && err == nil {
+		plugin.event = event
+	}
+	if err != nil || (len(h.Env) == 0 && len(h.Args) == 0) {
+		return driver, err
+	}
+	env, err := h.environ(args)
+	if err != nil {
+		return nil, err
+	}
+	switch d := driver.(type) {
+	case ExecDriver:
+		d.env, d.argv = env, h.Args
+		return d, nil
+	case *DaemonDriver:
+		d.env, d.argv = env, h.Args
+		return d, nil
+	}
+	return nil, fmt.Errorf("env and args are not supported by the %s driver", h.Driver)
+}
+
+func renderTemplate(text string, args RunArgs) (string, error) {
+	tmpl, err := templates.New(text)
+	if err != nil {
+		return "", err
+	}
+	var buff bytes.Buffer
+	if err := tmpl.Execute(&buff, args); err != nil {
+		return "", err
+	}
+	return buff.String(), nil
+}
@@ -0,0 +1,119 @@
+package hooks
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// the arguments of the tests mimic the ConnectHookArgs of the commands
+type testHost struct {
+	HostName string
+	Port     string
+	User     string
+}
+
+func (testHost) Name() string { return "bastion" }
+
+type testStats struct {
+	Gateway            string
+	SentBytes          uint64
+	ReceivedBytes      uint64
+	ConnectionDuration time.Duration
+}
+
+type testArgs struct {
+	Host   *testHost
+	Stats  *testStats
+	Error  string
+	Reason string
+}
+
+var testConnectArgs = testArgs{
+	Host:   &testHost{HostName: "10.0.0.1", Port: "22", User: "moul"},
+	Stats:  &testStats{Gateway: "direct", SentBytes: 42, ReceivedBytes: 1337, ConnectionDuration: time.Minute},
+	Reason: `client "closed"]`,
+}
+
+func Test_Hook_Validate(t *testing.T) {
+	tt := map[string]struct {
+		hook Hook
+		err  bool
+	}{
+		"expression":        {hook: Hook{Expr: "whatever"}},
+		"expression policy": {hook: Hook{Expr: "on-failure=never exec true"}, err: true},
+		"map":               {hook: Hook{Driver: "exec", Command: "true", Timeout: "1s", OnFailure: OnFailureAbort, RunOncePer: "1h"}},
+		"env":               {hook: Hook{Driver: "daemon", Command: "true", Env: map[string]string{"HOST": "{{.Host.Name}}"}}},
+		"missing driver":    {hook: Hook{Command: "true"}, err: true},
+		"plugin driver":     {hook: Hook{Driver: "vpn", Command: "corp"}},
+		"invalid driver":    {hook: Hook{Driver: "../vpn"}, err: true},
+		"unsupported env":   {hook: Hook{Driver: "write", Env: map[string]string{"A": "b"}}, err: true},
+		"invalid env":       {hook: Hook{Driver: "exec", Env: map[string]string{"A": "{{ .Host"}}, err: true},
+		"invalid when":      {hook: Hook{Driver: "exec", When: "{{ not_a_function }}"}, err: true},
+		"invalid timeout":   {hook: Hook{Driver: "exec", Timeout: "soon"}, err: true},
+		"invalid policy":    {hook: Hook{Driver: "exec", Async: true, OnFailure: OnFailureAbort}, err: true},
+		"invalid onfailure": {hook: Hook{Driver: "exec", OnFailure: "retry"}, err: true},
+		"args":              {hook: Hook{Driver: "exec", Args: []string{"logger", "{{.Host.Name}}"}}},
+		"unsupported args":  {hook: Hook{Driver: "write", Args: []string{"hello"}}, err: true},
+		"command and args":  {hook: Hook{Driver: "exec", Command: "true", Args: []string{"true"}}, err: true},
+		"invalid args":      {hook: Hook{Driver: "exec", Args: []string{"{{ .Host"}}, err: true},
+	}
+	for name, test := range tt {
+		t.Run(name, func(t *testing.T) {
+			err := test.hook.Validate()
+			if test.err {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_Hook_MarshalJSON(t *testing.T) {
+	out, err := json.Marshal(Hooks{{Expr: "write hello"}, {Driver: "write", Command: "hello", Timeout: "1s"}})
+	require.NoError(t, err)
+	require.Equal(t, `["write hello",{"Driver":"write","Command":"hello","Timeout":"1s"}]`, string(out))
+}
+
+func Test_Hooks_InvokeAll_Map(t *testing.T) {
+	path := filepath.ToSlash(filepath.Join(t.TempDir(), "hooks.log"))
+	root := map[string]interface{}{"Host": map[string]string{"Name": "bastion", "User": "root"}}
+	user := map[string]interface{}{"Host": map[string]string{"Name": "bastion", "User": "moul"}}
+
+	hooks := Hooks{
+		{Driver: "file", Command: path + " {{.Host.User}} always"},
+		{Driver: "file", Command: path + " {{.Host.User}} only root", When: `{{ eq .Host.User "root" }}`},
+	}
+	for _, args := range []RunArgs{root, user} {
+		drivers, err := hooks.InvokeAll("OnConnect", args)
+		require.NoError(t, err)
+		require.Empty(t, drivers.Close())
+	}
+	require.Equal(t, []string{"root always", "root only root", "moul always"}, readLines(t, path))
+
+	// a condition which is not a boolean is a failure of the hook
+	hooks = Hooks{{Driver: "file", Command: path + " never", When: "{{.Host.Name}}", OnFailure: OnFailureAbort}}
+	_, err := hooks.InvokeAll("OnConnect", root)
+	require.Error(t, err)
+}
+
+func Test_Hooks_InvokeAll_Env(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the exec driver needs a posix shell")
+	}
+	path := filepath.Join(t.TempDir(), "env")
+	hooks := Hooks{{
+		Driver:    "exec",
+		Command:   `echo "$TARGET $OTHER" > ` + path,
+		Env:       map[string]string{"TARGET": "{{.Host.Name}}", "OTHER": "static"},
+		OnFailure: OnFailureAbort,
+	}}
+	_, err := hooks.InvokeAll("OnConnect", map[string]interface{}{"Host": map[string]string{"Name": "bastion"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"bastion static"}, readLines(t, path))
+}
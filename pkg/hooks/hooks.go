@@ -4,11 +4,35 @@ import (
 	"fmt"
 	"strings"
 
-	composeyaml "github.com/docker/libcompose/yaml"
+	"go.uber.org/zap"
 )
 
 // Hooks represents a slice of Hook
-type Hooks composeyaml.Stringorslice
+type Hooks []Hook
+
+// UnmarshalYAML accepts a single hook or a list of hooks
+func (h *Hooks) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []Hook
+	err := unmarshal(&list)
+	if err == nil {
+		*h = list
+		return nil
+	}
+	var items []interface{}
+	if unmarshal(&items) == nil {
+		// a list with an invalid hook
+		return err
+	}
+	var hook Hook
+	if err := unmarshal(&hook); err != nil {
+		return err
+	}
+	*h = Hooks{hook}
+	return nil
+}
+
+// driverNames are the names of the drivers of assh, the other names are the plugin drivers
+var driverNames = []string{"write", "file", "notify", "exec", "daemon", "syslog", "journald", "webhook"}
 
 // HookDriver represents a hook driver
 type HookDriver interface {
@@ -22,22 +46,58 @@ type HookDrivers []HookDriver
 // RunArgs is a map of interface{}
 type RunArgs interface{}
 
-// InvokeAll calls all hooks
-func (h *Hooks) InvokeAll(args RunArgs) (HookDrivers, error) {
+// InvokeAll calls all hooks of an event, in order, according to their policies: the failures of the hooks are logged,
+// unless the policy of a hook is to abort or a plugin vetoes the event, then the remaining hooks are skipped and the
+// error is returned
+func (h *Hooks) InvokeAll(event string, args RunArgs) (HookDrivers, error) {
 	drivers := HookDrivers{}
 
-	for _, expr := range *h {
-		driver, err := New(expr)
+	for _, hook := range *h {
+		p, driverExpr, err := hook.policy()
+		name := hook.String()
 		if err != nil {
-			return nil, err
+			// the policies are validated with the configuration, an invalid one is reported as the default policy
+			_ = policy{onFailure: OnFailureWarn}.report(name, fmt.Errorf("invalid policy: %w", err))
+			continue
+		}
+		enabled, err := hook.enabled(args)
+		if err != nil {
+			if err := p.report(name, err); err != nil {
+				drivers.Close()
+				return nil, err
+			}
+			continue
+		}
+		if !enabled {
+			logger().Debug("hook skipped by its condition", zap.String("hook", name))
+			continue
+		}
+		if p.skipped(name, args) {
+			logger().Debug("hook skipped, it already ran recently", zap.String("hook", name))
+			continue
 		}
-		drivers = append(drivers, driver)
-	}
 
-	for _, driver := range drivers {
-		if err := driver.Run(args); err != nil {
-			return nil, err
+		driver, err := hook.newDriver(event, driverExpr, args)
+		if err != nil {
+			if err := p.report(name, err); err != nil {
+				drivers.Close()
+				return nil, err
+			}
+			continue
+		}
+		if p.async {
+			drivers = append(drivers, p.runAsync(name, driver, args))
+			continue
 		}
+		drivers = append(drivers, driver)
+		if err := p.run(driver, args); err != nil {
+			if err := p.report(name, err); err != nil {
+				drivers.Close()
+				return nil, err
+			}
+			continue
+		}
+		p.succeeded(name, args)
 	}
 	return drivers, nil
 }
@@ -53,14 +113,22 @@ func (hd *HookDrivers) Close() []error {
 	return errs
 }
 
-// New returns an HookDriver instance
+// New returns an HookDriver instance, the plugin drivers are searched in DefaultPluginsDir
 func New(expr string) (HookDriver, error) {
+	return parseDriver(expr, DefaultPluginsDir)
+}
+
+// parseDriver returns the HookDriver of an expression, the plugin drivers are searched in pluginsDir
+func parseDriver(expr string, pluginsDir string) (HookDriver, error) {
 	driverName := strings.Split(expr, " ")[0]
 	param := strings.Join(strings.Split(expr, " ")[1:], " ")
 	switch driverName {
 	case "write":
 		driver, err := NewWriteDriver(param)
 		return driver, err
+	case "file":
+		driver, err := NewFileDriver(param)
+		return driver, err
 	case "notify":
 		driver, err := NewNotificationDriver(param)
 		return driver, err
@@ -69,8 +137,31 @@ func New(expr string) (HookDriver, error) {
 		return driver, err
 	case "daemon":
 		driver, err := NewDaemonDriver(param)
+		if err != nil {
+			return nil, err
+		}
+		return driver, nil
+	case "syslog":
+		driver, err := NewSyslogDriver(param)
 		return driver, err
+	case "journald":
+		driver, err := NewJournaldDriver(param)
+		return driver, err
+	case "webhook":
+		driver, err := NewWebhookDriver(param)
+		if err != nil {
+			return nil, err
+		}
+		return driver, nil
 	default:
-		return nil, fmt.Errorf("no such driver %q", driverName)
+		// the other names are the plugin drivers, run as external executables
+		if !isPluginName(driverName) {
+			return nil, fmt.Errorf("no such driver %q", driverName)
+		}
+		driver, err := NewPluginDriver(pluginsDir, driverName, param)
+		if err != nil {
+			return nil, err
+		}
+		return driver, nil
 	}
 }
@@ -0,0 +1,99 @@
+package hooks
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// options are the "name=value" options given at the beginning of a hook expression
+type options map[string][]string
+
+// get returns the last value of the option name, or fallback if it is not set
+func (o options) get(name string, fallback string) string {
+	if values := o[name]; len(values) > 0 {
+		return values[len(values)-1]
+	}
+	return fallback
+}
+
+// parseOptions splits the leading options of line from the rest of the line; only the names listed in allowed are
+// options, the values can be double-quoted to contain spaces, i.e: header="Authorization: Bearer xxx"
+func parseOptions(line string, allowed ...string) (options, string, error) {
+	opts := options{}
+	rest := strings.TrimLeft(line, " ")
+	for rest != "" {
+		equal := strings.IndexByte(rest, '=')
+		space := strings.IndexByte(rest, ' ')
+		if equal <= 0 || (space >= 0 && space < equal) || !contains(allowed, rest[:equal]) {
+			break
+		}
+		name := rest[:equal]
+		value := rest[equal+1:]
+		if strings.HasPrefix(value, `"`) {
+			end := closingQuote(value)
+			if end < 0 {
+				return nil, "", fmt.Errorf("unterminated quoted value for option %q", name)
+			}
+			unquoted, err := strconv.Unquote(value[:end+1])
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid quoted value for option %q: %w", name, err)
+			}
+			opts[name] = append(opts[name], unquoted)
+			rest = value[end+1:]
+		} else {
+			if end := strings.IndexByte(value, ' '); end >= 0 {
+				opts[name] = append(opts[name], value[:end])
+				rest = value[end:]
+			} else {
+				opts[name] = append(opts[name], value)
+				rest = ""
+			}
+		}
+		rest = strings.TrimLeft(rest, " ")
+	}
+	return opts, rest, nil
+}
+
+// parseArgument splits the first argument of line from the rest of the line; like the option values, it can be
+// double-quoted to contain spaces, i.e: "~/my logs/audit.log"
+func parseArgument(line string) (string, string, error) {
+	line = strings.TrimLeft(line, " ")
+	if !strings.HasPrefix(line, `"`) {
+		if end := strings.IndexByte(line, ' '); end >= 0 {
+			return line[:end], line[end+1:], nil
+		}
+		return line, "", nil
+	}
+	end := closingQuote(line)
+	if end < 0 {
+		return "", "", fmt.Errorf("unterminated quoted argument %s", line)
+	}
+	arg, err := strconv.Unquote(line[:end+1])
+	if err != nil {
+		return "", "", fmt.Errorf("invalid quoted argument %s: %w", line[:end+1], err)
+	}
+	return arg, strings.TrimPrefix(line[end+1:], " "), nil
+}
+
+// closingQuote returns the index of the quote closing the quoted string starting value, -1 if there is none
+func closingQuote(value string) int {
+	for i := 1; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,214 @@
+package hooks
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// the failure modes of a hook
+const (
+	// OnFailureAbort stops the remaining hooks of the event, a BeforeConnect hook vetoes the connection
+	OnFailureAbort = "abort"
+	// OnFailureWarn logs the failure and runs the next hooks, it is the default
+	OnFailureWarn = "warn"
+	// OnFailureIgnore only logs the failure in debug mode
+	OnFailureIgnore = "ignore"
+)
+
+// StampsDir contains the time of the last success of the hooks limited by run-once-per
+var StampsDir = "~/.ssh/assh_hooks"
+
+// policy is how a hook is run, it is given by the options preceding the name of the driver, i.e:
+// "timeout=5s on-failure=abort exec ./check-vpn", or by the keys of the map form of the hook
+type policy struct {
+	// timeout interrupts the hook, 0 disables the timeout
+	timeout time.Duration
+	// async runs the hook in the background, the hook is awaited when the drivers are closed
+	async bool
+	// onFailure is one of OnFailureAbort, OnFailureWarn and OnFailureIgnore
+	onFailure string
+	// runOncePer skips the hook if it succeeded for the same host less than runOncePer ago
+	runOncePer time.Duration
+}
+
+// parsePolicy splits the policy of a hook from the driver expression; async is a flag, the other options are
+// "name=value" options
+func parsePolicy(expr string) (policy, string, error) {
+	opts := options{}
+	rest := expr
+	for {
+		parsed, remaining, err := parseOptions(rest, "timeout", "async", "on-failure", "run-once-per")
+		if err != nil {
+			return policy{}, "", err
+		}
+		for name, values := range parsed {
+			opts[name] = append(opts[name], values...)
+		}
+		rest = remaining
+		if rest != "async" && !strings.HasPrefix(rest, "async ") {
+			break
+		}
+		opts["async"] = append(opts["async"], "true")
+		rest = strings.TrimPrefix(rest, "async")
+	}
+
+	p, err := newPolicy(opts)
+	return p, strings.TrimLeft(rest, " "), err
+}
+
+// newPolicy returns the policy defined by the options
+func newPolicy(opts options) (policy, error) {
+	p := policy{}
+	var err error
+	if value := opts.get("timeout", ""); value != "" {
+		if p.timeout, err = time.ParseDuration(value); err != nil || p.timeout < 0 {
+			return policy{}, fmt.Errorf("invalid hook timeout %q", value)
+		}
+	}
+	switch value := opts.get("async", "false"); value {
+	case "true":
+		p.async = true
+	case "false":
+	default:
+		return policy{}, fmt.Errorf("invalid hook async %q, expected true or false", value)
+	}
+	switch value := opts.get("on-failure", OnFailureWarn); value {
+	case OnFailureAbort, OnFailureWarn, OnFailureIgnore:
+		p.onFailure = value
+	default:
+		return policy{}, fmt.Errorf("invalid hook on-failure %q, expected abort, warn or ignore", value)
+	}
+	if value := opts.get("run-once-per", ""); value != "" {
+		if p.runOncePer, err = time.ParseDuration(value); err != nil || p.runOncePer < 0 {
+			return policy{}, fmt.Errorf("invalid hook run-once-per %q", value)
+		}
+	}
+	if p.async && p.onFailure == OnFailureAbort {
+		return policy{}, fmt.Errorf("an async hook cannot abort")
+	}
+	return p, nil
+}
+
+// contextDriver is implemented by the drivers that can be interrupted on timeout, the other drivers are abandoned
+type contextDriver interface {
+	RunContext(ctx context.Context, args RunArgs) error
+}
+
+// run runs the driver within the timeout of the policy
+func (p policy) run(driver HookDriver, args RunArgs) error {
+	if p.timeout <= 0 {
+		return driver.Run(args)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		if driver, ok := driver.(contextDriver); ok {
+			done <- driver.RunContext(ctx, args)
+			return
+		}
+		done <- driver.Run(args)
+	}()
+	select {
+	case err := <-done:
+		if err != nil && ctx.Err() != nil {
+			return fmt.Errorf("timed out after %s", p.timeout)
+		}
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out after %s", p.timeout)
+	}
+}
+
+// report logs the failure of a hook according to the policy, it returns the error if the hook aborts or if a plugin
+// vetoed the event
+func (p policy) report(expr string, err error) error {
+	if isVeto(err) {
+		return fmt.Errorf("hook %q vetoed the event: %w", expr, err)
+	}
+	switch p.onFailure {
+	case OnFailureAbort:
+		return fmt.Errorf("hook %q failed: %w", expr, err)
+	case OnFailureIgnore:
+		logger().Debug("hook failed", zap.String("hook", expr), zap.Error(err))
+	default:
+		logger().Warn("hook failed", zap.String("hook", expr), zap.Error(err))
+	}
+	return nil
+}
+
+// stamp returns the file recording the last success of the hook for the host of args
+func (p policy) stamp(expr string, args RunArgs) string {
+	key := expr
+	for _, value := range auditValues(args) {
+		if value.name == "host" {
+			key += "\x00" + value.value
+		}
+	}
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(expandPath(StampsDir), hex.EncodeToString(sum[:16]))
+}
+
+// skipped returns whether the hook succeeded less than runOncePer ago
+func (p policy) skipped(expr string, args RunArgs) bool {
+	if p.runOncePer <= 0 {
+		return false
+	}
+	info, err := os.Stat(p.stamp(expr, args))
+	return err == nil && time.Since(info.ModTime()) < p.runOncePer
+}
+
+// succeeded records the success of a hook limited by runOncePer
+func (p policy) succeeded(expr string, args RunArgs) {
+	if p.runOncePer <= 0 {
+		return
+	}
+	path := p.stamp(expr, args)
+	err := os.MkdirAll(filepath.Dir(path), 0o700)
+	if err == nil {
+		err = os.WriteFile(path, []byte(expr+"\n"), 0o600)
+	}
+	if err == nil {
+		now := time.Now()
+		err = os.Chtimes(path, now, now)
+	}
+	if err != nil {
+		logger().Warn("failed to record the success of the hook", zap.String("hook", expr), zap.Error(err))
+	}
+}
+
+// asyncDriver is a driver running in the background, it is awaited when closed
+type asyncDriver struct {
+	HookDriver
+	done chan struct{}
+}
+
+// Close waits for the end of the run, then closes the driver
+func (d asyncDriver) Close() error {
+	<-d.done
+	return d.HookDriver.Close()
+}
+
+// runAsync runs the driver in the background, the failures are only logged
+func (p policy) runAsync(expr string, driver HookDriver, args RunArgs) HookDriver {
+	async := asyncDriver{HookDriver: driver, done: make(chan struct{})}
+	go func() {
+		defer close(async.done)
+		if err := p.run(driver, args); err != nil {
+			if err := p.report(expr, err); err != nil {
+				logger().Warn("an async hook cannot veto", zap.String("hook", expr), zap.Error(err))
+			}
+			return
+		}
+		p.succeeded(expr, args)
+	}()
+	return async
+}
@@ -0,0 +1,191 @@
+package hooks
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parsePolicy(t *testing.T) {
+	tt := map[string]struct {
+		expr   string
+		policy policy
+		rest   string
+	}{
+		"default": {
+			expr:   "exec echo timeout=1s",
+			policy: policy{onFailure: OnFailureWarn},
+			rest:   "exec echo timeout=1s",
+		},
+		"all options": {
+			expr:   "timeout=5s on-failure=ignore async run-once-per=1h webhook timeout=1s https://example.com",
+			policy: policy{timeout: 5 * time.Second, async: true, onFailure: OnFailureIgnore, runOncePer: time.Hour},
+			rest:   "webhook timeout=1s https://example.com",
+		},
+		"abort": {
+			expr:   "on-failure=abort exec ./check-vpn",
+			policy: policy{onFailure: OnFailureAbort},
+			rest:   "exec ./check-vpn",
+		},
+		"async option": {
+			expr:   "async=false exec true",
+			policy: policy{onFailure: OnFailureWarn},
+			rest:   "exec true",
+		},
+	}
+	for name, test := range tt {
+		t.Run(name, func(t *testing.T) {
+			p, rest, err := parsePolicy(test.expr)
+			require.NoError(t, err)
+			require.Equal(t, test.policy, p)
+			require.Equal(t, test.rest, rest)
+		})
+	}
+
+	for name, expr := range map[string]string{
+		"invalid timeout":      "timeout=soon exec true",
+		"invalid on-failure":   "on-failure=retry exec true",
+		"invalid run-once-per": "run-once-per=-1h exec true",
+		"invalid async":        "async=maybe exec true",
+		"async abort":          "async on-failure=abort exec true",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := parsePolicy(expr)
+			require.Error(t, err)
+		})
+	}
+}
+
+// exprs returns the hooks defined by the expressions
+func exprs(expressions ...string) Hooks {
+	hooks := Hooks{}
+	for _, expr := range expressions {
+		hooks = append(hooks, Hook{Expr: expr})
+	}
+	return hooks
+}
+
+// readLines returns the lines of the file at path, nil if it does not exist
+func readLines(t *testing.T, path string) []string {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	require.NoError(t, err)
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+func Test_Hooks_InvokeAll_OnFailure(t *testing.T) {
+	path := filepath.ToSlash(filepath.Join(t.TempDir(), "hooks.log"))
+	args := map[string]interface{}{"Host": map[string]string{"Name": "bastion"}}
+
+	tt := map[string]struct {
+		hooks Hooks
+		err   bool
+		lines []string
+	}{
+		"warn by default": {
+			hooks: exprs(`file `+path+` {{fail "vpn down"}}`, "file "+path+" next"),
+			lines: []string{"next"},
+		},
+		"ignore": {
+			hooks: exprs(`on-failure=ignore file `+path+` {{fail "vpn down"}}`, "file "+path+" next"),
+			lines: []string{"next"},
+		},
+		"abort": {
+			hooks: exprs("file "+path+" first", `on-failure=abort file `+path+` {{fail "vpn down"}}`, "file "+path+" next"),
+			err:   true,
+			lines: []string{"first"},
+		},
+		"unknown driver": {
+			hooks: exprs("on-failure=abort unknown driver"),
+			err:   true,
+		},
+		"unknown driver warns": {
+			hooks: exprs("unknown driver", "file "+path+" next"),
+			lines: []string{"next"},
+		},
+		"invalid policy warns": {
+			hooks: exprs("on-failure=never file "+path+" first", "file "+path+" next"),
+			lines: []string{"next"},
+		},
+	}
+	for name, test := range tt {
+		t.Run(name, func(t *testing.T) {
+			_ = os.Remove(path)
+			drivers, err := test.hooks.InvokeAll("OnConnect", args)
+			if test.err {
+				require.Error(t, err)
+				require.Nil(t, drivers)
+			} else {
+				require.NoError(t, err)
+				require.Empty(t, drivers.Close())
+			}
+			require.Equal(t, test.lines, readLines(t, path))
+		})
+	}
+}
+
+func Test_Hooks_InvokeAll_RunOncePer(t *testing.T) {
+	previousStampsDir := StampsDir
+	StampsDir = t.TempDir()
+	defer func() { StampsDir = previousStampsDir }()
+
+	path := filepath.ToSlash(filepath.Join(t.TempDir(), "hooks.log"))
+	hooks := exprs("run-once-per=1h file " + path + " {{.Host.Name}}")
+	for _, host := range []string{"bastion", "bastion", "other", "bastion"} {
+		_, err := hooks.InvokeAll("OnConnect", map[string]interface{}{"Host": map[string]string{"Name": host}})
+		require.NoError(t, err)
+	}
+	require.Equal(t, []string{"bastion", "other"}, readLines(t, path))
+
+	// the failures are not recorded
+	failing := exprs(`run-once-per=1h file ` + path + ` {{fail "vpn down"}}`)
+	for i := 0; i < 2; i++ {
+		_, err := failing.InvokeAll("OnConnect", nil)
+		require.NoError(t, err)
+	}
+	entries, err := os.ReadDir(StampsDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+}
+
+func Test_Hooks_InvokeAll_Timeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the exec driver needs a posix shell")
+	}
+	start := time.Now()
+	// the shell is replaced by sleep, to not leave an orphan holding the output of the tests
+	hooks := exprs("timeout=100ms on-failure=abort exec exec sleep 5")
+	_, err := hooks.InvokeAll("OnConnect", nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "timed out after 100ms")
+	require.Less(t, time.Since(start), 2*time.Second)
+
+	hooks = exprs("timeout=5s exec true")
+	drivers, err := hooks.InvokeAll("OnConnect", nil)
+	require.NoError(t, err)
+	require.Len(t, drivers, 1)
+}
+
+func Test_Hooks_InvokeAll_Async(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the exec driver needs a posix shell")
+	}
+	path := filepath.Join(t.TempDir(), "done")
+	hooks := exprs("async exec sleep 0.2 && touch " + path)
+
+	start := time.Now()
+	drivers, err := hooks.InvokeAll("OnConnect", nil)
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), 150*time.Millisecond)
+	require.NoFileExists(t, path)
+
+	require.Empty(t, drivers.Close())
+	require.FileExists(t, path)
+}
@@ -0,0 +1 @@
+package logfile // import "moul.io/assh/v2/pkg/logfile"
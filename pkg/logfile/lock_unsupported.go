@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package logfile
+
+import "os"
+
+// lockFile is a no-op, the rotation is not protected against concurrent processes on this platform
+func lockFile(_ *os.File) error { return nil }
+
+func unlockFile(_ *os.File) error { return nil }
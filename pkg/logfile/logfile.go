@@ -0,0 +1,222 @@
+package logfile
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// File is an append-only file rotated by size or by age: once full or too old, "path" is renamed to "path.1",
+// "path.1" to "path.2" and so on, the oldest files being removed.
+// Multiple processes can append to the same file, the rotation is protected by a lock file.
+type File struct {
+	Path string
+	// MaxSize is the size in bytes triggering a rotation, 0 disables the rotation by size
+	MaxSize int64
+	// MaxAge is the age of the file triggering a rotation, 0 disables the rotation by age.
+	// The creation of the current file is recorded as the modification time of the lock file.
+	MaxAge time.Duration
+	// MaxBackups is the number of rotated files kept
+	MaxBackups int
+	// Compress gzips the rotated files, named "path.1.gz", "path.2.gz" and so on
+	Compress bool
+	// Mode is the permission of the created files, 0600 by default
+	Mode os.FileMode
+}
+
+func (f *File) mode() os.FileMode {
+	if f.Mode == 0 {
+		return 0o600
+	}
+	return f.Mode
+}
+
+// Append writes data at the end of the file, rotating it first if needed.
+// data is written using a single write, so lines appended by concurrent processes are not interleaved.
+func (f *File) Append(data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0o700); err != nil {
+		return err
+	}
+
+	lockPath := f.Path + ".lock"
+	unlock, err := Lock(lockPath, f.mode())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	info, err := os.Stat(f.Path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		f.created(lockPath)
+	case err != nil:
+		return err
+	case f.full(info, len(data)) || f.expired(lockPath):
+		if err := f.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate %q: %w", f.Path, err)
+		}
+		f.created(lockPath)
+	}
+
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, f.mode())
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Lock waits for an exclusive lock on the file at path, created if needed with mode.
+// The lock is released by calling the returned function, it is a no-op on the platforms without flock.
+func Lock(path string, mode os.FileMode) (func(), error) {
+	lock, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, mode)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(lock); err != nil {
+		_ = lock.Close()
+		return nil, fmt.Errorf("failed to lock %q: %w", path, err)
+	}
+	return func() {
+		if err := unlockFile(lock); err != nil {
+			logger().Warn("failed to unlock file", zap.String("path", path), zap.Error(err))
+		}
+		_ = lock.Close()
+	}, nil
+}
+
+// full returns whether appending size bytes to the current file exceeds MaxSize
+func (f *File) full(info os.FileInfo, size int) bool {
+	return f.MaxSize > 0 && info.Size() > 0 && info.Size()+int64(size) > f.MaxSize
+}
+
+// expired returns whether the current file was created more than MaxAge ago
+func (f *File) expired(lockPath string) bool {
+	if f.MaxAge <= 0 {
+		return false
+	}
+	info, err := os.Stat(lockPath)
+	return err == nil && time.Since(info.ModTime()) > f.MaxAge
+}
+
+// created records the creation of the current file, for the rotation by age
+func (f *File) created(lockPath string) {
+	if f.MaxAge <= 0 {
+		return
+	}
+	now := time.Now()
+	if err := os.Chtimes(lockPath, now, now); err != nil {
+		logger().Warn("failed to record the creation of the file", zap.String("path", f.Path), zap.Error(err))
+	}
+}
+
+// backup returns the path of the nth rotated file, without the ".gz" suffix of the compressed files
+func (f *File) backup(n int) string {
+	return fmt.Sprintf("%s.%d", f.Path, n)
+}
+
+func (f *File) rotate() error {
+	if f.MaxBackups < 1 {
+		return os.Remove(f.Path)
+	}
+	// both the plain and the compressed files are shifted, Compress may have been changed since the last rotation
+	for _, suffix := range []string{"", ".gz"} {
+		if err := os.Remove(f.backup(f.MaxBackups) + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		for n := f.MaxBackups - 1; n > 0; n-- {
+			if err := os.Rename(f.backup(n)+suffix, f.backup(n+1)+suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	logger().Debug("rotating file", zap.String("path", f.Path))
+	if err := os.Rename(f.Path, f.backup(1)); err != nil {
+		return err
+	}
+	if f.Compress {
+		return f.compress(f.backup(1))
+	}
+	return nil
+}
+
+// compress replaces the file at path by its gzipped version, "path.gz"
+func (f *File) compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.mode())
+	if err != nil {
+		return err
+	}
+	writer := gzip.NewWriter(dst)
+	if _, err := io.Copy(writer, src); err != nil {
+		_ = dst.Close()
+		_ = os.Remove(path + ".gz")
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		_ = dst.Close()
+		_ = os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		_ = os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Files returns the existing files, from the oldest rotated file to the current one, the compressed files can be
+// read with Open
+func (f *File) Files() []string {
+	files := []string{}
+	for n := f.MaxBackups; n > 0; n-- {
+		for _, path := range []string{f.backup(n), f.backup(n) + ".gz"} {
+			if _, err := os.Stat(path); err == nil {
+				files = append(files, path)
+			}
+		}
+	}
+	if _, err := os.Stat(f.Path); err == nil {
+		files = append(files, f.Path)
+	}
+	return files
+}
+
+// Open opens one of the files returned by Files, decompressing the gzipped files
+func Open(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil || !strings.HasSuffix(path, ".gz") {
+		return file, err
+	}
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to decompress %q: %w", path, err)
+	}
+	return gzipFile{Reader: reader, file: file}, nil
+}
+
+// gzipFile closes both the decompressor and the underlying file
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (f gzipFile) Close() error {
+	_ = f.Reader.Close()
+	return f.file.Close()
+}
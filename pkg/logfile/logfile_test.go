@@ -0,0 +1,138 @@
+package logfile
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_File_Append(t *testing.T) {
+	dir := t.TempDir()
+	file := File{Path: filepath.Join(dir, "sub", "test.log"), MaxSize: 10, MaxBackups: 2}
+
+	require.Empty(t, file.Files())
+	for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeee\n", "ffff\n", "gggg\n"} {
+		require.NoError(t, file.Append([]byte(line)))
+	}
+
+	read := func(path string) string {
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return string(content)
+	}
+	require.Equal(t, "gggg\n", read(file.Path))
+	require.Equal(t, "eeee\nffff\n", read(file.Path+".1"))
+	require.Equal(t, "cccc\ndddd\n", read(file.Path+".2"))
+	require.NoFileExists(t, file.Path+".3")
+	require.Equal(t, []string{file.Path + ".2", file.Path + ".1", file.Path}, file.Files())
+
+	if os.PathSeparator == '/' {
+		info, err := os.Stat(file.Path)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	}
+}
+
+func Test_File_NoRotation(t *testing.T) {
+	file := File{Path: filepath.Join(t.TempDir(), "test.log")}
+	for i := 0; i < 100; i++ {
+		require.NoError(t, file.Append([]byte("line\n")))
+	}
+	require.Equal(t, []string{file.Path}, file.Files())
+
+	// no backups: the file is truncated when full
+	file.MaxSize = 10
+	require.NoError(t, file.Append([]byte("new\n")))
+	content, err := os.ReadFile(file.Path)
+	require.NoError(t, err)
+	require.Equal(t, "new\n", string(content))
+}
+
+func Test_File_Concurrent(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the rotation is not protected by a lock on windows")
+	}
+	file := File{Path: filepath.Join(t.TempDir(), "test.log"), MaxSize: 1024, MaxBackups: 100}
+	line := strings.Repeat("x", 99) + "\n"
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				require.NoError(t, file.Append([]byte(line)))
+			}
+		}()
+	}
+	wg.Wait()
+
+	total := 0
+	for _, path := range file.Files() {
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(content), 1024)
+		for _, got := range strings.SplitAfter(string(content), "\n") {
+			if got != "" {
+				require.Equal(t, line, got)
+				total++
+			}
+		}
+	}
+	require.Equal(t, 200, total)
+}
+
+func Test_File_MaxAge(t *testing.T) {
+	file := File{Path: filepath.Join(t.TempDir(), "test.log"), MaxAge: time.Hour, MaxBackups: 2}
+	require.NoError(t, file.Append([]byte("old\n")))
+	require.NoError(t, file.Append([]byte("recent\n")))
+	require.Equal(t, []string{file.Path}, file.Files())
+
+	// the creation of the current file is recorded by the lock file
+	past := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(file.Path+".lock", past, past))
+	require.NoError(t, file.Append([]byte("new\n")))
+	require.Equal(t, []string{file.Path + ".1", file.Path}, file.Files())
+	content, err := os.ReadFile(file.Path)
+	require.NoError(t, err)
+	require.Equal(t, "new\n", string(content))
+
+	// the new file is not expired
+	require.NoError(t, file.Append([]byte("next\n")))
+	require.Equal(t, []string{file.Path + ".1", file.Path}, file.Files())
+}
+
+func Test_File_Compress(t *testing.T) {
+	file := File{Path: filepath.Join(t.TempDir(), "test.log"), MaxSize: 10, MaxBackups: 2, Compress: true}
+	for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeee\n", "ffff\n", "gggg\n"} {
+		require.NoError(t, file.Append([]byte(line)))
+	}
+	require.Equal(t, []string{file.Path + ".2.gz", file.Path + ".1.gz", file.Path}, file.Files())
+	require.NoFileExists(t, file.Path+".1")
+
+	read := func(path string) string {
+		reader, err := Open(path)
+		require.NoError(t, err)
+		defer reader.Close()
+		content, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		return string(content)
+	}
+	require.Equal(t, "gggg\n", read(file.Path))
+	require.Equal(t, "eeee\nffff\n", read(file.Path+".1.gz"))
+	require.Equal(t, "cccc\ndddd\n", read(file.Path+".2.gz"))
+
+	// the compressed files are kept in the rotation when the compression is disabled
+	file.Compress = false
+	require.NoError(t, file.Append([]byte("hhhh\n")))
+	require.NoError(t, file.Append([]byte("iiii\n")))
+	require.Equal(t, []string{file.Path + ".2.gz", file.Path + ".1", file.Path}, file.Files())
+	require.Equal(t, "gggg\nhhhh\n", read(file.Path+".1"))
+}
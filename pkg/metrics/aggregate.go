@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HostStats aggregates the records of a host
+type HostStats struct {
+	Host                   string
+	Sessions               int
+	Failures               int
+	SentBytes              uint64
+	ReceivedBytes          uint64
+	TotalDuration          time.Duration
+	AverageDuration        time.Duration
+	MaxDuration            time.Duration
+	AverageTimeToFirstByte time.Duration
+	LastConnectedAt        time.Time
+}
+
+// Aggregate computes the statistics of each host, sorted by host name.
+// Sessions only include the established connections, failed ones are counted as Failures.
+func Aggregate(records []Record) []HostStats {
+	byHost := map[string]*HostStats{}
+	firstBytes := map[string]int{}
+	for _, record := range records {
+		stats, found := byHost[record.Host]
+		if !found {
+			stats = &HostStats{Host: record.Host}
+			byHost[record.Host] = stats
+		}
+		if record.Failed() {
+			stats.Failures++
+			continue
+		}
+
+		stats.Sessions++
+		stats.SentBytes += record.SentBytes
+		stats.ReceivedBytes += record.ReceivedBytes
+		stats.TotalDuration += record.Duration
+		if record.Duration > stats.MaxDuration {
+			stats.MaxDuration = record.Duration
+		}
+		if record.TimeToFirstByte > 0 {
+			// the average is computed once every record is processed
+			stats.AverageTimeToFirstByte += record.TimeToFirstByte
+			firstBytes[record.Host]++
+		}
+		if record.ConnectedAt.After(stats.LastConnectedAt) {
+			stats.LastConnectedAt = record.ConnectedAt
+		}
+	}
+
+	hosts := make([]HostStats, 0, len(byHost))
+	for host, stats := range byHost {
+		if stats.Sessions > 0 {
+			stats.AverageDuration = stats.TotalDuration / time.Duration(stats.Sessions)
+		}
+		if count := firstBytes[host]; count > 0 {
+			stats.AverageTimeToFirstByte /= time.Duration(count)
+		}
+		hosts = append(hosts, *stats)
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Host < hosts[j].Host })
+	return hosts
+}
+
+// prometheusMetric describes a metric of the Prometheus text exposition format
+type prometheusMetric struct {
+	name  string
+	kind  string
+	help  string
+	value func(HostStats) float64
+}
+
+var prometheusMetrics = []prometheusMetric{
+	{"assh_sessions_total", "counter", "Number of established connections.", func(s HostStats) float64 { return float64(s.Sessions) }},
+	{"assh_connection_failures_total", "counter", "Number of failed connections.", func(s HostStats) float64 { return float64(s.Failures) }},
+	{"assh_sent_bytes_total", "counter", "Number of bytes sent to the host.", func(s HostStats) float64 { return float64(s.SentBytes) }},
+	{"assh_received_bytes_total", "counter", "Number of bytes received from the host.", func(s HostStats) float64 { return float64(s.ReceivedBytes) }},
+	{"assh_session_duration_seconds_total", "counter", "Cumulated duration of the connections.", func(s HostStats) float64 { return s.TotalDuration.Seconds() }},
+	{"assh_session_duration_seconds_max", "gauge", "Duration of the longest connection.", func(s HostStats) float64 { return s.MaxDuration.Seconds() }},
+	{"assh_time_to_first_byte_seconds_avg", "gauge", "Average time between the connection and the first byte received.", func(s HostStats) float64 { return s.AverageTimeToFirstByte.Seconds() }},
+	{"assh_last_session_timestamp_seconds", "gauge", "Time of the last established connection.", func(s HostStats) float64 {
+		if s.LastConnectedAt.IsZero() {
+			return 0
+		}
+		return float64(s.LastConnectedAt.UnixNano()) / 1e9
+	}},
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WritePrometheus writes the statistics using the Prometheus text exposition format
+func WritePrometheus(w io.Writer, hosts []HostStats) error {
+	for _, metric := range prometheusMetrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind); err != nil {
+			return err
+		}
+		for _, stats := range hosts {
+			if _, err := fmt.Fprintf(w, "%s{host=\"%s\"} %g\n", metric.name, labelEscaper.Replace(stats.Host), metric.value(stats)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WritePrometheusFile writes the statistics to a file for the node_exporter textfile collector,
+// the file is replaced atomically so the collector never reads a partial file
+func WritePrometheusFile(path string, hosts []HostStats) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	// no-op once the file is renamed
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if err := WritePrometheus(tmp, hosts); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// the collector usually runs as another user
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
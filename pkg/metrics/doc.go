@@ -0,0 +1 @@
+package metrics // import "moul.io/assh/v2/pkg/metrics"
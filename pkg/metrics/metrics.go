@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Record contains the metrics of a finished connection, records are stored as JSON lines
+type Record struct {
+	Host            string
+	HostName        string
+	Port            string
+	Gateway         string `json:",omitempty"`
+	CreatedAt       time.Time
+	ConnectedAt     time.Time
+	DisconnectedAt  time.Time
+	Duration        time.Duration
+	TimeToFirstByte time.Duration
+	SentBytes       uint64
+	ReceivedBytes   uint64
+	Error           string `json:",omitempty"`
+}
+
+// Failed returns true if the connection could not be established
+func (r Record) Failed() bool {
+	return r.ConnectedAt.IsZero()
+}
+
+// Append appends a record to the metrics file, the file and its parent directory are created if needed.
+// Each record is written using a single write, so concurrent assh processes can share the same file.
+func Append(path string, record Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(line); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Read returns the records of a metrics file, a missing file contains no records
+func Read(path string) ([]Record, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Decode(file)
+}
+
+// Decode reads JSON lines records, malformed lines (i.e: truncated by a crash) are skipped
+func Decode(r io.Reader) ([]Record, error) {
+	records := []Record{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			logger().Debug("skipping malformed record", zap.Int("line", lineNumber), zap.Error(err))
+			continue
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read records: %w", err)
+	}
+	return records, nil
+}
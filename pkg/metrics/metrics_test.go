@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_AppendRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subdir", "metrics.ndjson")
+
+	records, err := Read(path)
+	require.NoError(t, err)
+	require.Empty(t, records)
+
+	connectedAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	record := Record{
+		Host:           "example",
+		HostName:       "1.2.3.4",
+		Port:           "22",
+		Gateway:        "direct",
+		CreatedAt:      connectedAt.Add(-time.Second),
+		ConnectedAt:    connectedAt,
+		DisconnectedAt: connectedAt.Add(time.Minute),
+		Duration:       time.Minute,
+		SentBytes:      42,
+		ReceivedBytes:  1337,
+	}
+	require.NoError(t, Append(path, record))
+	require.NoError(t, Append(path, Record{Host: "example", Error: "failed to dial"}))
+
+	// a truncated line does not prevent reading the other records
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"Host":"trunc` + "\n")
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	require.NoError(t, Append(path, record))
+
+	records, err = Read(path)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	require.Equal(t, record.ConnectedAt, records[0].ConnectedAt.UTC())
+	require.Equal(t, record.ReceivedBytes, records[0].ReceivedBytes)
+	require.False(t, records[0].Failed())
+	require.True(t, records[1].Failed())
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	if os.PathSeparator == '/' {
+		require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	}
+}
+
+func Test_Aggregate(t *testing.T) {
+	now := time.Now()
+	records := []Record{
+		{Host: "b", ConnectedAt: now, Duration: 10 * time.Second, TimeToFirstByte: 20 * time.Millisecond, SentBytes: 1, ReceivedBytes: 10},
+		{Host: "a", Error: "failed to dial"},
+		{Host: "b", ConnectedAt: now.Add(time.Hour), Duration: 30 * time.Second, SentBytes: 2, ReceivedBytes: 20},
+		{Host: "b", ConnectedAt: now.Add(-time.Hour), Duration: 20 * time.Second, TimeToFirstByte: 40 * time.Millisecond},
+		{Host: "b", Error: "failed to dial"},
+	}
+
+	hosts := Aggregate(records)
+	require.Equal(t, []HostStats{
+		{Host: "a", Failures: 1},
+		{
+			Host:                   "b",
+			Sessions:               3,
+			Failures:               1,
+			SentBytes:              3,
+			ReceivedBytes:          30,
+			TotalDuration:          time.Minute,
+			AverageDuration:        20 * time.Second,
+			MaxDuration:            30 * time.Second,
+			AverageTimeToFirstByte: 30 * time.Millisecond,
+			LastConnectedAt:        now.Add(time.Hour),
+		},
+	}, hosts)
+}
+
+func Test_WritePrometheus(t *testing.T) {
+	hosts := []HostStats{
+		{Host: "a", Failures: 1},
+		{Host: `b"c`, Sessions: 2, SentBytes: 1024, TotalDuration: 90 * time.Second, LastConnectedAt: time.Unix(1600000000, 0)},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WritePrometheus(&buf, hosts))
+	output := buf.String()
+	require.Contains(t, output, "# TYPE assh_sessions_total counter\nassh_sessions_total{host=\"a\"} 0\nassh_sessions_total{host=\"b\\\"c\"} 2\n")
+	require.Contains(t, output, "assh_connection_failures_total{host=\"a\"} 1\n")
+	require.Contains(t, output, "assh_sent_bytes_total{host=\"b\\\"c\"} 1024\n")
+	require.Contains(t, output, "assh_session_duration_seconds_total{host=\"b\\\"c\"} 90\n")
+	require.Contains(t, output, "assh_last_session_timestamp_seconds{host=\"b\\\"c\"} 1.6e+09\n")
+	require.Equal(t, len(prometheusMetrics)*(2+len(hosts)), strings.Count(output, "\n"))
+
+	path := filepath.Join(t.TempDir(), "assh.prom")
+	require.NoError(t, WritePrometheusFile(path, hosts))
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, output, string(content))
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
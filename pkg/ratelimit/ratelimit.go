@@ -3,73 +3,129 @@ package ratelimit
 // Package ratelimit based on http://hustcat.github.io/rate-limit-example-in-go/
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"io"
 	"time"
 
 	"golang.org/x/time/rate"
 )
 
-type reader struct {
-	r       io.Reader
+// clock is the source of time of the limiters, replaced by a fake clock in the tests
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// limited waits for the tokens of a limiter, a token represents one byte
+type limited struct {
+	ctx     context.Context
 	limiter *rate.Limiter
+	clock   clock
+}
+
+// wait blocks until n bytes can be transferred or ctx is done.
+// The reservations are split in chunks no larger than the limiter's burst,
+// so any amount of bytes can be transferred.
+func (l *limited) wait(n int) error {
+	if l.limiter.Limit() == rate.Inf {
+		return nil
+	}
+
+	for n > 0 {
+		// the burst can be changed during the transfer, i.e: by the bandwidth coordinator
+		burst := l.limiter.Burst()
+		if burst < 1 {
+			return errors.New("rate limiter burst must be positive")
+		}
+		chunk := n
+		if chunk > burst {
+			chunk = burst
+		}
+
+		now := l.clock.Now()
+		reservation := l.limiter.ReserveN(now, chunk)
+		if !reservation.OK() {
+			// the burst was lowered since it was read, the chunk is retried with the new burst
+			continue
+		}
+		if delay := reservation.DelayFrom(now); delay > 0 {
+			select {
+			case <-l.ctx.Done():
+				reservation.CancelAt(l.clock.Now())
+				return l.ctx.Err()
+			case <-l.clock.After(delay):
+			}
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+type reader struct {
+	limited
+	r io.Reader
 }
 
 // NewReader returns a reader that is rate limited by
 // the given token bucket. Each token in the bucket
 // represents one byte.
 func NewReader(r io.Reader, l *rate.Limiter) io.Reader {
+	return NewReaderWithContext(context.Background(), r, l)
+}
+
+// NewReaderWithContext returns a rate limited reader, the reads stop waiting for the limiter once ctx is done
+func NewReaderWithContext(ctx context.Context, r io.Reader, l *rate.Limiter) io.Reader {
 	return &reader{
+		limited: limited{ctx: ctx, limiter: l, clock: realClock{}},
 		r:       r,
-		limiter: l,
 	}
 }
 
+// Read reads from the underlying reader then waits for the limiter, the bytes read are always returned
 func (r *reader) Read(buf []byte) (int, error) {
 	n, err := r.r.Read(buf)
 	if n <= 0 {
 		return n, err
 	}
-
-	now := time.Now()
-	rv := r.limiter.ReserveN(now, n)
-	if !rv.OK() {
-		return 0, fmt.Errorf("exceeds limiter's burst")
+	if waitErr := r.wait(n); waitErr != nil {
+		return n, waitErr
 	}
-	delay := rv.DelayFrom(now)
-	// fmt.Printf("Read %d bytes, delay %d\n", n, delay)
-	time.Sleep(delay)
 	return n, err
 }
 
 type writer struct {
-	w       io.Writer
-	limiter *rate.Limiter
+	limited
+	w io.Writer
 }
 
 // NewWriter returns a writer that is rate limited by
 // the given token bucket. Each token in the bucket
 // represents one byte.
 func NewWriter(w io.Writer, l *rate.Limiter) io.Writer {
+	return NewWriterWithContext(context.Background(), w, l)
+}
+
+// NewWriterWithContext returns a rate limited writer, the writes stop waiting for the limiter once ctx is done
+func NewWriterWithContext(ctx context.Context, w io.Writer, l *rate.Limiter) io.Writer {
 	return &writer{
+		limited: limited{ctx: ctx, limiter: l, clock: realClock{}},
 		w:       w,
-		limiter: l,
 	}
 }
 
+// Write waits for the limiter then writes to the underlying writer, nothing is written if ctx is done
 func (w *writer) Write(buf []byte) (int, error) {
-	n, err := w.w.Write(buf)
-	if n <= 0 {
-		return n, err
+	if len(buf) == 0 {
+		return w.w.Write(buf)
 	}
-
-	now := time.Now()
-	rv := w.limiter.ReserveN(now, n)
-	if !rv.OK() {
-		return 0, fmt.Errorf("exceeds limiter's burst")
+	if err := w.wait(len(buf)); err != nil {
+		return 0, err
 	}
-	delay := rv.DelayFrom(now)
-	// fmt.Printf("Write %d bytes, delay %d\n", n, delay)
-	time.Sleep(delay)
-	return n, err
+	return w.w.Write(buf)
 }
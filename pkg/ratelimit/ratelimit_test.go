@@ -0,0 +1,152 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+// fakeClock advances its time instead of sleeping
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	slept time.Duration
+	// onAfter is called before the clock advances, i.e: to cancel a context
+	onAfter func()
+	// onSleep is called before the clock advances, the clock still advances
+	onSleep func()
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	if c.onAfter != nil {
+		c.onAfter()
+		// never fires, the context is done
+		return make(chan time.Time)
+	}
+	if c.onSleep != nil {
+		c.onSleep()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.slept += d
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func Test_Reader(t *testing.T) {
+	tt := map[string]struct {
+		limit, burst int
+		size         int
+		want         time.Duration
+	}{
+		"Within burst":       {limit: 100, burst: 100, size: 100, want: 0},
+		"Exceeds burst":      {limit: 100, burst: 100, size: 1000, want: 9 * time.Second},
+		"Small burst":        {limit: 100, burst: 10, size: 300, want: 2900 * time.Millisecond},
+		"Burst above limit":  {limit: 100, burst: 500, size: 1000, want: 5 * time.Second},
+		"Larger than buffer": {limit: 1024, burst: 1, size: 64 * 1024, want: 64 * time.Second},
+	}
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			clock := newFakeClock()
+			r := NewReader(strings.NewReader(strings.Repeat("x", tc.size)), rate.NewLimiter(rate.Limit(tc.limit), tc.burst)).(*reader)
+			r.clock = clock
+
+			got, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Len(t, got, tc.size)
+			require.InDelta(t, tc.want.Seconds(), clock.slept.Seconds(), 0.01)
+		})
+	}
+}
+
+func Test_Writer(t *testing.T) {
+	clock := newFakeClock()
+	var buf bytes.Buffer
+	w := NewWriter(&buf, rate.NewLimiter(100, 50)).(*writer)
+	w.clock = clock
+
+	// a single write larger than the burst is split in several reservations
+	n, err := w.Write(bytes.Repeat([]byte("x"), 250))
+	require.NoError(t, err)
+	require.Equal(t, 250, n)
+	require.Equal(t, 250, buf.Len())
+	require.InDelta(t, 2.0, clock.slept.Seconds(), 0.01)
+}
+
+func Test_LoweredBurst(t *testing.T) {
+	clock := newFakeClock()
+	limiter := rate.NewLimiter(100, 100)
+	clock.onSleep = func() { limiter.SetBurstAt(clock.Now(), 10) }
+	var buf bytes.Buffer
+	w := NewWriter(&buf, limiter).(*writer)
+	w.clock = clock
+
+	// the burst is lowered while the second chunk waits, the next chunks fit in the new burst
+	n, err := w.Write(bytes.Repeat([]byte("x"), 300))
+	require.NoError(t, err)
+	require.Equal(t, 300, n)
+	require.InDelta(t, 2.0, clock.slept.Seconds(), 0.2)
+}
+
+func Test_Unlimited(t *testing.T) {
+	clock := newFakeClock()
+	r := NewReader(strings.NewReader(strings.Repeat("x", 1000)), rate.NewLimiter(rate.Inf, 0)).(*reader)
+	r.clock = clock
+
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Len(t, got, 1000)
+	require.Zero(t, clock.slept)
+}
+
+func Test_InvalidBurst(t *testing.T) {
+	_, err := NewWriter(io.Discard, rate.NewLimiter(100, 0)).Write([]byte("x"))
+	require.Error(t, err)
+}
+
+func Test_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := newFakeClock()
+	clock.onAfter = cancel
+	var buf bytes.Buffer
+	limiter := rate.NewLimiter(100, 100)
+	w := NewWriterWithContext(ctx, &buf, limiter).(*writer)
+	w.clock = clock
+
+	// the first chunk is within the burst, the second one waits and is canceled
+	n, err := w.Write(bytes.Repeat([]byte("x"), 200))
+	require.ErrorIs(t, err, context.Canceled)
+	require.Zero(t, n)
+	require.Zero(t, buf.Len())
+
+	// the canceled reservation gives its tokens back
+	require.InDelta(t, 0, limiter.TokensAt(clock.Now()), 0.01)
+
+	r := NewReaderWithContext(ctx, strings.NewReader("data"), rate.NewLimiter(1, 1)).(*reader)
+	r.clock = clock
+	read := make([]byte, 10)
+	n, err = r.Read(read)
+	require.Equal(t, 4, n)
+	require.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1 @@
+package recording // import "moul.io/assh/v2/pkg/recording"
@@ -0,0 +1,9 @@
+// Code generated by moul.io/assh/contrib/generate-loggers.sh
+
+package recording
+
+import "go.uber.org/zap"
+
+func logger() *zap.Logger {
+	return zap.L().Named("assh.pkg.recording")
+}
@@ -0,0 +1,190 @@
+package recording
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maxLineSize is the maximum size of a line of a recording
+const maxLineSize = 1024 * 1024
+
+// Info describes a recording file
+type Info struct {
+	Name      string        `json:"name"`
+	Path      string        `json:"path"`
+	Host      string        `json:"host,omitempty"`
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"duration"`
+	Size      int64         `json:"size"`
+}
+
+// PlayOptions configures the replay of a recording
+type PlayOptions struct {
+	// Speed multiplies the replay speed, 1 if not positive
+	Speed float64
+	// IdleLimit caps the pauses between the events, disabled if zero
+	IdleLimit time.Duration
+}
+
+// Stat reads the header and the events of the recording at path
+func Stat(path string) (Info, error) {
+	info := Info{Path: path, Name: strings.TrimSuffix(filepath.Base(path), Extension)}
+	file, err := os.Open(path)
+	if err != nil {
+		return info, err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return info, err
+	}
+	info.Size = fileInfo.Size()
+
+	var last float64
+	header, err := readEvents(file, func(event Event) error {
+		last = event.Time
+		return nil
+	})
+	if err != nil {
+		return info, err
+	}
+	info.Host = header.Host
+	info.StartedAt = time.Unix(header.Timestamp, 0)
+	info.Duration = time.Duration(last * float64(time.Second))
+	return info, nil
+}
+
+// List returns the recordings of dir sorted by start time, the invalid files are ignored
+func List(dir string) ([]Info, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []Info{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	recordings := []Info{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != Extension {
+			continue
+		}
+		info, err := Stat(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			logger().Warn("Ignoring invalid recording", zap.String("path", info.Path), zap.Error(err))
+			continue
+		}
+		recordings = append(recordings, info)
+	}
+	sort.SliceStable(recordings, func(i, j int) bool {
+		return recordings[i].StartedAt.Before(recordings[j].StartedAt)
+	})
+	return recordings, nil
+}
+
+// Clean removes the recordings of dir last modified more than maxAge before now, it returns the removed files
+func Clean(dir string, maxAge time.Duration, now time.Time) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	removed := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != Extension {
+			continue
+		}
+		fileInfo, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if now.Sub(fileInfo.ModTime()) <= maxAge {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if err := os.Remove(path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
+
+// Play replays the output events of a recording to w, respecting their timing
+func Play(ctx context.Context, w io.Writer, r io.Reader, options PlayOptions) error {
+	speed := options.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+	var last float64
+	_, err := readEvents(r, func(event Event) error {
+		delay := time.Duration((event.Time - last) * float64(time.Second))
+		last = event.Time
+		if options.IdleLimit > 0 && delay > options.IdleLimit {
+			delay = options.IdleLimit
+		}
+		if delay = time.Duration(float64(delay) / speed); delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		if event.Code != "o" {
+			return nil
+		}
+		_, err := io.WriteString(w, event.Data)
+		return err
+	})
+	return err
+}
+
+// readEvents decodes the header of an asciicast v2 recording and calls fn for each of its events
+func readEvents(r io.Reader, fn func(Event) error) (Header, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	var header Header
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return header, err
+		}
+		return header, io.ErrUnexpectedEOF
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return header, fmt.Errorf("invalid header: %w", err)
+	}
+	if header.Version != 2 {
+		return header, fmt.Errorf("unsupported asciicast version: %d", header.Version)
+	}
+
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return header, fmt.Errorf("invalid event: %w", err)
+		}
+		if err := fn(event); err != nil {
+			return header, err
+		}
+	}
+	return header, scanner.Err()
+}
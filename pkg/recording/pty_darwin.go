@@ -0,0 +1,45 @@
+package recording
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPTY opens a new pseudo-terminal, it returns its master and slave sides
+func openPTY() (*os.File, *os.File, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	fd := master.Fd()
+	// grantpt, unlockpt, then ptsname
+	var name [128]byte
+	for _, request := range []struct {
+		code uintptr
+		arg  uintptr
+	}{
+		{unix.TIOCPTYGRANT, 0},
+		{unix.TIOCPTYUNLK, 0},
+		{unix.TIOCPTYGNAME, uintptr(unsafe.Pointer(&name[0]))},
+	} {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request.code, request.arg); errno != 0 {
+			_ = master.Close()
+			return nil, nil, fmt.Errorf("failed to prepare the pseudo-terminal: %w", errno)
+		}
+	}
+	path := string(name[:])
+	if end := bytes.IndexByte(name[:], 0); end >= 0 {
+		path = string(name[:end])
+	}
+	slave, err := os.OpenFile(path, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		_ = master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
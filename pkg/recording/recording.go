@@ -0,0 +1,194 @@
+package recording
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Extension is the extension of the recording files
+const Extension = ".cast"
+
+// the size of the terminal of the recordings, when the size of the recorded terminal is unknown
+const (
+	defaultWidth  = 80
+	defaultHeight = 24
+)
+
+// Header is the first line of an asciicast v2 recording
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+	// Host is the name of the recorded assh host, an extension of the asciicast format ignored by the players
+	Host string `json:"host,omitempty"`
+}
+
+// Event is an asciicast v2 event: its time in seconds since the beginning of the recording, its code ("o" for an
+// output, "r" for a resize) and its data
+type Event struct {
+	Time float64
+	Code string
+	Data string
+}
+
+// MarshalJSON encodes the event as an asciicast array
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Time, e.Code, e.Data})
+}
+
+// UnmarshalJSON decodes an asciicast array
+func (e *Event) UnmarshalJSON(data []byte) error {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if len(fields) != 3 {
+		return fmt.Errorf("invalid event: %d fields", len(fields))
+	}
+	for i, dest := range []interface{}{&e.Time, &e.Code, &e.Data} {
+		if err := json.Unmarshal(fields[i], dest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Recorder writes a terminal session in the asciicast v2 format: the output of the terminal and its resizes. It is
+// safe for concurrent use.
+type Recorder struct {
+	mu      sync.Mutex
+	file    io.WriteCloser
+	encoder *json.Encoder
+	start   time.Time
+	now     func() time.Time
+	// partial is the beginning of an UTF-8 character cut between two writes
+	partial []byte
+	err     error
+}
+
+// Create creates the recording file at path, its Version, Width, Height and Timestamp are set if empty
+func Create(path string, header Header) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	recorder, err := newRecorder(file, header, time.Now)
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(path)
+		return nil, err
+	}
+	return recorder, nil
+}
+
+func newRecorder(file io.WriteCloser, header Header, now func() time.Time) (*Recorder, error) {
+	r := &Recorder{file: file, encoder: json.NewEncoder(file), start: now(), now: now}
+	header.Version = 2
+	if header.Width <= 0 {
+		header.Width = defaultWidth
+	}
+	if header.Height <= 0 {
+		header.Height = defaultHeight
+	}
+	if header.Timestamp == 0 {
+		header.Timestamp = r.start.Unix()
+	}
+	if err := r.encoder.Encode(header); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Write records data as an output of the terminal. The errors are kept for Close, to not break the recorded session.
+func (r *Recorder) Write(data []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	output := append(r.partial, data...)
+	cut := completeUTF8(output)
+	r.partial = append([]byte(nil), output[cut:]...)
+	r.record("o", string(output[:cut]))
+	return len(data), nil
+}
+
+// Resize records a resize of the terminal
+func (r *Recorder) Resize(width int, height int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.record("r", fmt.Sprintf("%dx%d", width, height))
+}
+
+// Close writes the pending output and closes the file, it returns the first error met while recording
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.record("o", string(r.partial))
+	r.partial = nil
+	if err := r.file.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+func (r *Recorder) record(code string, data string) {
+	if data == "" || r.err != nil {
+		return
+	}
+	seconds := math.Round(r.now().Sub(r.start).Seconds()*1e6) / 1e6
+	r.err = r.encoder.Encode(Event{Time: seconds, Code: code, Data: data})
+}
+
+// completeUTF8 returns the length of the beginning of data not ending with an incomplete UTF-8 character
+func completeUTF8(data []byte) int {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(data)
+}
+
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// FileName returns the name of the recording of a connection to host started at start by the process pid
+func FileName(host string, start time.Time, pid int) string {
+	// no path separator, nor hidden file
+	host = strings.TrimLeft(unsafeFileNameChars.ReplaceAllString(host, "_"), ".")
+	return fmt.Sprintf("%s-%s-%d%s", host, start.Format("20060102-150405"), pid, Extension)
+}
+
+// ParseRetention parses the maximum age of the recordings, a duration accepting days (e.g. "30d" or "12h")
+func ParseRetention(value string) (time.Duration, error) {
+	var duration time.Duration
+	if days := strings.TrimSuffix(value, "d"); days != value {
+		count, err := strconv.ParseUint(days, 10, 16)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number of days: %q", value)
+		}
+		duration = time.Duration(count) * 24 * time.Hour
+	} else {
+		var err error
+		if duration, err = time.ParseDuration(value); err != nil {
+			return 0, err
+		}
+	}
+	if duration <= 0 {
+		return 0, errors.New("the retention must be positive")
+	}
+	return duration, nil
+}
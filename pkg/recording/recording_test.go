@@ -0,0 +1,134 @@
+package recording
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func Test_Recorder(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	var buf bytes.Buffer
+	recorder, err := newRecorder(nopCloser{&buf}, Header{Width: 120, Height: 40, Host: "example"}, func() time.Time { return now })
+	require.NoError(t, err)
+
+	now = start.Add(time.Second)
+	_, err = recorder.Write([]byte("$ ls\r\n"))
+	require.NoError(t, err)
+	// an UTF-8 character cut between two writes is recorded once complete
+	now = now.Add(20 * time.Millisecond)
+	_, err = recorder.Write([]byte("caf\xc3"))
+	require.NoError(t, err)
+	now = now.Add(10 * time.Millisecond)
+	_, err = recorder.Write([]byte("\xa9\r\n"))
+	require.NoError(t, err)
+	now = start.Add(2 * time.Second)
+	recorder.Resize(100, 30)
+	now = start.Add(3500 * time.Millisecond)
+	_, err = recorder.Write([]byte("$ exit\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, recorder.Close())
+
+	expected := `{"version":2,"width":120,"height":40,"timestamp":1577836800,"host":"example"}
+[1,"o","$ ls\r\n"]
+[1.02,"o","caf"]
+[1.03,"o","é\r\n"]
+[2,"r","100x30"]
+[3.5,"o","$ exit\r\n"]
+`
+	require.Equal(t, expected, buf.String())
+
+	var played bytes.Buffer
+	require.NoError(t, Play(context.Background(), &played, strings.NewReader(expected), PlayOptions{IdleLimit: time.Millisecond}))
+	require.Equal(t, "$ ls\r\ncafé\r\n$ exit\r\n", played.String())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, Play(ctx, ioutil.Discard, strings.NewReader(expected), PlayOptions{}), context.Canceled)
+
+	require.Error(t, Play(context.Background(), ioutil.Discard, strings.NewReader(`{"version":1}`), PlayOptions{}))
+}
+
+func Test_List_Clean(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	for i, host := range []string{"new", "old"} {
+		recorder, err := Create(filepath.Join(dir, FileName(host, now, 42)), Header{Host: host, Timestamp: now.Add(-time.Duration(i) * time.Hour).Unix()})
+		require.NoError(t, err)
+		_, err = recorder.Write([]byte("hello"))
+		require.NoError(t, err)
+		require.NoError(t, recorder.Close())
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "invalid"+Extension), []byte("invalid"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a recording"), 0600))
+
+	recordings, err := List(dir)
+	require.NoError(t, err)
+	require.Len(t, recordings, 2)
+	require.Equal(t, "old", recordings[0].Host)
+	require.Equal(t, "new", recordings[1].Host)
+	require.Equal(t, "new-"+now.Format("20060102-150405")+"-42", recordings[1].Name)
+	require.Greater(t, recordings[1].Size, int64(0))
+
+	oldPath := filepath.Join(dir, FileName("old", now, 42))
+	require.NoError(t, os.Chtimes(oldPath, now.Add(-48*time.Hour), now.Add(-48*time.Hour)))
+	removed, err := Clean(dir, 24*time.Hour, now)
+	require.NoError(t, err)
+	require.Equal(t, []string{oldPath}, removed)
+
+	_, err = os.Stat(filepath.Join(dir, "notes.txt"))
+	require.NoError(t, err)
+
+	recordings, err = List(filepath.Join(dir, "missing"))
+	require.NoError(t, err)
+	require.Empty(t, recordings)
+}
+
+func Test_FileName(t *testing.T) {
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.Equal(t, "db-1.example.com-20200102-030405-42.cast", FileName("db-1.example.com", start, 42))
+	require.Equal(t, "_etc_passwd-20200102-030405-42.cast", FileName("../etc/passwd", start, 42))
+}
+
+func Test_ParseRetention(t *testing.T) {
+	tt := map[string]struct {
+		expected time.Duration
+		wantErr  bool
+	}{
+		"30d":   {expected: 30 * 24 * time.Hour},
+		"12h":   {expected: 12 * time.Hour},
+		"1h30m": {expected: 90 * time.Minute},
+		"0d":    {wantErr: true},
+		"-1h":   {wantErr: true},
+		"d":     {wantErr: true},
+		"1.5d":  {wantErr: true},
+		"week":  {wantErr: true},
+	}
+	for value, test := range tt {
+		t.Run(value, func(t *testing.T) {
+			got, err := ParseRetention(value)
+			if test.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.expected, got)
+		})
+	}
+}
@@ -0,0 +1,89 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package recording
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+// drainTimeout is the time given to the output of the terminal once the command exited, the processes it started in
+// the background may keep the terminal open
+const drainTimeout = time.Second
+
+// Supported is true if the terminal sessions can be recorded on this system
+const Supported = true
+
+// Run runs cmd in a pseudo-terminal bound to the terminal of the standard input and output, and records the output
+// and the resizes of the terminal. The terminal is in raw mode during the session.
+func Run(cmd *exec.Cmd, recorder *Recorder) error {
+	return run(cmd, recorder, os.Stdin, os.Stdout)
+}
+
+func run(cmd *exec.Cmd, recorder *Recorder, input *os.File, output io.Writer) error {
+	master, slave, err := openPTY()
+	if err != nil {
+		return err
+	}
+	defer master.Close()
+
+	stdin := int(input.Fd())
+	resize := func() {
+		size, err := unix.IoctlGetWinsize(stdin, unix.TIOCGWINSZ)
+		if err != nil {
+			return
+		}
+		_ = unix.IoctlSetWinsize(int(master.Fd()), unix.TIOCSWINSZ, size)
+		recorder.Resize(int(size.Col), int(size.Row))
+	}
+	if size, err := unix.IoctlGetWinsize(stdin, unix.TIOCGWINSZ); err == nil {
+		_ = unix.IoctlSetWinsize(int(master.Fd()), unix.TIOCSWINSZ, size)
+	}
+
+	// the command leads a new session, controlled by the pseudo-terminal
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	err = cmd.Start()
+	_ = slave.Close()
+	if err != nil {
+		return err
+	}
+
+	if state, err := term.MakeRaw(stdin); err == nil {
+		defer func() { _ = term.Restore(stdin, state) }()
+	}
+	resizes := make(chan os.Signal, 1)
+	signal.Notify(resizes, syscall.SIGWINCH)
+	defer func() {
+		signal.Stop(resizes)
+		close(resizes)
+	}()
+	go func() {
+		for range resizes {
+			resize()
+		}
+	}()
+
+	go func() { _, _ = io.Copy(master, input) }()
+	copied := make(chan struct{})
+	go func() {
+		// the copy ends once every process using the terminal exited, with EIO on Linux
+		_, _ = io.Copy(io.MultiWriter(output, recorder), master)
+		close(copied)
+	}()
+
+	err = cmd.Wait()
+	select {
+	case <-copied:
+	case <-time.After(drainTimeout):
+	}
+	return err
+}
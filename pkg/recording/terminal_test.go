@@ -0,0 +1,39 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package recording
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_run(t *testing.T) {
+	input, err := os.Open(os.DevNull)
+	require.NoError(t, err)
+	defer input.Close()
+
+	var buf bytes.Buffer
+	recorder, err := newRecorder(nopCloser{&buf}, Header{}, time.Now)
+	require.NoError(t, err)
+
+	var output bytes.Buffer
+	// the command runs in a terminal
+	cmd := exec.Command("sh", "-c", `test -t 1 && printf 'hello\n'; exit 3`)
+	err = run(cmd, recorder, input, &output)
+	require.Error(t, err)
+	require.Equal(t, 3, cmd.ProcessState.ExitCode())
+	require.NoError(t, recorder.Close())
+
+	// the terminal translates the newlines
+	require.Equal(t, "hello\r\n", output.String())
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	require.Contains(t, lines[1], `"o","hello\r\n"]`)
+}
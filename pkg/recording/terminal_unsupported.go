@@ -0,0 +1,17 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package recording
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// Supported is true if the terminal sessions can be recorded on this system
+const Supported = false
+
+// Run is not supported on this system
+func Run(cmd *exec.Cmd, recorder *Recorder) error {
+	return errors.New("the terminal sessions cannot be recorded on this system")
+}
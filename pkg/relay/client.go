@@ -0,0 +1,114 @@
+package relay
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maxRetryDelay is the maximum delay between two reconnection attempts
+var maxRetryDelay = 10 * time.Second
+
+// Dialer opens a connection to the relay
+type Dialer func() (net.Conn, error)
+
+// Dial opens a new session with the relay reached by dial.
+// When the connection drops, dial is called again until the session is resumed or timeout expires.
+func Dial(dial Dialer, timeout time.Duration) (*Session, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	s := newSession(id)
+
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	if err := handshake(s, conn, helloNew); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	go reconnect(s, dial, timeout)
+	return s, nil
+}
+
+// handshake opens or resumes the session on conn
+func handshake(s *Session, conn net.Conn, flag byte) error {
+	s.mu.Lock()
+	greeting := hello{flag: flag, id: s.id, received: s.received}
+	s.mu.Unlock()
+
+	_ = conn.SetDeadline(time.Now().Add(ioTimeout))
+	if _, err := conn.Write(greeting.marshal()); err != nil {
+		return err
+	}
+	answer, err := readWelcome(conn)
+	if err != nil {
+		return err
+	}
+	switch answer.status {
+	case welcomeOK:
+	case welcomeUnknown:
+		return ErrUnknownSession
+	default:
+		return fmt.Errorf("unexpected relay status %d", answer.status)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return s.attach(conn, answer.received)
+}
+
+// reconnect resumes the session each time its connection is lost, it fails after timeout without connection
+func reconnect(s *Session, dial Dialer, timeout time.Duration) {
+	for {
+		select {
+		case <-s.disconnected:
+		case <-s.done:
+			return
+		}
+
+		lostAt := time.Now()
+		delay := 100 * time.Millisecond
+		for attempt := 1; !s.connected(); attempt++ {
+			select {
+			case <-time.After(delay):
+			case <-s.done:
+				return
+			}
+			logger().Info("Reconnecting to relay", zap.Int("attempt", attempt))
+			err := resume(s, dial)
+			if err == nil {
+				logger().Info("Reconnected to relay", zap.Int("attempt", attempt), zap.Duration("after", time.Since(lostAt)))
+				break
+			}
+			logger().Warn("Failed to reconnect to relay", zap.Int("attempt", attempt), zap.Error(err))
+			if errors.Is(err, ErrUnknownSession) {
+				s.fail(err)
+				return
+			}
+			if timeout > 0 && time.Since(lostAt) > timeout {
+				s.fail(fmt.Errorf("cannot reconnect to relay for %v: %w", timeout, err))
+				return
+			}
+			if delay *= 2; delay > maxRetryDelay {
+				delay = maxRetryDelay
+			}
+		}
+	}
+}
+
+func resume(s *Session, dial Dialer) error {
+	conn, err := dial()
+	if err != nil {
+		return err
+	}
+	if err := handshake(s, conn, helloResume); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,5 @@
+// Package relay implements a reconnectable stream between "assh connect" and a remote "assh relay":
+// the stream is cut in numbered frames, kept in a replay buffer until acknowledged by the other side.
+// When the underlying connection drops, a new one resumes the session: each side tells the other the
+// last frame it received and the missing frames are sent again.
+package relay // import "moul.io/assh/v2/pkg/relay"
@@ -0,0 +1,148 @@
+package relay
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// frame kinds
+const (
+	frameData  byte = 1
+	frameAck   byte = 2
+	frameClose byte = 3
+)
+
+const (
+	// frameHeaderSize is kind (1), seq (8), ack (8) and payload length (4)
+	frameHeaderSize = 21
+	// maxPayload is the maximum size of the payload of a data frame
+	maxPayload = 32 * 1024
+)
+
+// frame is the unit exchanged on a connection; data and close frames are numbered
+// and kept until acknowledged, to be sent again on the next connection
+type frame struct {
+	kind byte
+	// seq is the sequence number of data and close frames, starting at 1
+	seq uint64
+	// ack is the sequence number of the last frame received in order by the sender
+	ack     uint64
+	payload []byte
+}
+
+func (f *frame) size() int {
+	return frameHeaderSize + len(f.payload)
+}
+
+// marshal returns the frame encoded to be written in a single call
+func (f *frame) marshal() []byte {
+	buf := make([]byte, f.size())
+	buf[0] = f.kind
+	binary.BigEndian.PutUint64(buf[1:9], f.seq)
+	binary.BigEndian.PutUint64(buf[9:17], f.ack)
+	binary.BigEndian.PutUint32(buf[17:21], uint32(len(f.payload)))
+	copy(buf[frameHeaderSize:], f.payload)
+	return buf
+}
+
+func readFrame(r io.Reader) (*frame, error) {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	f := &frame{
+		kind: header[0],
+		seq:  binary.BigEndian.Uint64(header[1:9]),
+		ack:  binary.BigEndian.Uint64(header[9:17]),
+	}
+	switch f.kind {
+	case frameData, frameAck, frameClose:
+	default:
+		return nil, fmt.Errorf("invalid frame kind %d", f.kind)
+	}
+	length := binary.BigEndian.Uint32(header[17:21])
+	if length > maxPayload {
+		return nil, fmt.Errorf("frame payload too large (%d bytes)", length)
+	}
+	if length > 0 {
+		f.payload = make([]byte, length)
+		if _, err := io.ReadFull(r, f.payload); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// handshake
+
+// magic starts the handshake messages of both sides
+var magic = [8]byte{'A', 'S', 'S', 'H', 'R', 'L', 'Y', '1'}
+
+// hello flags
+const (
+	helloNew    byte = 0
+	helloResume byte = 1
+)
+
+// welcome status
+const (
+	welcomeOK      byte = 0
+	welcomeUnknown byte = 1
+)
+
+// ErrUnknownSession is returned when the relay does not know the session to resume, i.e: it expired
+var ErrUnknownSession = errors.New("unknown relay session")
+
+// hello is sent by the client to open or resume a session
+type hello struct {
+	flag     byte
+	id       [16]byte
+	received uint64
+}
+
+func (h hello) marshal() []byte {
+	buf := make([]byte, 0, 33)
+	buf = append(buf, magic[:]...)
+	buf = append(buf, h.flag)
+	buf = append(buf, h.id[:]...)
+	return binary.BigEndian.AppendUint64(buf, h.received)
+}
+
+func readHello(r io.Reader) (hello, error) {
+	buf := make([]byte, 33)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return hello{}, err
+	}
+	if [8]byte(buf[:8]) != magic {
+		return hello{}, errors.New("not an assh relay client")
+	}
+	h := hello{flag: buf[8], received: binary.BigEndian.Uint64(buf[25:33])}
+	copy(h.id[:], buf[9:25])
+	return h, nil
+}
+
+// welcome is the answer of the relay to a hello
+type welcome struct {
+	status   byte
+	received uint64
+}
+
+func (w welcome) marshal() []byte {
+	buf := make([]byte, 0, 17)
+	buf = append(buf, magic[:]...)
+	buf = append(buf, w.status)
+	return binary.BigEndian.AppendUint64(buf, w.received)
+}
+
+func readWelcome(r io.Reader) (welcome, error) {
+	buf := make([]byte, 17)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return welcome{}, err
+	}
+	if [8]byte(buf[:8]) != magic {
+		return welcome{}, errors.New("not an assh relay")
+	}
+	return welcome{status: buf[8], received: binary.BigEndian.Uint64(buf[9:17])}, nil
+}
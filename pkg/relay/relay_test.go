@@ -0,0 +1,277 @@
+package relay
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_frame(t *testing.T) {
+	tt := map[string]*frame{
+		"Data":  {kind: frameData, seq: 42, ack: 12, payload: []byte("hello world")},
+		"Ack":   {kind: frameAck, ack: 1 << 40},
+		"Close": {kind: frameClose, seq: 7, ack: 3},
+	}
+	for name, want := range tt {
+		t.Run(name, func(t *testing.T) {
+			got, err := readFrame(bytes.NewReader(want.marshal()))
+			require.NoError(t, err)
+			require.Equal(t, want, got)
+		})
+	}
+
+	invalid := (&frame{kind: frameData}).marshal()
+	invalid[0] = 42
+	_, err := readFrame(bytes.NewReader(invalid))
+	require.Error(t, err)
+
+	tooLarge := (&frame{kind: frameData}).marshal()
+	tooLarge[17] = 0xff
+	_, err = readFrame(bytes.NewReader(tooLarge))
+	require.Error(t, err)
+}
+
+func Test_handshake(t *testing.T) {
+	want := hello{flag: helloResume, id: [16]byte{1, 2, 3}, received: 1234}
+	got, err := readHello(bytes.NewReader(want.marshal()))
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+
+	answer, err := readWelcome(bytes.NewReader(welcome{status: welcomeUnknown, received: 5}.marshal()))
+	require.NoError(t, err)
+	require.Equal(t, welcome{status: welcomeUnknown, received: 5}, answer)
+
+	_, err = readHello(bytes.NewReader(make([]byte, 33)))
+	require.Error(t, err)
+}
+
+// listen starts a TCP listener closed at the end of the test
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	return listener
+}
+
+// echoTarget accepts connections echoing their input, the closed connections are sent on the returned channel
+func echoTarget(t *testing.T) (func() (net.Conn, error), <-chan struct{}) {
+	listener := listen(t)
+	closed := make(chan struct{}, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+				_ = conn.Close()
+				closed <- struct{}{}
+			}()
+		}
+	}()
+	return func() (net.Conn, error) { return net.Dial("tcp", listener.Addr().String()) }, closed
+}
+
+// flakyDialer dials address and allows the test to break the current connection or to refuse the next ones
+type flakyDialer struct {
+	mu      sync.Mutex
+	address string
+	refuse  bool
+	conns   []net.Conn
+	dials   int
+}
+
+func (d *flakyDialer) dial() (net.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dials++
+	if d.refuse {
+		return nil, errors.New("network is down")
+	}
+	conn, err := net.Dial("tcp", d.address)
+	if err == nil {
+		d.conns = append(d.conns, conn)
+	}
+	return conn, err
+}
+
+func (d *flakyDialer) breakConnection() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, conn := range d.conns {
+		_ = conn.Close()
+	}
+	d.conns = nil
+}
+
+func (d *flakyDialer) setRefuse(refuse bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.refuse = refuse
+}
+
+func startServer(t *testing.T, server *Server) string {
+	listener := listen(t)
+	go func() { _ = server.Serve(listener) }()
+	return listener.Addr().String()
+}
+
+func Test_Session_Reconnect(t *testing.T) {
+	target, _ := echoTarget(t)
+	dialer := &flakyDialer{address: startServer(t, &Server{Dial: target, Timeout: time.Minute})}
+
+	session, err := Dial(dialer.dial, time.Minute)
+	require.NoError(t, err)
+
+	data := make([]byte, 2*1024*1024)
+	_, err = rand.Read(data)
+	require.NoError(t, err)
+
+	received := make(chan []byte)
+	go func() {
+		got := make([]byte, len(data))
+		_, err := io.ReadFull(session, got)
+		require.NoError(t, err)
+		received <- got
+	}()
+
+	// the connection is broken several times while the data is sent
+	chunk := len(data) / 8
+	for i := 0; i < len(data); i += chunk {
+		_, err := session.Write(data[i : i+chunk])
+		require.NoError(t, err)
+		if i%(2*chunk) == 0 {
+			dialer.breakConnection()
+		}
+	}
+
+	select {
+	case got := <-received:
+		require.True(t, bytes.Equal(data, got), "the data is received once and in order")
+	case <-time.After(30 * time.Second):
+		t.Fatal("timeout")
+	}
+	dialer.mu.Lock()
+	require.Greater(t, dialer.dials, 1)
+	dialer.mu.Unlock()
+	require.NoError(t, session.Close())
+	require.NoError(t, session.Err())
+
+	_, err = session.Write([]byte("closed"))
+	require.ErrorIs(t, err, ErrClosed)
+}
+
+func Test_Session_Close(t *testing.T) {
+	target, closed := echoTarget(t)
+	server := &Server{Dial: target, Timeout: time.Minute}
+	dialer := &flakyDialer{address: startServer(t, server)}
+
+	session, err := Dial(dialer.dial, time.Minute)
+	require.NoError(t, err)
+	_, err = session.Write([]byte("ping"))
+	require.NoError(t, err)
+	got := make([]byte, 4)
+	_, err = io.ReadFull(session, got)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(got))
+	require.Equal(t, 1, server.Sessions())
+
+	// closing the session closes the connection to the target
+	require.NoError(t, session.Close())
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the target connection is not closed")
+	}
+	require.Eventually(t, func() bool { return server.Sessions() == 0 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func Test_Session_Expire(t *testing.T) {
+	target, closed := echoTarget(t)
+	server := &Server{Dial: target, Timeout: 100 * time.Millisecond}
+	dialer := &flakyDialer{address: startServer(t, server)}
+
+	session, err := Dial(dialer.dial, 500*time.Millisecond)
+	require.NoError(t, err)
+
+	// the network stays down: the relay forgets the session, the client gives up
+	dialer.setRefuse(true)
+	dialer.breakConnection()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the target connection is not closed")
+	}
+	select {
+	case <-session.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the session did not fail")
+	}
+	require.Error(t, session.Err())
+	_, err = session.Read(make([]byte, 1))
+	require.Error(t, err)
+}
+
+func Test_Session_Unknown(t *testing.T) {
+	target, _ := echoTarget(t)
+	dialer := &flakyDialer{address: startServer(t, &Server{Dial: target, Timeout: time.Minute})}
+
+	session, err := Dial(dialer.dial, time.Minute)
+	require.NoError(t, err)
+
+	// the relay restarted, it cannot resume the session
+	dialer.mu.Lock()
+	dialer.address = startServer(t, &Server{Dial: target, Timeout: time.Minute})
+	dialer.mu.Unlock()
+	dialer.breakConnection()
+
+	select {
+	case <-session.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the session did not fail")
+	}
+	require.ErrorIs(t, session.Err(), ErrUnknownSession)
+}
+
+func Test_Server_Duplicate(t *testing.T) {
+	target, _ := echoTarget(t)
+	// both clients are dialing the target before any of them registers the session
+	dialing := sync.WaitGroup{}
+	dialing.Add(2)
+	address := startServer(t, &Server{Dial: func() (net.Conn, error) {
+		dialing.Done()
+		dialing.Wait()
+		return target()
+	}, Timeout: time.Minute})
+
+	id := [16]byte{4, 2}
+	answers := make(chan byte, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			conn, err := net.Dial("tcp", address)
+			if err != nil {
+				answers <- 0xff
+				return
+			}
+			defer conn.Close()
+			_, _ = conn.Write(hello{flag: helloNew, id: id}.marshal())
+			answer, err := readWelcome(conn)
+			if err != nil {
+				answers <- 0xff
+				return
+			}
+			answers <- answer.status
+		}()
+	}
+	statuses := []byte{<-answers, <-answers}
+	require.ElementsMatch(t, []byte{welcomeOK, welcomeUnknown}, statuses)
+}
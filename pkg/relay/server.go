@@ -0,0 +1,164 @@
+package relay
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrExpired is the error of a session not resumed before the timeout of the relay
+var ErrExpired = errors.New("relay session expired")
+
+// Server relays the sessions to a target, i.e: the local SSH server
+type Server struct {
+	// Dial opens the connection to the target of a new session
+	Dial func() (net.Conn, error)
+	// Timeout is the time a disconnected session is kept, waiting for the client to resume it
+	Timeout time.Duration
+
+	mu       sync.Mutex
+	sessions map[[16]byte]*Session
+}
+
+// Serve accepts the relay clients on listener
+func (s *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+// Sessions returns the number of sessions currently relayed
+func (s *Server) Sessions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+func (s *Server) handle(conn net.Conn) {
+	_ = conn.SetDeadline(time.Now().Add(ioTimeout))
+	greeting, err := readHello(conn)
+	if err != nil {
+		logger().Debug("invalid relay handshake", zap.Stringer("remote", conn.RemoteAddr()), zap.Error(err))
+		_ = conn.Close()
+		return
+	}
+	sessionLogger := logger().With(zap.String("session", hex.EncodeToString(greeting.id[:])), zap.Stringer("remote", conn.RemoteAddr()))
+
+	s.mu.Lock()
+	if s.sessions == nil {
+		s.sessions = map[[16]byte]*Session{}
+	}
+	session, found := s.sessions[greeting.id]
+	s.mu.Unlock()
+
+	switch {
+	case greeting.flag == helloResume && found:
+		sessionLogger.Info("resuming relay session")
+	case greeting.flag == helloNew && !found:
+		target, err := s.Dial()
+		if err != nil {
+			sessionLogger.Warn("failed to dial relay target", zap.Error(err))
+			_ = conn.Close()
+			return
+		}
+		// another client may have opened the same session while dialing
+		s.mu.Lock()
+		_, found = s.sessions[greeting.id]
+		if !found {
+			session = newSession(greeting.id)
+			s.sessions[greeting.id] = session
+		}
+		s.mu.Unlock()
+		if found {
+			sessionLogger.Warn("refusing duplicate relay session")
+			_ = target.Close()
+			_, _ = conn.Write(welcome{status: welcomeUnknown}.marshal())
+			_ = conn.Close()
+			return
+		}
+		sessionLogger.Info("new relay session")
+		go s.relay(session, target, sessionLogger)
+	default:
+		sessionLogger.Info("refusing relay session", zap.Bool("resume", greeting.flag == helloResume))
+		_, _ = conn.Write(welcome{status: welcomeUnknown}.marshal())
+		_ = conn.Close()
+		return
+	}
+
+	session.mu.Lock()
+	answer := welcome{status: welcomeOK, received: session.received}
+	session.mu.Unlock()
+	if _, err := conn.Write(answer.marshal()); err != nil {
+		sessionLogger.Debug("failed to answer relay client", zap.Error(err))
+		_ = conn.Close()
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+	if err := session.attach(conn, greeting.received); err != nil {
+		sessionLogger.Warn("failed to attach relay session", zap.Error(err))
+		_ = conn.Close()
+	}
+}
+
+// relay copies the data between the session and the target until one of them is closed
+func (s *Server) relay(session *Session, target net.Conn, sessionLogger *zap.Logger) {
+	go s.expire(session)
+
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(target, session)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(session, target)
+		done <- struct{}{}
+	}()
+	<-done
+
+	_ = target.Close()
+	_ = session.Close()
+	<-done
+
+	s.mu.Lock()
+	delete(s.sessions, session.id)
+	s.mu.Unlock()
+	sessionLogger.Info("relay session finished", zap.NamedError("reason", session.Err()))
+}
+
+// expire fails the session once it stays disconnected for longer than the timeout
+func (s *Server) expire(session *Session) {
+	for {
+		select {
+		case <-session.disconnected:
+		case <-session.Done():
+			return
+		}
+		for {
+			session.mu.Lock()
+			remaining := s.Timeout - time.Since(session.detachedAt)
+			connected := session.conn != nil
+			session.mu.Unlock()
+			if connected {
+				break
+			}
+			if remaining <= 0 {
+				session.fail(ErrExpired)
+				return
+			}
+			select {
+			case <-time.After(remaining):
+			case <-session.Done():
+				return
+			}
+		}
+	}
+}
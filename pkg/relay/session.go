@@ -0,0 +1,396 @@
+package relay
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// heartbeatInterval is the interval between two acknowledgements sent on an idle connection
+	heartbeatInterval = time.Second
+	// deadTimeout is the duration without receiving any frame after which a connection is considered dead
+	deadTimeout = 10 * time.Second
+	// ioTimeout bounds the writes and the handshakes
+	ioTimeout = 10 * time.Second
+	// closeTimeout is the time Close waits for the close frame to be acknowledged
+	closeTimeout = 2 * time.Second
+)
+
+const (
+	// maxReplay is the amount of unacknowledged bytes after which the writes block
+	maxReplay = 4 * 1024 * 1024
+	// ackThreshold is the amount of received bytes after which an acknowledgement is sent without waiting for the heartbeat
+	ackThreshold = maxReplay / 4
+	// maxIncoming is the amount of received bytes not read yet after which the connection is not read anymore
+	maxIncoming = 4 * 1024 * 1024
+)
+
+// ErrClosed is returned when using a closed session
+var ErrClosed = errors.New("relay session closed")
+
+// Session is a stream surviving the loss of its underlying connection
+type Session struct {
+	id [16]byte
+
+	// writeMu keeps the frames in order on the connection
+	writeMu sync.Mutex
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// conn is the current connection, nil while disconnected
+	conn net.Conn
+	// gen identifies the current connection, incremented on each attach
+	gen int
+	// detachedAt is the time of the last disconnection
+	detachedAt time.Time
+	// sent and received are the sequence numbers of the last frame sent and of the last frame received in order
+	sent     uint64
+	received uint64
+	// unacked is the amount of bytes received since the last acknowledgement sent
+	unacked     int
+	replay      []*frame
+	replayBytes int
+	incoming    bytes.Buffer
+	// remoteClosed is set once the close frame of the other side is received
+	remoteClosed bool
+	closed       bool
+	err          error
+
+	// disconnected is notified each time the current connection is lost
+	disconnected chan struct{}
+	// ackNeeded asks the heartbeat to acknowledge the received frames without waiting
+	ackNeeded chan struct{}
+	// done is closed when the session is closed or failed
+	done chan struct{}
+}
+
+func newSession(id [16]byte) *Session {
+	s := &Session{
+		id:           id,
+		disconnected: make(chan struct{}, 1),
+		ackNeeded:    make(chan struct{}, 1),
+		done:         make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// Read reads the data sent by the other side, io.EOF is returned once it closed the session
+func (s *Session) Read(buf []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.incoming.Len() == 0 {
+		switch {
+		case s.remoteClosed:
+			return 0, io.EOF
+		case s.err != nil:
+			return 0, s.err
+		case s.closed:
+			return 0, ErrClosed
+		}
+		s.cond.Wait()
+	}
+	n, _ := s.incoming.Read(buf)
+	// the connection may wait for room in the incoming buffer
+	s.cond.Broadcast()
+	return n, nil
+}
+
+// Write sends data to the other side, blocking while too much data is not acknowledged
+func (s *Session) Write(buf []byte) (int, error) {
+	written := 0
+	for len(buf) > 0 {
+		chunk := buf
+		if len(chunk) > maxPayload {
+			chunk = chunk[:maxPayload]
+		}
+
+		s.mu.Lock()
+		for s.replayBytes >= maxReplay && s.usable() {
+			s.cond.Wait()
+		}
+		err := s.failure()
+		s.mu.Unlock()
+		if err != nil {
+			return written, err
+		}
+
+		payload := make([]byte, len(chunk))
+		copy(payload, chunk)
+		if err := s.queue(frameData, payload); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		buf = buf[len(chunk):]
+	}
+	return written, nil
+}
+
+// Close sends a close frame to the other side, waits shortly for its acknowledgement and releases the connection
+func (s *Session) Close() error {
+	s.mu.Lock()
+	if s.closed || s.err != nil {
+		s.mu.Unlock()
+		return nil
+	}
+	s.mu.Unlock()
+
+	if err := s.queue(frameClose, nil); err == nil {
+		deadline := time.AfterFunc(closeTimeout, func() {
+			s.mu.Lock()
+			s.cond.Broadcast()
+			s.mu.Unlock()
+		})
+		start := time.Now()
+		s.mu.Lock()
+		for len(s.replay) > 0 && s.conn != nil && s.err == nil && time.Since(start) < closeTimeout {
+			s.cond.Wait()
+		}
+		s.mu.Unlock()
+		deadline.Stop()
+	}
+
+	s.shutdown(nil)
+	return nil
+}
+
+// Done returns a channel closed when the session is closed or failed
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the error that made the session fail, nil if it is still usable or was closed
+func (s *Session) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// fail stops the session with err, the pending and next reads and writes return err
+func (s *Session) fail(err error) {
+	s.shutdown(err)
+}
+
+func (s *Session) shutdown(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || s.err != nil {
+		return
+	}
+	if err != nil {
+		s.err = err
+	} else {
+		s.closed = true
+	}
+	if s.conn != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+	s.gen++
+	close(s.done)
+	s.cond.Broadcast()
+}
+
+// usable returns true if the session is neither closed nor failed, the lock must be held
+func (s *Session) usable() bool {
+	return !s.closed && s.err == nil
+}
+
+// failure returns the error of a closed or failed session, the lock must be held
+func (s *Session) failure() error {
+	switch {
+	case s.err != nil:
+		return s.err
+	case s.closed:
+		return ErrClosed
+	}
+	return nil
+}
+
+// connected returns true if the session currently has a connection
+func (s *Session) connected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn != nil
+}
+
+// queue numbers a frame, keeps it until acknowledged and sends it on the current connection if any
+func (s *Session) queue(kind byte, payload []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.mu.Lock()
+	if err := s.failure(); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	s.sent++
+	f := &frame{kind: kind, seq: s.sent, payload: payload}
+	s.replay = append(s.replay, f)
+	s.replayBytes += len(payload)
+	conn, gen := s.conn, s.gen
+	s.mu.Unlock()
+
+	if conn != nil {
+		s.send(conn, gen, f)
+	}
+	return nil
+}
+
+// send writes a frame on conn, detaching it on failure; the write lock must be held
+func (s *Session) send(conn net.Conn, gen int, f *frame) {
+	s.mu.Lock()
+	f.ack = s.received
+	s.unacked = 0
+	s.mu.Unlock()
+
+	_ = conn.SetWriteDeadline(time.Now().Add(ioTimeout))
+	if _, err := conn.Write(f.marshal()); err != nil {
+		s.detach(gen, err)
+	}
+}
+
+// acknowledge drops the frames received by the other side, the lock must be held
+func (s *Session) acknowledge(ack uint64) {
+	dropped := 0
+	for dropped < len(s.replay) && s.replay[dropped].seq <= ack {
+		s.replayBytes -= len(s.replay[dropped].payload)
+		dropped++
+	}
+	if dropped > 0 {
+		s.replay = s.replay[dropped:]
+		s.cond.Broadcast()
+	}
+}
+
+// attach resumes the session on conn; peerReceived is the last frame received by the other side,
+// the following ones are sent again
+func (s *Session) attach(conn net.Conn, peerReceived uint64) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.mu.Lock()
+	if err := s.failure(); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if peerReceived > s.sent {
+		s.mu.Unlock()
+		return errors.New("the other side received frames never sent")
+	}
+	if s.conn != nil {
+		// the previous connection is dead, but was not detected yet
+		_ = s.conn.Close()
+	}
+	s.gen++
+	s.conn = conn
+	gen := s.gen
+	s.acknowledge(peerReceived)
+	pending := make([]*frame, len(s.replay))
+	copy(pending, s.replay)
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	// the other side also sends its pending frames, they are read meanwhile
+	go s.readLoop(conn, gen)
+	go s.heartbeat(conn, gen)
+	for _, f := range pending {
+		s.send(conn, gen, f)
+	}
+	return nil
+}
+
+// detach forgets the connection identified by gen after an error
+func (s *Session) detach(gen int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gen != gen || s.conn == nil {
+		return
+	}
+	logger().Debug("relay connection lost", zap.Error(err))
+	_ = s.conn.Close()
+	s.conn = nil
+	s.detachedAt = time.Now()
+	select {
+	case s.disconnected <- struct{}{}:
+	default:
+	}
+	s.cond.Broadcast()
+}
+
+func (s *Session) readLoop(conn net.Conn, gen int) {
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(deadTimeout))
+		f, err := readFrame(conn)
+		if err != nil {
+			s.detach(gen, err)
+			return
+		}
+
+		s.mu.Lock()
+		if s.gen != gen {
+			s.mu.Unlock()
+			return
+		}
+		s.acknowledge(f.ack)
+		if f.kind != frameAck {
+			switch {
+			case f.seq <= s.received:
+				// sent again after a reconnection, already received
+			case f.seq > s.received+1:
+				s.mu.Unlock()
+				s.detach(gen, errors.New("missing relay frames"))
+				return
+			default:
+				s.received = f.seq
+				s.unacked += len(f.payload) + 1
+				if f.kind == frameClose {
+					s.remoteClosed = true
+				} else {
+					s.incoming.Write(f.payload)
+				}
+				if s.unacked >= ackThreshold || f.kind == frameClose {
+					select {
+					case s.ackNeeded <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}
+		s.cond.Broadcast()
+		// stop reading until the received data is consumed, the other side is slowed down by TCP
+		for s.incoming.Len() >= maxIncoming && s.gen == gen {
+			s.cond.Wait()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// heartbeat acknowledges the received frames regularly, proving the connection is alive
+func (s *Session) heartbeat(conn net.Conn, gen int) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.ackNeeded:
+		case <-s.done:
+			return
+		}
+		s.mu.Lock()
+		current := s.gen == gen
+		s.mu.Unlock()
+		if !current {
+			return
+		}
+		s.writeMu.Lock()
+		s.send(conn, gen, &frame{kind: frameAck})
+		s.writeMu.Unlock()
+	}
+}
@@ -0,0 +1 @@
+package socks // import "moul.io/assh/v2/pkg/socks"
@@ -0,0 +1,237 @@
+package socks
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// defaultHandshakeTimeout is the time a client has to send its request
+const defaultHandshakeTimeout = 10 * time.Second
+
+const (
+	socksVersion = 5
+
+	methodNoAuth       = 0
+	methodNoAcceptable = 0xff
+
+	commandConnect = 1
+
+	addressIPv4   = 1
+	addressDomain = 3
+	addressIPv6   = 4
+)
+
+// the reply codes of RFC 1928
+const (
+	replySucceeded               = 0
+	replyGeneralFailure          = 1
+	replyNetworkUnreachable      = 3
+	replyHostUnreachable         = 4
+	replyConnectionRefused       = 5
+	replyCommandNotSupported     = 7
+	replyAddressTypeNotSupported = 8
+)
+
+// Dialer connects to the target requested by a client, host is a name or an IP address
+type Dialer func(ctx context.Context, host string, port string) (net.Conn, error)
+
+// Server is a SOCKS5 server (RFC 1928) supporting the CONNECT command without authentication,
+// the targets are reached with Dial
+type Server struct {
+	Dial Dialer
+	// HandshakeTimeout is the time a client has to send its request, 10 seconds if zero
+	HandshakeTimeout time.Duration
+}
+
+// Serve accepts the SOCKS clients on listener
+func (s *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+// request is the CONNECT request of a client
+type request struct {
+	host string
+	port string
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	clientLogger := logger().With(zap.Stringer("client", conn.RemoteAddr()))
+
+	timeout := s.HandshakeTimeout
+	if timeout == 0 {
+		timeout = defaultHandshakeTimeout
+	}
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	req, code, err := readRequest(conn)
+	if err != nil {
+		clientLogger.Debug("invalid SOCKS request", zap.Error(err))
+		if code != replySucceeded {
+			_ = writeReply(conn, code, nil)
+		}
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	target := net.JoinHostPort(req.host, req.port)
+	upstream, err := s.Dial(context.Background(), req.host, req.port)
+	if err != nil {
+		clientLogger.Warn("Failed to reach the SOCKS target", zap.String("target", target), zap.Error(err))
+		_ = writeReply(conn, dialErrorCode(err), nil)
+		return
+	}
+	defer upstream.Close()
+	if err := writeReply(conn, replySucceeded, upstream.LocalAddr()); err != nil {
+		return
+	}
+	clientLogger.Debug("Forwarding SOCKS connection", zap.String("target", target))
+
+	sent, received := pipe(conn, upstream)
+	clientLogger.Debug(
+		"SOCKS connection closed",
+		zap.String("target", target),
+		zap.Int64("sent", sent),
+		zap.Int64("received", received),
+	)
+}
+
+// readRequest reads the greeting and the request of a client, the returned code is the reply to send on error
+func readRequest(r io.ReadWriter) (request, byte, error) {
+	req := request{}
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return req, replySucceeded, err
+	}
+	if header[0] != socksVersion {
+		return req, replySucceeded, fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return req, replySucceeded, err
+	}
+	method := byte(methodNoAcceptable)
+	for _, m := range methods {
+		if m == methodNoAuth {
+			method = methodNoAuth
+		}
+	}
+	if _, err := r.Write([]byte{socksVersion, method}); err != nil {
+		return req, replySucceeded, err
+	}
+	if method == methodNoAcceptable {
+		return req, replySucceeded, errors.New("the client does not support the 'no authentication' method")
+	}
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return req, replySucceeded, err
+	}
+	if head[0] != socksVersion {
+		return req, replySucceeded, fmt.Errorf("unsupported SOCKS version %d", head[0])
+	}
+
+	switch head[3] {
+	case addressIPv4, addressIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if head[3] == addressIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return req, replySucceeded, err
+		}
+		req.host = ip.String()
+	case addressDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return req, replySucceeded, err
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return req, replySucceeded, err
+		}
+		req.host = string(name)
+	default:
+		return req, replyAddressTypeNotSupported, fmt.Errorf("unsupported address type %d", head[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return req, replySucceeded, err
+	}
+	req.port = strconv.Itoa(int(binary.BigEndian.Uint16(port)))
+
+	if head[1] != commandConnect {
+		return req, replyCommandNotSupported, fmt.Errorf("unsupported command %d", head[1])
+	}
+	return req, replySucceeded, nil
+}
+
+// writeReply sends the reply to the request, with the address bound to reach the target
+func writeReply(w io.Writer, code byte, bound net.Addr) error {
+	reply := []byte{socksVersion, code, 0, addressIPv4, 0, 0, 0, 0, 0, 0}
+	if addr, ok := bound.(*net.TCPAddr); ok {
+		if ip := addr.IP.To4(); ip != nil {
+			copy(reply[4:8], ip)
+		} else if ip := addr.IP.To16(); ip != nil {
+			reply = append([]byte{socksVersion, code, 0, addressIPv6}, ip...)
+			reply = append(reply, 0, 0)
+		}
+		binary.BigEndian.PutUint16(reply[len(reply)-2:], uint16(addr.Port))
+	}
+	_, err := w.Write(reply)
+	return err
+}
+
+// dialErrorCode returns the reply code of a dial error
+func dialErrorCode(err error) byte {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return replyConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return replyNetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.As(err, &netErr) && netErr.Timeout():
+		return replyHostUnreachable
+	default:
+		return replyGeneralFailure
+	}
+}
+
+// pipe forwards the data between client and upstream until both directions are closed, the end of a direction is
+// propagated with a half-close when supported
+func pipe(client net.Conn, upstream net.Conn) (sent int64, received int64) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sent, _ = io.Copy(upstream, client)
+		closeWrite(upstream)
+	}()
+	received, _ = io.Copy(client, upstream)
+	closeWrite(client)
+	wg.Wait()
+	return sent, received
+}
+
+// closeWrite closes the write side of conn, or the whole connection if half-closes are not supported
+func closeWrite(conn net.Conn) {
+	if closer, ok := conn.(interface{ CloseWrite() error }); ok && closer.CloseWrite() == nil {
+		return
+	}
+	_ = conn.Close()
+}
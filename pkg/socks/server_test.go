@@ -0,0 +1,145 @@
+package socks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
+)
+
+// echoServer answers the input once its client closed its write side
+func echoServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				input, _ := io.ReadAll(conn)
+				_, _ = conn.Write(append([]byte("echo: "), input...))
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// startServer starts a SOCKS server whose targets are mapped by the routes
+func startServer(t *testing.T, routes map[string]string) (string, *[]string) {
+	var mu sync.Mutex
+	requested := []string{}
+	server := &Server{
+		Dial: func(ctx context.Context, host string, port string) (net.Conn, error) {
+			mu.Lock()
+			requested = append(requested, net.JoinHostPort(host, port))
+			mu.Unlock()
+			address, ok := routes[host]
+			if !ok {
+				return nil, errors.New("no route")
+			}
+			return net.Dial("tcp", address)
+		},
+		HandshakeTimeout: time.Second,
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() { _ = server.Serve(listener) }()
+	return listener.Addr().String(), &requested
+}
+
+func Test_Server(t *testing.T) {
+	target := echoServer(t)
+	address, requested := startServer(t, map[string]string{
+		"web.internal": target,
+		"10.0.0.1":     target,
+		"::1":          target,
+		"refused":      "127.0.0.1:1",
+	})
+	dialer, err := proxy.SOCKS5("tcp", address, nil, nil)
+	require.NoError(t, err)
+
+	tt := map[string]struct {
+		address string
+		wantErr string
+	}{
+		"hostname":     {address: "web.internal:8080"},
+		"ipv4":         {address: "10.0.0.1:80"},
+		"ipv6":         {address: "[::1]:443"},
+		"refused":      {address: "refused:22", wantErr: "connection refused"},
+		"unknown host": {address: "unknown:22", wantErr: "general SOCKS server failure"},
+	}
+	for name, test := range tt {
+		t.Run(name, func(t *testing.T) {
+			conn, err := dialer.Dial("tcp", test.address)
+			if test.wantErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			defer conn.Close()
+
+			// the end of the request is forwarded with a half-close
+			_, err = conn.Write([]byte("hello"))
+			require.NoError(t, err)
+			require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+			answer, err := io.ReadAll(conn)
+			require.NoError(t, err)
+			require.Equal(t, "echo: hello", string(answer))
+		})
+	}
+	require.Contains(t, *requested, "web.internal:8080")
+	require.Contains(t, *requested, "[::1]:443")
+}
+
+func Test_Server_InvalidRequests(t *testing.T) {
+	address, _ := startServer(t, map[string]string{})
+
+	tt := map[string]struct {
+		request []byte
+		answer  []byte
+	}{
+		"authentication required": {request: []byte{5, 1, 2}, answer: []byte{5, 0xff}},
+		"bind command":            {request: []byte{5, 1, 0, 5, 2, 0, 1, 127, 0, 0, 1, 0, 80}, answer: []byte{5, 0, 5, 7, 0, 1, 0, 0, 0, 0, 0, 0}},
+		"invalid address type":    {request: []byte{5, 1, 0, 5, 1, 0, 9}, answer: []byte{5, 0, 5, 8, 0, 1, 0, 0, 0, 0, 0, 0}},
+		"socks4":                  {request: []byte{4, 1, 0, 80, 127, 0, 0, 1, 0}, answer: []byte{}},
+		"incomplete request":      {request: []byte{5, 1, 0, 5}, answer: []byte{5, 0}},
+	}
+	for name, test := range tt {
+		t.Run(name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", address)
+			require.NoError(t, err)
+			defer conn.Close()
+			_, err = conn.Write(test.request)
+			require.NoError(t, err)
+			// the server closes the connection, at the latest after the handshake timeout; the connection may be
+			// reset as the request is not completely read
+			answer, _ := io.ReadAll(conn)
+			require.Equal(t, test.answer, answer)
+		})
+	}
+}
+
+func Test_dialErrorCode(t *testing.T) {
+	_, err := net.Dial("tcp", "127.0.0.1:1")
+	require.Equal(t, byte(replyConnectionRefused), dialErrorCode(err))
+	require.Equal(t, byte(replyGeneralFailure), dialErrorCode(errors.New("no route")))
+	require.Equal(t, byte(replyHostUnreachable), dialErrorCode(&net.OpError{Op: "dial", Err: timeoutError{}}))
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
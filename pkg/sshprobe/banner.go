@@ -0,0 +1,79 @@
+package sshprobe
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maxBannerBytes is the maximum size of the identification lines (RFC 4253 section 4.2)
+const maxBannerBytes = 255
+
+// Banner is the identification string sent by an SSH server
+type Banner struct {
+	Raw             string
+	ProtoVersion    string
+	SoftwareVersion string
+	Software        string
+	Version         string
+	Comments        string
+}
+
+// String returns the raw identification string
+func (b *Banner) String() string { return b.Raw }
+
+// ParseBanner parses an identification string:
+// SSH-protoversion-softwareversion SP comments
+func ParseBanner(line string) (*Banner, error) {
+	line = strings.TrimRight(line, "\r\n")
+	if !strings.HasPrefix(line, "SSH-") {
+		return nil, fmt.Errorf("invalid SSH identification string %q", line)
+	}
+
+	banner := Banner{Raw: line}
+	ident := line
+	if idx := strings.Index(line, " "); idx != -1 {
+		ident = line[:idx]
+		banner.Comments = line[idx+1:]
+	}
+
+	parts := strings.SplitN(ident, "-", 3)
+	if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("invalid SSH identification string %q", line)
+	}
+	banner.ProtoVersion = parts[1]
+	banner.SoftwareVersion = parts[2]
+
+	// most implementations use a "name_version" format, i.e: OpenSSH_8.9p1
+	banner.Software = banner.SoftwareVersion
+	if idx := strings.Index(banner.SoftwareVersion, "_"); idx != -1 {
+		banner.Software = banner.SoftwareVersion[:idx]
+		banner.Version = banner.SoftwareVersion[idx+1:]
+	}
+
+	return &banner, nil
+}
+
+// ReadBanner reads the server identification string, the lines sent before are ignored
+func ReadBanner(r *bufio.Reader) (*Banner, error) {
+	line := make([]byte, 0, 64)
+	for read := 0; read < maxBannerBytes; read++ {
+		c, err := r.ReadByte()
+		if err != nil {
+			if len(line) > 0 {
+				return nil, fmt.Errorf("incomplete SSH identification string %q: %w", line, err)
+			}
+			return nil, err
+		}
+		if c != '\n' {
+			line = append(line, c)
+			continue
+		}
+		if strings.HasPrefix(string(line), "SSH-") {
+			return ParseBanner(string(line))
+		}
+		line = line[:0]
+	}
+	return nil, errors.New("no SSH identification string received")
+}
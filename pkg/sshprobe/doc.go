@@ -0,0 +1 @@
+package sshprobe // import "moul.io/assh/v2/pkg/sshprobe"
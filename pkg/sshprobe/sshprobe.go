@@ -0,0 +1,164 @@
+package sshprobe
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// ClientVersion is the identification string sent to the servers when completing the key exchange
+const ClientVersion = "SSH-2.0-assh_probe"
+
+// KnownHostsStatus is the result of the comparison of a host key with the known hosts files
+type KnownHostsStatus string
+
+// KnownHostsStatus values
+const (
+	KnownHostsUnchecked KnownHostsStatus = ""
+	KnownHostsMatch     KnownHostsStatus = "match"
+	KnownHostsUnknown   KnownHostsStatus = "unknown"
+	KnownHostsMismatch  KnownHostsStatus = "mismatch"
+	KnownHostsRevoked   KnownHostsStatus = "revoked"
+)
+
+// Options configures a probe
+type Options struct {
+	// KEX completes the key exchange to retrieve the server host key
+	KEX bool
+	// Address is the "host:port" used to lookup the host key in the known hosts files
+	Address string
+	// KnownHostsFiles are the files the host key is compared with, missing files are ignored
+	KnownHostsFiles []string
+	// Timeout bounds the whole probe, 0 disables it
+	Timeout time.Duration
+}
+
+// Result contains what a probe learned about an SSH server
+type Result struct {
+	Banner         *Banner
+	BannerDuration time.Duration
+	KEXDuration    time.Duration
+	HostKeyType    string
+	Fingerprint    string
+	KnownHosts     KnownHostsStatus
+}
+
+// errHostKeyReceived is used to interrupt the handshake as soon as the host key is verified,
+// so no authentication is ever attempted
+var errHostKeyReceived = errors.New("host key received")
+
+// Probe reads the identification string of the SSH server on the other side of conn
+// and optionally completes the key exchange to retrieve the host key.
+// The result may be partially filled when an error is returned.
+// conn is closed when the key exchange is attempted, the caller still owns it otherwise.
+func Probe(conn net.Conn, opts Options) (*Result, error) {
+	result := Result{}
+	if opts.Timeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(opts.Timeout)); err != nil {
+			logger().Debug("failed to set probe deadline", zap.Error(err))
+		}
+	}
+
+	start := time.Now()
+	reader := bufio.NewReader(conn)
+	banner, err := ReadBanner(reader)
+	result.BannerDuration = time.Since(start)
+	if err != nil {
+		return &result, fmt.Errorf("failed to read SSH banner: %w", err)
+	}
+	result.Banner = banner
+
+	if !opts.KEX {
+		return &result, nil
+	}
+
+	var hostKey ssh.PublicKey
+	config := &ssh.ClientConfig{
+		User:          "assh",
+		ClientVersion: ClientVersion,
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return errHostKeyReceived
+		},
+	}
+	// the identification string was already consumed, it is replayed to the ssh client
+	replayed := &replayConn{
+		Conn:   conn,
+		reader: io.MultiReader(strings.NewReader(banner.Raw+"\r\n"), reader),
+	}
+	start = time.Now()
+	_, _, _, err = ssh.NewClientConn(replayed, opts.Address, config)
+	result.KEXDuration = time.Since(start)
+	if !errors.Is(err, errHostKeyReceived) {
+		if err == nil {
+			err = errors.New("unexpected successful authentication")
+		}
+		return &result, fmt.Errorf("failed to complete key exchange: %w", err)
+	}
+
+	result.HostKeyType = hostKey.Type()
+	result.Fingerprint = ssh.FingerprintSHA256(hostKey)
+	if len(opts.KnownHostsFiles) > 0 {
+		result.KnownHosts = CheckKnownHosts(opts.KnownHostsFiles, opts.Address, hostKey)
+	}
+	return &result, nil
+}
+
+// CheckKnownHosts compares a host key with the entries of the known hosts files for address
+func CheckKnownHosts(files []string, address string, key ssh.PublicKey) KnownHostsStatus {
+	existing := make([]string, 0, len(files))
+	for _, file := range files {
+		if _, err := os.Stat(file); err == nil {
+			existing = append(existing, file)
+		}
+	}
+	if len(existing) == 0 {
+		return KnownHostsUnknown
+	}
+
+	callback, err := knownhosts.New(existing...)
+	if err != nil {
+		logger().Warn("failed to load known hosts", zap.Strings("files", existing), zap.Error(err))
+		return KnownHostsUnchecked
+	}
+
+	err = callback(address, addrString(address), key)
+	var keyErr *knownhosts.KeyError
+	var revokedErr *knownhosts.RevokedError
+	switch {
+	case err == nil:
+		return KnownHostsMatch
+	case errors.As(err, &revokedErr):
+		return KnownHostsRevoked
+	case errors.As(err, &keyErr) && len(keyErr.Want) > 0:
+		return KnownHostsMismatch
+	case errors.As(err, &keyErr):
+		return KnownHostsUnknown
+	default:
+		logger().Warn("failed to check known hosts", zap.String("address", address), zap.Error(err))
+		return KnownHostsUnchecked
+	}
+}
+
+// replayConn is a net.Conn reading from another reader
+type replayConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *replayConn) Read(b []byte) (int, error) { return c.reader.Read(b) }
+
+// addrString is a net.Addr for a "host:port" string
+type addrString string
+
+func (a addrString) Network() string { return "tcp" }
+func (a addrString) String() string  { return string(a) }
@@ -0,0 +1,205 @@
+package sshprobe
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func Test_ParseBanner(t *testing.T) {
+	type want struct {
+		banner *Banner
+		err    bool
+	}
+
+	tt := map[string]struct {
+		line string
+		want want
+	}{
+		"OpenSSH": {
+			line: "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.6\r\n",
+			want: want{banner: &Banner{
+				Raw:             "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.6",
+				ProtoVersion:    "2.0",
+				SoftwareVersion: "OpenSSH_8.9p1",
+				Software:        "OpenSSH",
+				Version:         "8.9p1",
+				Comments:        "Ubuntu-3ubuntu0.6",
+			}},
+		},
+		"NoVersion": {
+			line: "SSH-1.99-Go\n",
+			want: want{banner: &Banner{
+				Raw:             "SSH-1.99-Go",
+				ProtoVersion:    "1.99",
+				SoftwareVersion: "Go",
+				Software:        "Go",
+			}},
+		},
+		"ErrNotSSH": {
+			line: "HTTP/1.1 400 Bad Request\r\n",
+			want: want{err: true},
+		},
+		"ErrMissingSoftware": {
+			line: "SSH-2.0-\r\n",
+			want: want{err: true},
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			got, err := ParseBanner(tc.line)
+			if tc.want.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want.banner, got)
+		})
+	}
+}
+
+func Test_ReadBanner(t *testing.T) {
+	banner, err := ReadBanner(bufio.NewReader(strings.NewReader("hello\r\nworld\nSSH-2.0-dropbear_2022.83\r\n")))
+	require.NoError(t, err)
+	require.Equal(t, "dropbear", banner.Software)
+	require.Equal(t, "2022.83", banner.Version)
+
+	_, err = ReadBanner(bufio.NewReader(strings.NewReader(strings.Repeat("a", 1024))))
+	require.EqualError(t, err, "no SSH identification string received")
+}
+
+// newTestServer starts a local server handling each connection with handler
+func newTestServer(t *testing.T, handler func(net.Conn)) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handler(conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func newHostKey(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(private)
+	require.NoError(t, err)
+	return signer
+}
+
+func probe(t *testing.T, address string, opts Options) (*Result, error) {
+	t.Helper()
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer conn.Close()
+	opts.Address = address
+	return Probe(conn, opts)
+}
+
+func Test_Probe(t *testing.T) {
+	hostKey := newHostKey(t)
+	sshServer := newTestServer(t, func(conn net.Conn) {
+		config := &ssh.ServerConfig{NoClientAuth: true, ServerVersion: "SSH-2.0-assh_test_1.0"}
+		config.AddHostKey(hostKey)
+		_, _, _, _ = ssh.NewServerConn(conn, config)
+	})
+
+	t.Run("TCPOnly", func(t *testing.T) {
+		silent := newTestServer(t, func(conn net.Conn) { time.Sleep(time.Second) })
+		result, err := probe(t, silent, Options{Timeout: 50 * time.Millisecond})
+		require.Error(t, err)
+		require.Nil(t, result.Banner)
+		require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	})
+
+	t.Run("NotSSH", func(t *testing.T) {
+		http := newTestServer(t, func(conn net.Conn) {
+			_, _ = conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+		})
+		result, err := probe(t, http, Options{Timeout: time.Second})
+		require.Error(t, err)
+		require.Nil(t, result.Banner)
+	})
+
+	t.Run("BannerOnly", func(t *testing.T) {
+		result, err := probe(t, sshServer, Options{Timeout: time.Second})
+		require.NoError(t, err)
+		require.Equal(t, "assh", result.Banner.Software)
+		require.Equal(t, "test_1.0", result.Banner.Version)
+		require.Empty(t, result.Fingerprint)
+		require.Zero(t, result.KEXDuration)
+	})
+
+	t.Run("KEXFailure", func(t *testing.T) {
+		bannerOnly := newTestServer(t, func(conn net.Conn) {
+			_, _ = conn.Write([]byte("SSH-2.0-broken\r\n"))
+		})
+		result, err := probe(t, bannerOnly, Options{Timeout: time.Second, KEX: true})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "key exchange")
+		require.Equal(t, "broken", result.Banner.Software)
+		require.Empty(t, result.Fingerprint)
+	})
+
+	t.Run("KEX", func(t *testing.T) {
+		result, err := probe(t, sshServer, Options{Timeout: time.Second, KEX: true})
+		require.NoError(t, err)
+		require.Equal(t, ssh.KeyAlgoED25519, result.HostKeyType)
+		require.Equal(t, ssh.FingerprintSHA256(hostKey.PublicKey()), result.Fingerprint)
+		require.NotZero(t, result.KEXDuration)
+		require.Equal(t, KnownHostsUnchecked, result.KnownHosts)
+	})
+
+	t.Run("KnownHosts", func(t *testing.T) {
+		dir := t.TempDir()
+		writeKnownHosts := func(name string, address string, key ssh.PublicKey) string {
+			path := filepath.Join(dir, name)
+			line := knownhosts.Line([]string{knownhosts.Normalize(address)}, key) + "\n"
+			require.NoError(t, os.WriteFile(path, []byte(line), 0o600))
+			return path
+		}
+		matching := writeKnownHosts("matching", sshServer, hostKey.PublicKey())
+		mismatching := writeKnownHosts("mismatching", sshServer, newHostKey(t).PublicKey())
+		other := writeKnownHosts("other", "192.0.2.1:22", hostKey.PublicKey())
+
+		tt := map[string]struct {
+			files []string
+			want  KnownHostsStatus
+		}{
+			"Match":       {files: []string{matching}, want: KnownHostsMatch},
+			"Mismatch":    {files: []string{mismatching}, want: KnownHostsMismatch},
+			"Unknown":     {files: []string{other}, want: KnownHostsUnknown},
+			"MissingFile": {files: []string{filepath.Join(dir, "missing")}, want: KnownHostsUnknown},
+			"Multiple":    {files: []string{filepath.Join(dir, "missing"), other, matching}, want: KnownHostsMatch},
+		}
+		for name, tc := range tt {
+			t.Run(name, func(t *testing.T) {
+				result, err := probe(t, sshServer, Options{Timeout: time.Second, KEX: true, KnownHostsFiles: tc.files})
+				require.NoError(t, err)
+				require.Equal(t, tc.want, result.KnownHosts)
+			})
+		}
+	})
+}
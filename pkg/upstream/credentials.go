@@ -0,0 +1,87 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// PasswordEnv is the environment variable holding the password of the proxies, it can be specialized per proxy
+// host, i.e: ASSH_PROXY_PASSWORD_PROXY_EXAMPLE_COM for proxy.example.com
+const PasswordEnv = "ASSH_PROXY_PASSWORD"
+
+// keyringTimeout is the maximum time spent querying the keyring, it may wait for the user to unlock it
+const keyringTimeout = 30 * time.Second
+
+// lookupKeyring returns the password of user on the proxy from the keyring of the system, it is replaced by the tests
+var lookupKeyring = keyringPassword
+
+var unsafeEnvChars = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// credentials returns the user and the password used to authenticate on the proxy; the password is taken from the
+// URL, then from the environment, then from the keyring
+func credentials(u *url.URL) (string, string, error) {
+	if u.User == nil || u.User.Username() == "" {
+		return "", "", nil
+	}
+	user := u.User.Username()
+	if password, ok := u.User.Password(); ok {
+		return user, password, nil
+	}
+
+	hostEnv := PasswordEnv + "_" + strings.Trim(unsafeEnvChars.ReplaceAllString(strings.ToUpper(u.Hostname()), "_"), "_")
+	for _, name := range []string{hostEnv, PasswordEnv} {
+		if password, ok := os.LookupEnv(name); ok {
+			logger().Debug("Using the proxy password of the environment", zap.String("variable", name))
+			return user, password, nil
+		}
+	}
+
+	password, err := lookupKeyring(u.Scheme, u.Hostname(), user)
+	if err != nil {
+		return "", "", fmt.Errorf("no password for %s@%s, set it in %s or in the keyring: %w", user, u.Hostname(), hostEnv, err)
+	}
+	logger().Debug("Using the proxy password of the keyring")
+	return user, password, nil
+}
+
+// keyringPassword queries the keyring of the system: the macOS keychain, or the Secret Service (GNOME Keyring,
+// KWallet) through secret-tool
+func keyringPassword(scheme, host, user string) (string, error) {
+	var args []string
+	switch runtime.GOOS {
+	case "darwin":
+		args = []string{"security", "find-internet-password", "-s", host, "-a", user, "-w"}
+	case "windows":
+		return "", fmt.Errorf("the keyring is not supported on %s", runtime.GOOS)
+	default:
+		args = []string{"secret-tool", "lookup", "service", "assh", "protocol", scheme, "host", host, "user", user}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), keyringTimeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...) // #nosec
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("%s: %w: %s", args[0], err, message)
+		}
+		return "", fmt.Errorf("%s: %w", args[0], err)
+	}
+	password := strings.TrimRight(stdout.String(), "\r\n")
+	if password == "" {
+		return "", fmt.Errorf("%s: no password found", args[0])
+	}
+	return password, nil
+}
@@ -0,0 +1 @@
+package upstream // import "moul.io/assh/v2/pkg/upstream"
@@ -0,0 +1,201 @@
+package upstream
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/proxy"
+)
+
+// the supported proxy schemes, socks5h resolves the target on the proxy and socks5 resolves it locally
+const (
+	SchemeSOCKS5  = "socks5"
+	SchemeSOCKS5H = "socks5h"
+	SchemeHTTP    = "http"
+)
+
+var defaultPorts = map[string]string{
+	SchemeSOCKS5:  "1080",
+	SchemeSOCKS5H: "1080",
+	SchemeHTTP:    "80",
+}
+
+// IsProxy returns true if gateway is the URL of an upstream proxy instead of the name of an ssh gateway
+func IsProxy(gateway string) bool {
+	return strings.Contains(gateway, "://")
+}
+
+// Parse parses the URL of an upstream proxy: socks5://[user[:password]@]host[:port] or
+// http://[user[:password]@]host[:port]
+func Parse(gateway string) (*url.URL, error) {
+	u, err := url.Parse(gateway)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	port, ok := defaultPorts[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported proxy scheme %q, should be 'socks5', 'socks5h' or 'http'", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("missing proxy host in %q", Redact(gateway))
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("unexpected path or query in proxy URL %q", Redact(gateway))
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	return u, nil
+}
+
+// Redact returns gateway with its password masked, gateway itself if it is not an URL
+func Redact(gateway string) string {
+	if !IsProxy(gateway) {
+		return gateway
+	}
+	u, err := url.Parse(gateway)
+	if err != nil {
+		return gateway
+	}
+	return u.Redacted()
+}
+
+// Dial connects to address through the upstream proxy described by gateway, the connection to the proxy and the
+// handshake must succeed within timeout, disabled if zero
+func Dial(gateway string, address string, timeout time.Duration) (net.Conn, error) {
+	u, err := Parse(gateway)
+	if err != nil {
+		return nil, err
+	}
+	user, password, err := credentials(u)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	logger().Debug("Dialing through proxy", zap.String("proxy", u.Redacted()), zap.String("address", address))
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach the proxy: %w", err)
+	}
+	// the deadline interrupts the handshake, the context is only used by the dialers
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	switch u.Scheme {
+	case SchemeSOCKS5, SchemeSOCKS5H:
+		conn, err = socksConnect(ctx, conn, u, user, password, address)
+	case SchemeHTTP:
+		conn, err = httpConnect(conn, user, password, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// socksWithConn is implemented by the dialer returned by proxy.SOCKS5, it keeps the TCP connection to the proxy
+// to allow half-closes
+type socksWithConn interface {
+	DialWithConn(ctx context.Context, c net.Conn, network, address string) (net.Addr, error)
+}
+
+func socksConnect(ctx context.Context, conn net.Conn, u *url.URL, user, password, address string) (net.Conn, error) {
+	if u.Scheme == SchemeSOCKS5 {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		address = net.JoinHostPort(addrs[0], port)
+	}
+
+	var auth *proxy.Auth
+	if user != "" {
+		auth = &proxy.Auth{User: user, Password: password}
+	}
+	dialer, err := proxy.SOCKS5("tcp", u.Host, auth, nil)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if _, err := dialer.(socksWithConn).DialWithConn(ctx, conn, "tcp", address); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("socks5 proxy: %w", err)
+	}
+	return conn, nil
+}
+
+func httpConnect(conn net.Conn, user, password, address string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: http.Header{},
+	}
+	if user != "" {
+		token := base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+token)
+	}
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("http proxy: %w", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("http proxy: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("http proxy: %s", resp.Status)
+	}
+	if reader.Buffered() > 0 {
+		// the server already sent data after the answer of the proxy
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn returns the data read in advance before reading from the connection
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(buf []byte) (int, error) {
+	return c.reader.Read(buf)
+}
+
+// CloseWrite closes the write side of the underlying connection, if supported
+func (c *bufferedConn) CloseWrite() error {
+	if closer, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return closer.CloseWrite()
+	}
+	return fmt.Errorf("half-close is not supported by %T", c.Conn)
+}